@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// context is the number of unchanged lines shown around each change.
+const context = 3
+
+// An edit is a line of a diff: a line of the old text that is kept
+// (' '), deleted ('-'), or a line of the new text that is inserted ('+').
+type edit struct {
+	op   byte
+	line string
+}
+
+// diff returns a unified diff of the old and new texts, named oldName and
+// newName, or nil if they are the same.
+func diff(oldName string, old []byte, newName string, new []byte) []byte {
+	if bytes.Equal(old, new) {
+		return nil
+	}
+	edits := lineEdits(splitLines(old), splitLines(new))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", oldName, newName)
+	// the positions in edits, and the lines of the old and new texts,
+	// at which the current hunk starts
+	i, oldLine, newLine := 0, 1, 1
+	for i < len(edits) {
+		// skip to the next change, keeping its leading context
+		j := i
+		for j < len(edits) && edits[j].op == ' ' {
+			j++
+		}
+		if j == len(edits) {
+			break
+		}
+		start := j - context
+		if start < i {
+			start = i
+		}
+		oldLine += start - i
+		newLine += start - i
+
+		// extend the hunk while changes are within twice the context
+		end, same := j, 0
+		for k := j; k < len(edits); k++ {
+			if edits[k].op != ' ' {
+				end, same = k+1, 0
+				continue
+			}
+			if same++; same > 2*context {
+				break
+			}
+		}
+		stop := end + context
+		if stop > len(edits) {
+			stop = len(edits)
+		}
+
+		nOld, nNew := 0, 0
+		for _, e := range edits[start:stop] {
+			if e.op != '+' {
+				nOld++
+			}
+			if e.op != '-' {
+				nNew++
+			}
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(oldLine, nOld), hunkRange(newLine, nNew))
+		for _, e := range edits[start:stop] {
+			buf.WriteByte(e.op)
+			buf.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		oldLine += nOld
+		newLine += nNew
+		i = stop
+	}
+	return buf.Bytes()
+}
+
+// hunkRange returns the range of n lines from line, as it is written in
+// a hunk header.
+func hunkRange(line, n int) string {
+	switch n {
+	case 0:
+		return fmt.Sprintf("%d,0", line-1)
+	case 1:
+		return fmt.Sprint(line)
+	}
+	return fmt.Sprintf("%d,%d", line, n)
+}
+
+// splitLines splits text after each newline.
+func splitLines(text []byte) []string {
+	lines := strings.SplitAfter(string(text), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// lineEdits returns the edits that turn the lines a into the lines b,
+// keeping a longest common subsequence of them.
+func lineEdits(a, b []string) []edit {
+	// lcs[i][j] is the length of a longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i]})
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			edits = append(edits, edit{'-', a[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j]})
+			j++
+		}
+	}
+	return edits
+}
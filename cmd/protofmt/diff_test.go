@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{
+			name: "same",
+			old:  "a\nb\n",
+			new:  "a\nb\n",
+		},
+		{
+			name: "change",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			new:  "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			want: "--- old\n+++ new\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "hunks",
+			old:  "a\n1\n2\n3\n4\n5\n6\n7\n8\nb\n",
+			new:  "1\n2\n3\n4\n5\n6\n7\n8\nb\nc\n",
+			want: "--- old\n+++ new\n@@ -1,4 +1,3 @@\n-a\n 1\n 2\n 3\n@@ -8,3 +7,4 @@\n 7\n 8\n b\n+c\n",
+		},
+		{
+			name: "insert into empty",
+			old:  "",
+			new:  "a\n",
+			want: "--- old\n+++ new\n@@ -0,0 +1 @@\n+a\n",
+		},
+		{
+			name: "no final newline",
+			old:  "a\nb",
+			new:  "a\nb\n",
+			want: "--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+	}
+	for _, tt := range tests {
+		got := string(diff("old", []byte(tt.old), "new", []byte(tt.new)))
+		if d := cmp.Diff(tt.want, got); d != "" {
+			t.Errorf("%s: diff() mismatch (-want +got):\n%s", tt.name, d)
+		}
+	}
+}
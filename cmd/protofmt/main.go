@@ -0,0 +1,159 @@
+// Protofmt formats .proto files.
+//
+// Without an explicit path, it processes the standard input. Given a file,
+// it operates on that file; given a directory, it operates on all .proto
+// files in that directory, recursively. By default, protofmt prints the
+// reformatted sources to standard output.
+//
+// Usage:
+//
+//	protofmt [flags] [path ...]
+//
+// The flags are:
+//
+//	-d
+//		Do not print reformatted sources to standard output.
+//		If a file's formatting is different than protofmt's, print diffs
+//		to standard output.
+//	-l
+//		Do not print reformatted sources to standard output.
+//		If a file's formatting is different from protofmt's, print its name
+//		to standard output.
+//	-w
+//		Do not print reformatted sources to standard output.
+//		If a file's formatting is different from protofmt's, overwrite it
+//		with protofmt's version.
+//	-align
+//		Align the numbers of fields and enum values declared on
+//		consecutive lines.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"rogchap.com/protoparser"
+	"rogchap.com/protoparser/format"
+)
+
+var (
+	list  = flag.Bool("l", false, "list files whose formatting differs from protofmt's")
+	write = flag.Bool("w", false, "write result to (source) file instead of stdout")
+	diffs = flag.Bool("d", false, "display diffs instead of rewriting files")
+	align = flag.Bool("align", false, "align the numbers of fields and enum values")
+)
+
+var exitCode = 0
+
+func report(err error) {
+	protoparser.PrintError(os.Stderr, err)
+	exitCode = 2
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: protofmt [flags] [path ...]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	cfg := &format.Config{AlignNumbers: *align}
+
+	if flag.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "error: cannot use -w with standard input")
+			os.Exit(2)
+		}
+		if err := processFile(cfg, "<standard input>", os.Stdin, os.Stdout); err != nil {
+			report(err)
+		}
+		os.Exit(exitCode)
+	}
+
+	for _, path := range flag.Args() {
+		switch info, err := os.Stat(path); {
+		case err != nil:
+			report(err)
+		case info.IsDir():
+			walkDir(cfg, path)
+		default:
+			if err := processFile(cfg, path, nil, os.Stdout); err != nil {
+				report(err)
+			}
+		}
+	}
+	os.Exit(exitCode)
+}
+
+func walkDir(cfg *format.Config, path string) {
+	filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && isProtoFile(d) {
+			err = processFile(cfg, path, nil, os.Stdout)
+		}
+		if err != nil {
+			report(err)
+		}
+		return nil
+	})
+}
+
+func isProtoFile(d fs.DirEntry) bool {
+	name := d.Name()
+	return !d.IsDir() && !strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".proto")
+}
+
+// processFile formats the file filename, read from in if it is not nil,
+// and writes the result to out as the flags direct.
+func processFile(cfg *format.Config, filename string, in io.Reader, out io.Writer) error {
+	var perm fs.FileMode = 0644
+	if in == nil {
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		in = f
+		perm = fi.Mode().Perm()
+	}
+
+	src, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	res, err := cfg.Source(filename, src)
+	if err != nil {
+		return err
+	}
+
+	if !*list && !*write && !*diffs {
+		_, err = out.Write(res)
+		return err
+	}
+	if bytes.Equal(src, res) {
+		return nil
+	}
+	if *list {
+		fmt.Fprintln(out, filename)
+	}
+	if *write {
+		if err := os.WriteFile(filename, res, perm); err != nil {
+			return err
+		}
+	}
+	if *diffs {
+		fmt.Fprintf(out, "diff -u %s %s\n", filepath.ToSlash(filename+".orig"), filepath.ToSlash(filename))
+		out.Write(diff("old/"+filename, src, "new/"+filename, res))
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+// Protogen runs protoc plugins, such as protoc-gen-go, on .proto files,
+// as protoc does, without needing protoc.
+//
+// It parses the files, and the files they import, and sends them to each
+// plugin named by an output flag, writing the files that the plugins
+// generate once every plugin has run successfully. Plugins that write to
+// the same directory may insert into each other's files.
+//
+// Usage:
+//
+//	protogen [flags] file.proto ...
+//
+// The flags are those of protoc:
+//
+//	-IPATH, --proto_path=PATH
+//		Look for imports in the directory PATH. It may be given more
+//		than once, and the directories are searched in order. If it is
+//		not given, the current directory is used.
+//	--NAME_out=[PARAMS:]DIR
+//		Run the plugin protoc-gen-NAME, passing it the parameter PARAMS,
+//		and write the files it generates to the directory DIR.
+//	--NAME_opt=PARAMS
+//		Pass PARAMS to the plugin protoc-gen-NAME too, separated from
+//		the other parameters by a comma.
+//	--plugin=[protoc-gen-NAME=]PATH
+//		Run the plugin protoc-gen-NAME from the executable PATH, rather
+//		than looking for protoc-gen-NAME in the directories named by the
+//		PATH environment variable. Without a name, the plugin is named
+//		by the base name of PATH.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"rogchap.com/protoparser"
+	"rogchap.com/protoparser/plugin"
+)
+
+// An output is a plugin to run, and the directory it writes to.
+type output struct {
+	flag   string // the flag that names it, for errors
+	name   string
+	params string
+	dir    string
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: protogen [-IPATH] [--NAME_out=[PARAMS:]DIR] [--NAME_opt=PARAMS] [--plugin=[protoc-gen-NAME=]PATH] file.proto ...\n")
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		protoparser.PrintError(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run runs protogen with the command-line arguments args.
+func run(args []string) error {
+	var (
+		importPaths []string
+		outputs     []output
+		opts        = make(map[string][]string)
+		plugins     = make(map[string]string)
+		filenames   []string
+	)
+	for len(args) > 0 {
+		arg := args[0]
+		args = args[1:]
+		if !strings.HasPrefix(arg, "-") {
+			filenames = append(filenames, arg)
+			continue
+		}
+		if arg == "-h" || arg == "--help" {
+			usage()
+			return nil
+		}
+
+		// -IPATH, -I PATH, --flag=value or --flag value
+		var flag, value string
+		if strings.HasPrefix(arg, "-I") {
+			flag, value = "-I", arg[2:]
+		} else if i := strings.IndexByte(arg, '='); i >= 0 {
+			flag, value = arg[:i], arg[i+1:]
+		} else {
+			flag = arg
+		}
+		if value == "" {
+			if len(args) == 0 {
+				return fmt.Errorf("missing value for %s", flag)
+			}
+			value, args = args[0], args[1:]
+		}
+
+		switch {
+		case flag == "-I" || flag == "--proto_path":
+			importPaths = append(importPaths, filepath.SplitList(value)...)
+		case flag == "--plugin":
+			name, path := filepath.Base(value), value
+			if i := strings.IndexByte(value, '='); i >= 0 {
+				name, path = value[:i], value[i+1:]
+			}
+			plugins[strings.TrimSuffix(name, ".exe")] = path
+		case strings.HasPrefix(flag, "--") && strings.HasSuffix(flag, "_out"):
+			out := output{flag: flag, name: strings.TrimSuffix(flag[2:], "_out"), dir: value}
+			if i := strings.LastIndexByte(value, ':'); i >= 0 && !isVolume(value[:i+1]) {
+				out.params, out.dir = value[:i], value[i+1:]
+			}
+			outputs = append(outputs, out)
+		case strings.HasPrefix(flag, "--") && strings.HasSuffix(flag, "_opt"):
+			name := strings.TrimSuffix(flag[2:], "_opt")
+			opts[name] = append(opts[name], value)
+		default:
+			usage()
+			return fmt.Errorf("unknown flag: %s", flag)
+		}
+	}
+	if len(filenames) == 0 {
+		return errors.New("missing input file")
+	}
+	if len(outputs) == 0 {
+		return errors.New("missing output directives")
+	}
+	if importPaths == nil {
+		importPaths = []string{"."}
+	}
+
+	p := protoparser.Parser{
+		Mode:        protoparser.IncludeSourceInfo | protoparser.InterpretOptions,
+		ImportPaths: importPaths,
+	}
+	set, err := p.ParseFiles(filenames...)
+	if err != nil {
+		return err
+	}
+	names := make([]string, len(filenames))
+	for i, filename := range filenames {
+		names[i] = p.ImportName(filename)
+	}
+
+	// The files are only written once every plugin has run, so that
+	// plugins may insert into the files that others generate.
+	var dirs []string
+	files := make(map[string]*plugin.Files)
+	for _, out := range outputs {
+		params := out.params
+		if o := strings.Join(opts[out.name], ","); o != "" {
+			if params != "" {
+				params += ","
+			}
+			params += o
+		}
+		req, err := plugin.NewRequest(set, params, names...)
+		if err != nil {
+			return fmt.Errorf("%s: %v", out.flag, err)
+		}
+		pl := plugin.Plugin{Name: out.name, Path: plugins["protoc-gen-"+out.name]}
+		resp, err := pl.Run(context.Background(), req)
+		if err != nil {
+			return fmt.Errorf("%s: %v", out.flag, err)
+		}
+		dir := filepath.Clean(out.dir)
+		if files[dir] == nil {
+			dirs = append(dirs, dir)
+			files[dir] = new(plugin.Files)
+		}
+		if err := files[dir].Add(resp); err != nil {
+			return fmt.Errorf("%s: %v", out.flag, err)
+		}
+	}
+	for _, dir := range dirs {
+		if err := files[dir].WriteDir(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isVolume reports whether s, the start of an output directive ending in a
+// colon, is a Windows drive letter, as in C:\out, rather than parameters.
+func isVolume(s string) bool {
+	return len(s) == 2 && filepath.VolumeName(s) == s
+}
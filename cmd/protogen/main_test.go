@@ -0,0 +1,153 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// TestMain runs the test binary as a plugin when the tests run it so.
+func TestMain(m *testing.M) {
+	if os.Getenv("PROTOGEN_TEST_PLUGIN") != "" {
+		testPlugin()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// testPlugin generates request.txt, describing the request it was sent,
+// or fails if the parameter is "error".
+func testPlugin() {
+	in, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		os.Exit(2)
+	}
+	req := new(pluginpb.CodeGeneratorRequest)
+	if err := proto.Unmarshal(in, req); err != nil {
+		os.Exit(2)
+	}
+
+	resp := &pluginpb.CodeGeneratorResponse{
+		SupportedFeatures: proto.Uint64(uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)),
+	}
+	if req.GetParameter() == "error" {
+		resp.Error = proto.String("bad parameter")
+	}
+	var files []string
+	for _, fd := range req.ProtoFile {
+		files = append(files, fd.GetName())
+	}
+	resp.File = append(resp.File, &pluginpb.CodeGeneratorResponse_File{
+		Name: proto.String("request.txt"),
+		Content: proto.String("file_to_generate: " + strings.Join(req.FileToGenerate, " ") + "\n" +
+			"parameter: " + req.GetParameter() + "\n" +
+			"proto_file: " + strings.Join(files, " ") + "\n"),
+	})
+	out, err := proto.Marshal(resp)
+	if err != nil {
+		os.Exit(2)
+	}
+	os.Stdout.Write(out)
+}
+
+// writeFiles writes the files of contents, by name, to dir.
+func writeFiles(t *testing.T, dir string, contents map[string]string) {
+	t.Helper()
+	for name, content := range contents {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Setenv("PROTOGEN_TEST_PLUGIN", "1")
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeFiles(t, src, map[string]string{
+		"x/a.proto": "syntax = \"proto3\";\nimport \"x/b.proto\";\nmessage A { B b = 1; }\n",
+		"x/b.proto": "syntax = \"proto3\";\nimport \"c.proto\";\nmessage B { C c = 1; }\n",
+		"c.proto":   "syntax = \"proto3\";\nmessage C {}\n",
+		"d.proto":   "syntax = \"proto3\";\nimport \"c.proto\";\nmessage D { C c = 1; }\n",
+	})
+
+	out := filepath.Join(dir, "out")
+	err = run([]string{
+		"-I" + src,
+		"--plugin=protoc-gen-test=" + exe,
+		"--test_out=a=1:" + out,
+		"--test_opt=b=2",
+		"--test_opt", "c=3",
+		filepath.Join(src, "d.proto"),
+		filepath.Join(src, "x", "a.proto"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(out, "request.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "file_to_generate: d.proto x/a.proto\n" +
+		"parameter: a=1,b=2,c=3\n" +
+		"proto_file: c.proto d.proto x/b.proto x/a.proto\n"
+	if string(b) != want {
+		t.Errorf("got request\n%s\nwant\n%s", b, want)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Setenv("PROTOGEN_TEST_PLUGIN", "1")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.proto": "syntax = \"proto3\";\nmessage A {}\n"})
+	a := filepath.Join(dir, "a.proto")
+	out := filepath.Join(dir, "out")
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{a}, "missing output directives"},
+		{[]string{"--test_out=" + out}, "missing input file"},
+		{[]string{"--test_out"}, "missing value for --test_out"},
+		{[]string{"--bad", a}, "unknown flag: --bad"},
+		{
+			// the files of the first plugin are not written either
+			[]string{
+				"-I" + dir,
+				"--plugin=protoc-gen-good=" + exe,
+				"--plugin=protoc-gen-bad=" + exe,
+				"--good_out=" + out,
+				"--bad_out=error:" + out,
+				a,
+			},
+			"--bad_out: protoc-gen-bad: bad parameter",
+		},
+	}
+	for _, tt := range tests {
+		err := run(tt.args)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%v: got error %v, want %q", tt.args, err, tt.want)
+		}
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("got output directory, %v; want none", err)
+	}
+}
@@ -0,0 +1,260 @@
+package protoparser_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"rogchap.com/protoparser"
+)
+
+// conformanceDir holds the corpus of proto files, and the descriptor sets
+// that protoc wrote for them; see its README.
+const conformanceDir = "testdata/conformance"
+
+// TestConformance checks that the descriptor sets written for the corpus
+// are those that protoc wrote, reporting every field in which they
+// diverge.
+func TestConformance(t *testing.T) {
+	tests := []struct {
+		set            string
+		dir            string // the corpus subdirectory the set was written in
+		includeImports bool
+		sourceInfo     bool
+		files          []string // nil for every file in the corpus directory
+	}{
+		{set: "all.protoset", includeImports: true},
+		{set: "desc_test_complex.protoset", includeImports: true, files: []string{"desc_test_complex.proto"}},
+		{set: "desc_test_defaults.protoset", includeImports: true, files: []string{"desc_test_defaults.proto"}},
+		{set: "desc_test_proto3_optional.protoset", includeImports: true, files: []string{"desc_test_proto3_optional.proto"}},
+		{
+			set:            "descriptor_impl_tests.protoset",
+			includeImports: true,
+			files: []string{
+				"desc_test2.proto",
+				"desc_test_complex.proto",
+				"desc_test_defaults.proto",
+				"desc_test_proto3.proto",
+				"desc_test_proto3_optional.proto",
+			},
+		},
+		{
+			set:        "source_info.protoset",
+			sourceInfo: true,
+			files:      []string{"desc_test_options.proto", "desc_test_comments.proto", "desc_test_complex.proto"},
+		},
+		{set: "options.protoset", dir: "options", files: []string{"options.proto"}},
+		{set: "test.protoset", dir: "options", files: []string{"test.proto"}},
+		{set: "test_proto3.protoset", dir: "options", files: []string{"test_proto3.proto"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(path.Join(tt.dir, tt.set), func(t *testing.T) {
+			dir := filepath.Join(conformanceDir, tt.dir)
+			b, err := ioutil.ReadFile(filepath.Join(dir, tt.set))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := new(descriptorpb.FileDescriptorSet)
+			if err := proto.Unmarshal(b, want); err != nil {
+				t.Fatal(err)
+			}
+
+			var filenames []string
+			for _, name := range tt.files {
+				filenames = append(filenames, filepath.Join(dir, name))
+			}
+			if tt.files == nil {
+				filenames, err = filepath.Glob(filepath.Join(dir, "*.proto"))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			p := protoparser.Parser{ImportPaths: []string{dir}}
+			if tt.sourceInfo {
+				p.Mode = protoparser.IncludeSourceInfo
+			}
+			var buf bytes.Buffer
+			if err := p.WriteDescriptorSet(&buf, tt.includeImports, filenames...); err != nil {
+				t.Fatal(err)
+			}
+			got := new(descriptorpb.FileDescriptorSet)
+			if err := proto.Unmarshal(buf.Bytes(), got); err != nil {
+				t.Fatal(err)
+			}
+
+			var names []string
+			for _, fd := range got.File {
+				names = append(names, fd.GetName())
+			}
+			if len(got.File) != len(want.File) {
+				t.Fatalf("got files %v, want %d files", names, len(want.File))
+			}
+			same := true
+			for i, wantFile := range want.File {
+				gotFile := got.File[i]
+				if gotFile.GetName() != wantFile.GetName() {
+					t.Fatalf("got files %v, want %q at %d", names, wantFile.GetName(), i)
+				}
+				if !inCorpus(dir, wantFile.GetName()) {
+					t.Errorf("%s: not in the corpus, so it cannot be compared", wantFile.GetName())
+					continue
+				}
+				for _, d := range divergences("", wantFile.ProtoReflect(), gotFile.ProtoReflect()) {
+					same = false
+					t.Errorf("%s: %s", wantFile.GetName(), d)
+				}
+			}
+			if same && allInCorpus(dir, want) && !bytes.Equal(buf.Bytes(), b) {
+				t.Errorf("the set is not written as protoc writes it")
+			}
+		})
+	}
+}
+
+// TestConformanceWellKnown checks that the descriptors written for the
+// well-known types of the corpus are those that protoc compiled into the
+// protobuf runtime, for which the corpus has no sets.
+func TestConformanceWellKnown(t *testing.T) {
+	for _, file := range []protoreflect.FileDescriptor{
+		anypb.File_google_protobuf_any_proto,
+		durationpb.File_google_protobuf_duration_proto,
+		emptypb.File_google_protobuf_empty_proto,
+		fieldmaskpb.File_google_protobuf_field_mask_proto,
+		structpb.File_google_protobuf_struct_proto,
+		timestamppb.File_google_protobuf_timestamp_proto,
+		wrapperspb.File_google_protobuf_wrappers_proto,
+	} {
+		t.Run(file.Path(), func(t *testing.T) {
+			p := protoparser.Parser{ImportPaths: []string{conformanceDir}}
+			var buf bytes.Buffer
+			if err := p.WriteDescriptorSet(&buf, false, filepath.Join(conformanceDir, filepath.FromSlash(file.Path()))); err != nil {
+				t.Fatal(err)
+			}
+			got := new(descriptorpb.FileDescriptorSet)
+			if err := proto.Unmarshal(buf.Bytes(), got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got.File) != 1 {
+				t.Fatalf("got %d files, want 1", len(got.File))
+			}
+			want := protodesc.ToFileDescriptorProto(file)
+			for _, d := range divergences("", want.ProtoReflect(), got.File[0].ProtoReflect()) {
+				t.Errorf("%s: %s", file.Path(), d)
+			}
+		})
+	}
+}
+
+// inCorpus reports whether the file imported as name from the corpus
+// directory dir is in the corpus.
+func inCorpus(dir, name string) bool {
+	_, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	return err == nil
+}
+
+// allInCorpus reports whether every file of set, written in the corpus
+// directory dir, is in the corpus.
+func allInCorpus(dir string, set *descriptorpb.FileDescriptorSet) bool {
+	for _, fd := range set.File {
+		if !inCorpus(dir, fd.GetName()) {
+			return false
+		}
+	}
+	return true
+}
+
+// divergences returns the fields in which got diverges from want, two
+// messages of the same type found at path, each as the path of the field
+// and the values it has in each.
+func divergences(path string, want, got protoreflect.Message) []string {
+	var diffs []string
+	fields := want.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		name := string(fd.Name())
+		if path != "" {
+			name = path + "." + name
+		}
+		switch {
+		case !want.Has(fd) && !got.Has(fd):
+		case !got.Has(fd):
+			diffs = append(diffs, fmt.Sprintf("%s: unset, want %s", name, describe(fd, want.Get(fd))))
+		case !want.Has(fd):
+			diffs = append(diffs, fmt.Sprintf("%s: got %s, want unset", name, describe(fd, got.Get(fd))))
+		case fd.IsList():
+			w, g := want.Get(fd).List(), got.Get(fd).List()
+			if w.Len() != g.Len() {
+				diffs = append(diffs, fmt.Sprintf("%s: got %d elements, want %d", name, g.Len(), w.Len()))
+			}
+			for j := 0; j < w.Len() && j < g.Len(); j++ {
+				elem := fmt.Sprintf("%s[%d]", name, j)
+				if fd.Message() != nil {
+					diffs = append(diffs, divergences(elem, w.Get(j).Message(), g.Get(j).Message())...)
+				} else if !equal(w.Get(j), g.Get(j)) {
+					diffs = append(diffs, fmt.Sprintf("%s: got %s, want %s", elem, format(fd, g.Get(j)), format(fd, w.Get(j))))
+				}
+			}
+		case fd.Message() != nil:
+			diffs = append(diffs, divergences(name, want.Get(fd).Message(), got.Get(fd).Message())...)
+		case !equal(want.Get(fd), got.Get(fd)):
+			diffs = append(diffs, fmt.Sprintf("%s: got %s, want %s", name, format(fd, got.Get(fd)), format(fd, want.Get(fd))))
+		}
+	}
+	// custom options are kept as unknown fields
+	if w, g := want.GetUnknown(), got.GetUnknown(); !bytes.Equal(w, g) {
+		name := path
+		if name == "" {
+			name = "."
+		}
+		diffs = append(diffs, fmt.Sprintf("%s: got unknown fields % x, want % x", name, g, w))
+	}
+	return diffs
+}
+
+// equal reports whether a and b, two values of a scalar field, are equal.
+func equal(a, b protoreflect.Value) bool {
+	if x, ok := a.Interface().([]byte); ok {
+		return bytes.Equal(x, b.Bytes())
+	}
+	return a.Interface() == b.Interface()
+}
+
+// describe describes v, the value of the field fd, for a divergence.
+func describe(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	switch {
+	case fd.IsList():
+		return fmt.Sprintf("%d elements", v.List().Len())
+	case fd.Message() != nil:
+		return "a message"
+	}
+	return format(fd, v)
+}
+
+// format formats v, a value of the scalar field fd, for a divergence.
+func format(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	switch fd.Kind() {
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+	case protoreflect.StringKind:
+		return fmt.Sprintf("%q", v.String())
+	}
+	return v.String()
+}
@@ -0,0 +1,132 @@
+// Package format implements the standard formatting of .proto source.
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"rogchap.com/protoparser/internal/parser"
+	"rogchap.com/protoparser/internal/scanner"
+	"rogchap.com/protoparser/internal/token"
+	"rogchap.com/protoparser/printer"
+)
+
+// A Config controls the formatting of Source.
+type Config struct {
+	// Indent is the indentation of each level of nesting. If empty, two
+	// spaces are used.
+	Indent string
+
+	// AlignNumbers aligns the "=" of the fields and enum values declared
+	// on consecutive lines, so that their numbers line up.
+	AlignNumbers bool
+}
+
+// Source formats src, the source of the .proto file filename, in the
+// canonical style: the syntax, package, imports and file options come
+// first, in that order; declarations are indented by nesting, one per
+// line; and options, values and type names are written as the printer
+// package writes them. Every comment is kept, as are the blank lines that
+// group declarations.
+//
+// The file is only parsed, not compiled, so its imports need not be
+// available. If src has syntax errors, they are returned as a
+// protoparser.ErrorList and src is not formatted.
+func (cfg *Config) Source(filename string, src []byte) ([]byte, error) {
+	fd, comments, err := parser.ParseSource(filename, src)
+	if err != nil {
+		return nil, err
+	}
+	pc := printer.Config{
+		Indent:       cfg.Indent,
+		AlignNumbers: cfg.AlignNumbers,
+		KeepNames:    true,
+		Resolver:     new(protoregistry.Types), // options are not interpreted
+	}
+	orphans := make([]printer.Comment, len(comments))
+	for i, c := range comments {
+		orphans[i] = printer.Comment{Span: c.Span, Text: c.Text}
+	}
+	var buf bytes.Buffer
+	if err := pc.FprintCommented(&buf, fd, orphans); err != nil {
+		return nil, err
+	}
+	res := buf.Bytes()
+
+	// The formatted source must declare the same file, with the same
+	// comments, as the source.
+	got, _, err := parser.ParseSource(filename, res)
+	if err != nil || !sameFile(fd, got) || !sameComments(src, res) {
+		return nil, fmt.Errorf("%s: the file could not be formatted without changing it", filename)
+	}
+	return res, nil
+}
+
+// Source formats src, the source of a .proto file, using the default
+// Config.
+func Source(src []byte) ([]byte, error) {
+	return (&Config{}).Source("", src)
+}
+
+// sameFile reports whether a and b declare the same file, ignoring their
+// source information.
+func sameFile(a, b *descriptorpb.FileDescriptorProto) bool {
+	a = proto.Clone(a).(*descriptorpb.FileDescriptorProto)
+	b = proto.Clone(b).(*descriptorpb.FileDescriptorProto)
+	for _, fd := range []*descriptorpb.FileDescriptorProto{a, b} {
+		fd.SourceCodeInfo = nil
+		if fd.Syntax == nil {
+			fd.Syntax = proto.String("proto2") // the printer writes it
+		}
+	}
+	return proto.Equal(a, b)
+}
+
+// sameComments reports whether the sources a and b have the same comments,
+// as sets of words: comments may be moved, and block comments written as
+// line comments.
+func sameComments(a, b []byte) bool {
+	wa, wb := commentWords(a), commentWords(b)
+	if len(wa) != len(wb) {
+		return false
+	}
+	for i := range wa {
+		if wa[i] != wb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// commentWords returns the words of the comments of src, sorted.
+func commentWords(src []byte) []string {
+	var s scanner.Scanner
+	s.Init(token.NewFile("", len(src)), src, nil, scanner.ScanComments)
+	var words []string
+	for {
+		_, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok != token.COMMENT {
+			continue
+		}
+		if strings.HasPrefix(lit, "/*") {
+			lit = strings.TrimSuffix(lit, "*/")
+		}
+		// the asterisks that start the lines of block comments are not
+		// kept, and those of "/**" may be written as "//*"
+		for _, l := range strings.Split(lit[2:], "\n") {
+			l = strings.TrimPrefix(strings.TrimLeft(l, " \t"), "*")
+			words = append(words, strings.Fields(l)...)
+		}
+	}
+	sort.Strings(words)
+	return words
+}
@@ -0,0 +1,212 @@
+package format_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"rogchap.com/protoparser"
+	"rogchap.com/protoparser/format"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  format.Config
+		src  string
+		want string
+	}{
+		{
+			name: "layout",
+			src: `syntax="proto3";
+option go_package="x";
+import "b.proto";
+package   foo;
+message   Foo{
+      string name=1;
+  repeated int32 ids   =  2 [ packed=false ,deprecated=true] ;
+  map < string,Foo > children = 3;
+  enum Kind { KIND_UNSPECIFIED=0; }
+}
+service S{rpc Get(Foo)returns(Foo);}
+`,
+			want: `syntax = "proto3";
+
+package foo;
+
+import "b.proto";
+
+option go_package = "x";
+
+message Foo {
+  string name = 1;
+  repeated int32 ids = 2 [packed = false, deprecated = true];
+  map<string, Foo> children = 3;
+  enum Kind {
+    KIND_UNSPECIFIED = 0;
+  }
+}
+service S {
+  rpc Get(Foo) returns (Foo);
+}
+`,
+		},
+		{
+			name: "comments",
+			src: `// The file.
+
+syntax = "proto3";
+
+/* A message
+ * of many lines. */
+message Foo {  // Foo
+      string name = 1;// the name
+  int32 id = 2 /* within id */;
+
+  // detached in Foo
+
+      // at the end of Foo
+}  // after Foo
+message Bar {
+
+  // alone in Bar
+}
+// at the end of the file
+`,
+			want: `// The file.
+
+syntax = "proto3";
+
+// A message
+// of many lines.
+message Foo {  // Foo
+  string name = 1;  // the name
+
+  // within id
+
+  int32 id = 2;
+
+  // detached in Foo
+
+  // at the end of Foo
+}  // after Foo
+message Bar {
+
+  // alone in Bar
+}
+// at the end of the file
+`,
+		},
+		{
+			name: "blank lines",
+			src: `syntax = "proto3";
+message Foo {
+  int32 a = 1;
+  int32 b = 2;
+
+
+
+  int32 c = 3;
+}
+message Bar {}
+`,
+			want: `syntax = "proto3";
+
+message Foo {
+  int32 a = 1;
+  int32 b = 2;
+
+  int32 c = 3;
+}
+message Bar {}
+`,
+		},
+		{
+			name: "names as written",
+			src: `syntax = "proto2";
+package foo;
+import "google/protobuf/descriptor.proto";
+extend .google.protobuf.MessageOptions { optional .foo.Foo foo = 50000; }
+message Foo { optional group Result = 1 { optional string url = 2; } }
+`,
+			want: `syntax = "proto2";
+
+package foo;
+
+import "google/protobuf/descriptor.proto";
+
+extend .google.protobuf.MessageOptions {
+  optional .foo.Foo foo = 50000;
+}
+message Foo {
+  optional group Result = 1 {
+    optional string url = 2;
+  }
+}
+`,
+		},
+		{
+			name: "aligned numbers",
+			cfg:  format.Config{Indent: "    ", AlignNumbers: true},
+			src: `syntax = "proto3";
+message Foo {
+  string name = 1;
+  repeated int32 ids = 2 [packed = false];
+  Foo next = 3;
+
+  int32 id = 4;
+}
+enum Kind {
+  KIND_UNSPECIFIED = 0;
+  KIND_OTHER = 1;
+}
+`,
+			want: `syntax = "proto3";
+
+message Foo {
+    string name        = 1;
+    repeated int32 ids = 2 [packed = false];
+    Foo next           = 3;
+
+    int32 id = 4;
+}
+enum Kind {
+    KIND_UNSPECIFIED = 0;
+    KIND_OTHER       = 1;
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.cfg.Source("test.proto", []byte(tt.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("Source() mismatch (-want +got):\n%s", diff)
+			}
+
+			// formatting is idempotent
+			again, err := tt.cfg.Source("test.proto", got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(string(got), string(again)); diff != "" {
+				t.Errorf("Source() of the formatted source mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSourceErrors(t *testing.T) {
+	_, err := format.Source([]byte("syntax = \"proto3\";\nmessage {}\n"))
+	errs, ok := err.(protoparser.ErrorList)
+	if !ok || len(errs) == 0 {
+		t.Fatalf("Source() error = %v, want an ErrorList", err)
+	}
+	if got, want := errs[0].Error(), "2:9: expected message name, found '{'"; got != want {
+		t.Errorf("Source() error = %q, want %q", got, want)
+	}
+}
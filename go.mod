@@ -1,8 +1,8 @@
 module rogchap.com/protoparser
 
-go 1.14
+go 1.18
 
 require (
-	github.com/google/go-cmp v0.4.0
-	google.golang.org/protobuf v1.20.0
+	github.com/google/go-cmp v0.5.5
+	google.golang.org/protobuf v1.28.1
 )
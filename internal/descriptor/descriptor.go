@@ -0,0 +1,104 @@
+// Package descriptor defines what the parser and the printer share about
+// file descriptors: the field numbers used in SourceCodeInfo location
+// paths, and the names protoc derives from the names of fields.
+package descriptor
+
+// Field numbers used in SourceCodeInfo location paths.
+const (
+	// FileDescriptorProto
+	FilePackageTag          = 2
+	FileDependencyTag       = 3
+	FileMessageTag          = 4
+	FileEnumTag             = 5
+	FileServiceTag          = 6
+	FileExtensionTag        = 7
+	FileOptionsTag          = 8
+	FilePublicDependencyTag = 10
+	FileWeakDependencyTag   = 11
+	FileSyntaxTag           = 12
+
+	// DescriptorProto
+	MessageNameTag           = 1
+	MessageFieldTag          = 2
+	MessageNestedTag         = 3
+	MessageEnumTag           = 4
+	MessageExtensionRangeTag = 5
+	MessageExtensionTag      = 6
+	MessageOptionsTag        = 7
+	MessageOneofTag          = 8
+	MessageReservedRangeTag  = 9
+	MessageReservedNameTag   = 10
+
+	// ExtensionRange and ReservedRange
+	RangeStartTag   = 1
+	RangeEndTag     = 2
+	RangeOptionsTag = 3
+
+	// FieldDescriptorProto
+	FieldNameTag     = 1
+	FieldExtendeeTag = 2
+	FieldNumberTag   = 3
+	FieldLabelTag    = 4
+	FieldTypeTag     = 5
+	FieldTypeNameTag = 6
+	FieldDefaultTag  = 7
+	FieldOptionsTag  = 8
+	FieldJSONNameTag = 10
+
+	// OneofDescriptorProto
+	OneofNameTag    = 1
+	OneofOptionsTag = 2
+
+	// EnumDescriptorProto
+	EnumNameTag          = 1
+	EnumValueTag         = 2
+	EnumOptionsTag       = 3
+	EnumReservedRangeTag = 4
+	EnumReservedNameTag  = 5
+
+	// EnumValueDescriptorProto
+	EnumValueNameTag    = 1
+	EnumValueNumberTag  = 2
+	EnumValueOptionsTag = 3
+
+	// ServiceDescriptorProto
+	ServiceNameTag    = 1
+	ServiceMethodTag  = 2
+	ServiceOptionsTag = 3
+
+	// MethodDescriptorProto
+	MethodNameTag            = 1
+	MethodInputTag           = 2
+	MethodOutputTag          = 3
+	MethodOptionsTag         = 4
+	MethodClientStreamingTag = 5
+	MethodServerStreamingTag = 6
+)
+
+// UninterpretedOptionField is the field number of uninterpreted_option in
+// every options message.
+const UninterpretedOptionField = 999
+
+/*
+	JSONCamelCase has been taken from the protobuf-go repository
+	https://github.com/protocolbuffers/protobuf-go/blob/master/internal/strs/strings.go
+	Copyright 2019 The Go Authors. All rights reserved.
+*/
+
+// JSONCamelCase returns the default JSON name of the field named s, as
+// protoc computes it.
+func JSONCamelCase(s string) string {
+	var b []byte
+	var wasUnderscore bool
+	for i := 0; i < len(s); i++ { // proto identifiers are always ASCII
+		c := s[i]
+		if c != '_' {
+			if wasUnderscore && 'a' <= c && c <= 'z' {
+				c -= 'a' - 'A' // convert to uppercase
+			}
+			b = append(b, c)
+		}
+		wasUnderscore = c == '_'
+	}
+	return string(b)
+}
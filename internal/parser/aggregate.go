@@ -0,0 +1,403 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"rogchap.com/protoparser/internal/scanner"
+	"rogchap.com/protoparser/internal/token"
+)
+
+// An aggregateParser parses the value of an aggregate option, a message
+// written in the text format, as protoc's text format parser parses it.
+type aggregateParser struct {
+	in         *interpreter
+	relativeTo string // the element the option is set on
+	scanner    scanner.Scanner
+	tok        token.Token
+	lit        string
+	err        string // the first error found
+	nestLev    int    // message nesting level
+}
+
+// parseAggregate parses text, the value of an aggregate option set on the
+// element relativeTo, as a message of type typ. It returns the message,
+// or protoc's description of the first error found.
+func (in *interpreter) parseAggregate(typ *optionType, text, relativeTo string) (*message, string) {
+	a := &aggregateParser{in: in, relativeTo: relativeTo}
+	src := []byte(text)
+	a.scanner.Init(token.NewFile("", len(src)), src, func(_ token.Position, msg string) { a.fail(msg) }, 0)
+	a.next()
+	m := newMessage(typ)
+	a.parseFields(m, token.EOF)
+	if a.err != "" {
+		return nil, a.err
+	}
+	return m, ""
+}
+
+func (a *aggregateParser) next() {
+	_, a.tok, a.lit = a.scanner.Scan()
+	if a.tok.IsKeyword() {
+		a.tok = token.IDENT // keywords are identifiers here
+	}
+}
+
+// fail records msg, unless an error has been found already.
+func (a *aggregateParser) fail(msg string) {
+	if a.err == "" {
+		a.err = msg
+	}
+}
+
+// text returns the current token as it is written.
+func (a *aggregateParser) text() string {
+	switch {
+	case a.tok == token.EOF:
+		return ""
+	case a.lit != "":
+		return a.lit
+	}
+	return a.tok.String()
+}
+
+func (a *aggregateParser) expect(tok token.Token) bool {
+	if a.tok != tok {
+		a.fail(fmt.Sprintf("Expected %q, found %q.", tok.String(), a.text()))
+		return false
+	}
+	a.next()
+	return true
+}
+
+// parseFields parses the fields of m up to the token end.
+func (a *aggregateParser) parseFields(m *message, end token.Token) {
+	for a.err == "" && a.tok != end {
+		if a.tok == token.EOF {
+			a.fail(fmt.Sprintf("Expected %q.", end.String()))
+			return
+		}
+		a.parseField(m)
+	}
+}
+
+func (a *aggregateParser) parseField(m *message) {
+	var (
+		f      *descriptorpb.FieldDescriptorProto
+		proto3 bool
+	)
+	if a.tok == token.LBRACK {
+		a.next()
+		name := a.parseTypeName()
+		if !a.expect(token.RBRACK) {
+			return
+		}
+		if i := strings.LastIndexByte(name, '/'); i >= 0 && m.typ.name == "google.protobuf.Any" {
+			a.parseAny(m, name, name[i+1:])
+			return
+		}
+		ext, ok := a.in.extension(name, a.relativeTo, nil)
+		if !ok || strings.TrimPrefix(ext.fd.GetExtendee(), ".") != m.typ.name {
+			a.fail(fmt.Sprintf("Extension %q is not defined or is not an extension of %q.", name, m.typ.name))
+			return
+		}
+		f, proto3 = ext.fd, ext.proto3
+	} else {
+		if a.tok != token.IDENT {
+			a.fail("Expected identifier, got: " + a.text())
+			return
+		}
+		name := a.lit
+		a.next()
+		// groups are named by their type names
+		f = fieldNamed(m.typ.msg, name)
+		if f == nil {
+			f = fieldNamed(m.typ.msg, strings.ToLower(name))
+			if f != nil && f.GetType() != descriptorpb.FieldDescriptorProto_TYPE_GROUP {
+				f = nil
+			}
+		}
+		if f != nil && f.GetType() == descriptorpb.FieldDescriptorProto_TYPE_GROUP && shortTypeName(f.GetTypeName()) != name {
+			f = nil
+		}
+		if f == nil {
+			a.fail(fmt.Sprintf("Message type %q has no field named %q.", m.typ.name, name))
+			return
+		}
+		proto3 = m.typ.proto3
+	}
+
+	repeated := f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	if isMessageField(f) {
+		if a.tok == token.COLON {
+			a.next()
+		}
+	} else if !a.expect(token.COLON) {
+		return
+	}
+	if repeated && a.tok == token.LBRACK {
+		a.next()
+		for a.err == "" && a.tok != token.RBRACK {
+			a.parseValue(m, f, proto3)
+			if a.tok != token.COMMA {
+				break
+			}
+			a.next()
+		}
+		a.expect(token.RBRACK)
+	} else {
+		a.parseValue(m, f, proto3)
+	}
+	if a.tok == token.COMMA || a.tok == token.SEMICOLON {
+		a.next()
+	}
+}
+
+// parseTypeName parses the name of an extension, or the type URL of an
+// Any.
+func (a *aggregateParser) parseTypeName() string {
+	var sb strings.Builder
+	for a.err == "" {
+		if a.tok != token.IDENT {
+			a.fail("Expected identifier, got: " + a.text())
+			break
+		}
+		sb.WriteString(a.lit)
+		a.next()
+		if a.tok != token.DOT && a.tok != token.SLASH {
+			break
+		}
+		sb.WriteString(a.tok.String())
+		a.next()
+	}
+	return sb.String()
+}
+
+// parseAny parses the expanded value of the Any m, of the type typeName,
+// whose type URL is url.
+func (a *aggregateParser) parseAny(m *message, url, typeName string) {
+	typ := a.in.types[typeName]
+	if typ == nil || typ.msg == nil {
+		a.fail(fmt.Sprintf("Could not find type %q stored in google.protobuf.Any.", url))
+		return
+	}
+	if a.tok == token.COLON {
+		a.next()
+	}
+	v := a.parseMessageValue(typ)
+	if a.err != "" {
+		return
+	}
+	urlField, valueField := fieldNamed(m.typ.msg, "type_url"), fieldNamed(m.typ.msg, "value")
+	if urlField == nil || valueField == nil {
+		return
+	}
+	if m.fields[urlField.GetNumber()] != nil {
+		a.fail("Non-repeated Any specified multiple times.")
+		return
+	}
+	m.add(optionField{urlField, m.typ.proto3}, value{b: []byte(url)})
+	m.add(optionField{valueField, m.typ.proto3}, value{b: v.encode()})
+	if a.tok == token.COMMA || a.tok == token.SEMICOLON {
+		a.next()
+	}
+}
+
+// parseValue parses a value of the field f of m, declared in a proto3 file
+// if proto3 is set, and adds it to m.
+func (a *aggregateParser) parseValue(m *message, f *descriptorpb.FieldDescriptorProto, proto3 bool) {
+	typ := a.in.types[strings.TrimPrefix(f.GetTypeName(), ".")]
+	var v value
+	if isMessageField(f) {
+		v.msg = a.parseMessageValue(typ)
+	} else {
+		v.b = a.parseScalar(f, typ)
+	}
+	if a.err != "" {
+		return
+	}
+
+	if f.GetLabel() != descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+		if m.fields[f.GetNumber()] != nil {
+			a.fail(fmt.Sprintf("Non-repeated field %q is specified multiple times.", f.GetName()))
+			return
+		}
+		if f.OneofIndex != nil && f.Extendee == nil {
+			for _, g := range m.typ.msg.Field {
+				if g != f && g.OneofIndex != nil && g.GetOneofIndex() == f.GetOneofIndex() && m.fields[g.GetNumber()] != nil {
+					a.fail(fmt.Sprintf("Field %q is specified along with field %q, another member of oneof %q.",
+						f.GetName(), g.GetName(), m.typ.msg.OneofDecl[f.GetOneofIndex()].GetName()))
+					return
+				}
+			}
+		}
+	}
+	m.add(optionField{f, proto3}, v)
+}
+
+// parseMessageValue parses a message of type typ, between braces or
+// angle brackets.
+func (a *aggregateParser) parseMessageValue(typ *optionType) *message {
+	var end token.Token
+	switch a.tok {
+	case token.LBRACE:
+		end = token.RBRACE
+	case token.LANGLE:
+		end = token.RANGLE
+	default:
+		a.fail(fmt.Sprintf("Expected \"{\", found %q.", a.text()))
+		return nil
+	}
+	if a.nestLev >= maxNestLev {
+		a.fail(fmt.Sprintf("Message is too deep, the parser exceeded the configured recursion limit of %d.", maxNestLev))
+		return nil
+	}
+	a.nestLev++
+	defer func() { a.nestLev-- }()
+
+	a.next()
+	m := newMessage(typ)
+	a.parseFields(m, end)
+	if a.err == "" {
+		a.next()
+	}
+	return m
+}
+
+// parseScalar parses a value of the scalar field f, whose type is typ if
+// it is an enum, and returns its encoding.
+func (a *aggregateParser) parseScalar(f *descriptorpb.FieldDescriptorProto, typ *optionType) []byte {
+	kind := protoreflect.Kind(f.GetType())
+	switch kind {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return encodeInt(f.GetType(), a.parseInt(math.MaxInt32))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return encodeInt(f.GetType(), a.parseInt(math.MaxInt64))
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return encodeUint(f.GetType(), a.parseUint(math.MaxUint32))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return encodeUint(f.GetType(), a.parseUint(math.MaxUint64))
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return encodeFloat(f.GetType(), a.parseFloat())
+	case protoreflect.BoolKind:
+		lit := a.text()
+		a.next()
+		switch lit {
+		case "true", "True", "t", "1":
+			return []byte{1}
+		case "false", "False", "f", "0":
+			return []byte{0}
+		}
+		a.fail(fmt.Sprintf("Invalid value for boolean field %q. Value: %q.", f.GetName(), lit))
+	case protoreflect.EnumKind:
+		if a.tok == token.IDENT {
+			lit := a.lit
+			a.next()
+			if ev := enumValueNamed(typ, lit); ev != nil {
+				return encodeInt(descriptorpb.FieldDescriptorProto_TYPE_ENUM, int64(ev.GetNumber()))
+			}
+			a.fail(fmt.Sprintf("Unknown enumeration value of %q for field %q.", lit, f.GetName()))
+			return nil
+		}
+		n := a.parseInt(math.MaxInt32)
+		if a.err == "" && typ != nil && !typ.proto3 && !hasEnumNumber(typ.enum, int32(n)) {
+			// only open enums, declared in proto3 files, have unknown values
+			a.fail(fmt.Sprintf("Unknown enumeration value of \"%d\" for field %q.", n, f.GetName()))
+		}
+		return encodeInt(descriptorpb.FieldDescriptorProto_TYPE_ENUM, n)
+	case protoreflect.StringKind, protoreflect.BytesKind:
+		if a.tok != token.STRING {
+			a.fail("Expected string, got: " + a.text())
+			return nil
+		}
+		var sb strings.Builder
+		for a.tok == token.STRING {
+			s, err := unquote(a.lit)
+			if err != nil {
+				a.fail(err.Error())
+			}
+			sb.WriteString(s)
+			a.next()
+		}
+		return []byte(sb.String())
+	}
+	return nil
+}
+
+// parseInt parses an optionally negative integer in the range
+// [-max-1, max].
+func (a *aggregateParser) parseInt(max uint64) int64 {
+	neg := a.tok == token.MINUS
+	if neg {
+		a.next()
+		max++
+	}
+	v := a.parseUint(max)
+	if neg {
+		return -int64(v)
+	}
+	return int64(v)
+}
+
+// parseUint parses an integer in the range [0, max].
+func (a *aggregateParser) parseUint(max uint64) uint64 {
+	if a.tok != token.INT {
+		a.fail("Expected integer, got: " + a.text())
+		return 0
+	}
+	v, err := strconv.ParseUint(a.lit, 0, 64)
+	if err != nil || v > max {
+		a.fail("Integer out of range (" + a.lit + ")")
+	}
+	a.next()
+	return v
+}
+
+// parseFloat parses an optionally negative number, or inf or nan.
+func (a *aggregateParser) parseFloat() float64 {
+	neg := a.tok == token.MINUS
+	if neg {
+		a.next()
+	}
+	var v float64
+	switch a.tok {
+	case token.INT:
+		u, err := strconv.ParseUint(a.lit, 0, 64)
+		if err != nil {
+			a.fail("Integer out of range (" + a.lit + ")")
+		}
+		v = float64(u)
+	case token.FLOAT:
+		v, _ = strconv.ParseFloat(a.lit, 64)
+	case token.IDENT:
+		switch strings.ToLower(a.lit) {
+		case "inf", "infinity":
+			v = math.Inf(1)
+		case "nan":
+			v = math.NaN()
+		default:
+			a.fail("Expected double, got: " + a.lit)
+		}
+	default:
+		a.fail("Expected double, got: " + a.text())
+	}
+	a.next()
+	if neg {
+		v = -v
+	}
+	return v
+}
+
+func hasEnumNumber(e *descriptorpb.EnumDescriptorProto, n int32) bool {
+	for _, v := range e.GetValue() {
+		if v.GetNumber() == n {
+			return true
+		}
+	}
+	return false
+}
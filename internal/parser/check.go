@@ -0,0 +1,186 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"rogchap.com/protoparser/internal/descriptor"
+	"rogchap.com/protoparser/internal/scanner"
+	"rogchap.com/protoparser/internal/token"
+)
+
+// Validate checks the files of set as a parsed file is checked: the names
+// they use must refer to declarations of the file or of the files it
+// imports, and their declarations must follow the rules that protoc
+// enforces beyond the grammar. As the files may not have been parsed, it
+// also checks what the grammar guarantees, such as that every element has
+// a name. Each file may only import files that come before it in set. The
+// files of set are not modified.
+//
+// Every problem found is returned, not just the first. Problems are
+// reported at the source of the declaration they concern, if the file
+// has a SourceCodeInfo that records it, and at the file otherwise.
+func Validate(set *descriptorpb.FileDescriptorSet, mode Mode) scanner.ErrorList {
+	var (
+		errs  scanner.ErrorList
+		files []*descriptorpb.FileDescriptorProto
+		seen  = make(map[string]bool)
+	)
+	for _, f := range set.GetFile() {
+		fd := proto.Clone(f).(*descriptorpb.FileDescriptorProto)
+		var diags scanner.ErrorList
+		report := sourceReporter(fd, mode, &diags)
+		for i, dep := range fd.Dependency {
+			if !seen[dep] {
+				report([]int32{descriptor.FileDependencyTag, int32(i)}, scanner.SeverityError, scanner.CodeImport, fmt.Sprintf("import %q was not found", dep))
+			}
+		}
+		v := validator{fd: fd, report: report}
+		v.validateDescriptors()
+		resolveTypes(fd, files, true, nil, report)
+		v.validateFile()
+		v.validateConflicts(files)
+
+		// Problems are sorted by position within each file, and those
+		// without a position are kept in the order they were found.
+		sort.SliceStable(diags, func(i, j int) bool {
+			a, b := diags[i].Pos, diags[j].Pos
+			return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+		})
+		errs = append(errs, diags...)
+		files = append(files, fd)
+		seen[fd.GetName()] = true
+	}
+
+	if mode&WarningsAsErrors != 0 {
+		errs.PromoteWarnings()
+	}
+	return errs
+}
+
+// validateDescriptors checks what the parser guarantees of the files it
+// parses, but a file built by hand may lack: every element is named by an
+// identifier, and every field has a label, allowed by the syntax of the
+// file, and a type that agrees with its type name. It must be called
+// before the type names are resolved, which sets the types they imply.
+func (v *validator) validateDescriptors() {
+	for i, m := range v.fd.MessageType {
+		v.validateMessageDescriptor(m, []int32{descriptor.FileMessageTag, int32(i)})
+	}
+	for i, e := range v.fd.EnumType {
+		v.validateEnumDescriptor(e, []int32{descriptor.FileEnumTag, int32(i)})
+	}
+	for i, f := range v.fd.Extension {
+		v.validateFieldDescriptor(f, []int32{descriptor.FileExtensionTag, int32(i)})
+	}
+	for i, sd := range v.fd.Service {
+		path := []int32{descriptor.FileServiceTag, int32(i)}
+		v.validateName(sd.GetName(), path)
+		for j, md := range sd.Method {
+			v.validateName(md.GetName(), subpath(path, descriptor.ServiceMethodTag, int32(j)))
+		}
+	}
+}
+
+func (v *validator) validateMessageDescriptor(m *descriptorpb.DescriptorProto, path []int32) {
+	v.validateName(m.GetName(), path)
+	for i, f := range m.Field {
+		v.validateFieldDescriptor(f, subpath(path, descriptor.MessageFieldTag, int32(i)))
+	}
+	for i, n := range m.NestedType {
+		v.validateMessageDescriptor(n, subpath(path, descriptor.MessageNestedTag, int32(i)))
+	}
+	for i, e := range m.EnumType {
+		v.validateEnumDescriptor(e, subpath(path, descriptor.MessageEnumTag, int32(i)))
+	}
+	for i, f := range m.Extension {
+		v.validateFieldDescriptor(f, subpath(path, descriptor.MessageExtensionTag, int32(i)))
+	}
+	for i, o := range m.OneofDecl {
+		v.validateName(o.GetName(), subpath(path, descriptor.MessageOneofTag, int32(i)))
+	}
+}
+
+func (v *validator) validateEnumDescriptor(e *descriptorpb.EnumDescriptorProto, path []int32) {
+	v.validateName(e.GetName(), path)
+	for i, ev := range e.Value {
+		v.validateName(ev.GetName(), subpath(path, descriptor.EnumValueTag, int32(i)))
+	}
+}
+
+func (v *validator) validateFieldDescriptor(f *descriptorpb.FieldDescriptorProto, path []int32) {
+	v.validateName(f.GetName(), path)
+	name := f.GetName()
+	switch {
+	case f.Label == nil:
+		v.error(subpath(path, descriptor.FieldNameTag), fmt.Sprintf("field %q has no label", name))
+	case f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REQUIRED && v.fd.GetSyntax() == "proto3":
+		v.error(subpath(path, descriptor.FieldLabelTag), "required fields are not allowed in proto3")
+	}
+	switch f.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE,
+		descriptorpb.FieldDescriptorProto_TYPE_GROUP,
+		descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		if f.TypeName == nil {
+			v.error(subpath(path, descriptor.FieldTypeTag), fmt.Sprintf("field %q has a message or enum type, but no type name", name))
+		}
+	default:
+		switch {
+		case f.Type == nil && f.TypeName == nil:
+			v.error(subpath(path, descriptor.FieldNameTag), fmt.Sprintf("field %q has no type", name))
+		case f.Type != nil && f.TypeName != nil:
+			v.error(subpath(path, descriptor.FieldTypeNameTag), fmt.Sprintf("field %q has a scalar type, but also a type name", name))
+		}
+	}
+}
+
+// validateName checks that name, the name of the element at path, is an
+// identifier, as protoc checks it.
+func (v *validator) validateName(name string, path []int32) {
+	path = subpath(path, 1) // the name of every element has tag 1
+	if name == "" {
+		v.error(path, "missing name")
+		return
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c != '_' && !('a' <= c && c <= 'z') && !('A' <= c && c <= 'Z') && !('0' <= c && c <= '9') {
+			v.error(path, fmt.Sprintf("%q is not a valid identifier", name))
+			return
+		}
+	}
+}
+
+// sourceReporter returns the reportFunc of fd, which adds diagnostics to
+// errs at the positions recorded in the SourceCodeInfo of fd.
+func sourceReporter(fd *descriptorpb.FileDescriptorProto, mode Mode, errs *scanner.ErrorList) reportFunc {
+	spans := make(map[string][]int32)
+	for _, loc := range fd.GetSourceCodeInfo().GetLocation() {
+		key := pathKey(loc.Path)
+		if _, ok := spans[key]; !ok && len(loc.Span) >= 3 {
+			spans[key] = loc.Span
+		}
+	}
+	position := func(path []int32) token.Position {
+		pos := token.Position{Filename: fd.GetName()}
+		if span, ok := spans[pathKey(path)]; ok {
+			pos.Line = int(span[0]) + 1
+			pos.Column = int(span[1]) + 1
+		}
+		return pos
+	}
+
+	return func(path []int32, sev scanner.Severity, code, msg string, notes ...pathNote) {
+		if mode&ProtocErrors != 0 {
+			msg = protocMessage(msg)
+		}
+		e := &scanner.Error{Pos: position(path), Msg: msg, Severity: sev, Code: code}
+		for _, n := range notes {
+			e.Notes = append(e.Notes, &scanner.Note{Pos: position(n.path), Msg: n.msg})
+		}
+		*errs = append(*errs, e)
+	}
+}
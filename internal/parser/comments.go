@@ -0,0 +1,263 @@
+package parser
+
+import (
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"rogchap.com/protoparser/internal/token"
+)
+
+// When the IncludeSourceInfo mode is set, comments are attributed to the
+// declarations around them using protoc's rules:
+//
+//   - A comment that starts on the line on which a declaration ends, or
+//     on the next line if it is followed by a blank line, is the trailing
+//     comment of the declaration.
+//   - The comment that ends on the line before a declaration, or on the
+//     same line, is its leading comment.
+//   - Other comments before a declaration, separated from it by a blank
+//     line, are its detached comments.
+//
+// Consecutive line comments form a single comment; each block comment
+// stands alone. A comment that could belong to the declarations before
+// and after it is given to neither, and is detached instead.
+//
+// Comments are attributed between each pair of tokens, as they are
+// scanned. A declaration takes the leading and detached comments of its
+// first token, and the trailing comment of its last one: the ';' that
+// ends it, or the '{' that opens its body.
+
+// Comments that are given to no declaration, such as those at the end of
+// a block, are not recorded in the SourceCodeInfo. They are kept apart as
+// orphans, for the printers that must not lose them.
+
+// A comment is a comment token.
+type comment struct {
+	pos  token.Pos
+	text string // as written, including the comment markers
+}
+
+func (c comment) isLine() bool {
+	return strings.HasPrefix(c.text, "//")
+}
+
+// A Comment is a comment that the SourceCodeInfo of a file does not
+// record, as it was given to no declaration: one at the end of a block or
+// of the file, or one within a declaration.
+type Comment struct {
+	Span []int32 // as in a SourceCodeInfo location
+	Text string  // as in a SourceCodeInfo location
+}
+
+// docComments are the leading and detached comments of a declaration.
+type docComments struct {
+	leading  *string
+	detached []string
+}
+
+// startDecl starts the location of the declaration identified by path at
+// the current token, and gives it the comments before that token.
+func (p *parser) startDecl(path []int32) location {
+	l := p.startLoc(path)
+	l.setDoc(p.doc)
+	p.doc = docComments{}
+	return l
+}
+
+// setDoc sets the leading and detached comments of l.
+func (l location) setDoc(doc docComments) {
+	l.loc.LeadingComments = doc.leading
+	l.loc.LeadingDetachedComments = doc.detached
+}
+
+// moveDoc moves the leading and detached comments of from to l.
+func (l location) moveDoc(from location) {
+	l.setDoc(docComments{from.loc.LeadingComments, from.loc.LeadingDetachedComments})
+	from.setDoc(docComments{})
+}
+
+// endDecl gives the declaration at l the trailing comment of the token
+// just consumed.
+func (p *parser) endDecl(l location) {
+	l.loc.TrailingComments = p.trailing
+	p.trailing = nil
+}
+
+// attributeComments divides comments, found between the previous token
+// and the current one, into the trailing comment of the previous token
+// and the detached and leading comments of the current one. hasPrev
+// reports whether there is a previous token.
+func (p *parser) attributeComments(hasPrev bool, comments []comment) {
+	p.keepOrphans()
+	p.trailing, p.doc = nil, docComments{}
+	p.trailGroup, p.docGroups = nil, nil
+	if len(comments) == 0 {
+		return
+	}
+
+	var prevLine int
+	if hasPrev {
+		prevLine = p.file.Line(p.prevEnd - 1)
+	}
+	line := p.file.Line(p.pos)
+	if p.tok == token.EOF && len(p.src) > 0 && p.src[len(p.src)-1] == '\n' {
+		// EOF is at the start of the line after the final newline
+		line++
+	}
+
+	// A comment that starts on the same line as the previous token ends
+	// is its trailing comment, unless it is a block comment that is also
+	// on the same line as the current token, in which case it is not
+	// clear which token it belongs to.
+	var trail []comment
+	if hasPrev {
+		next := line
+		if p.tok == token.EOF && next == prevLine {
+			next++
+		}
+		c := comments[0]
+		if next > prevLine && p.file.Line(c.pos) == prevLine &&
+			(c.isLine() || len(comments) > 1 || p.commentEndLine(c) < next) {
+			trail, comments = comments[:1], comments[1:]
+		}
+	}
+
+	groups := p.groupComments(comments)
+	if hasPrev && trail == nil && len(groups) > 0 {
+		trail, groups = p.donateComments(prevLine, line, groups)
+	}
+
+	p.trailGroup, p.docGroups = trail, groups
+	if len(groups) == 0 {
+		p.trailing = p.commentText(trail)
+		return
+	}
+	if len(groups) == 1 && trail == nil && hasPrev {
+		// a comment between two tokens on the same lines as both is
+		// attached to neither
+		g := groups[0]
+		if p.file.Line(g[0].pos) == prevLine && p.commentEndLine(g[len(g)-1]) == line {
+			p.doc.detached = []string{*p.commentText(g)}
+			return
+		}
+	}
+	last := groups[len(groups)-1]
+	if p.commentEndLine(last[len(last)-1]) >= line-1 {
+		p.doc.leading = p.commentText(last)
+		groups = groups[:len(groups)-1]
+	}
+	for _, g := range groups {
+		p.doc.detached = append(p.doc.detached, *p.commentText(g))
+	}
+	p.trailing = p.commentText(trail)
+}
+
+// keepOrphans keeps the comments around the previous token that were not
+// given to a declaration, the trailing comment of the token before it and
+// the leading and detached comments of the token, as orphans.
+func (p *parser) keepOrphans() {
+	if p.trailing != nil {
+		p.orphan(p.trailGroup)
+	}
+	if p.doc.leading != nil || p.doc.detached != nil {
+		for _, g := range p.docGroups {
+			p.orphan(g)
+		}
+	}
+	p.trailing, p.doc = nil, docComments{}
+}
+
+// orphan keeps a group of comments as an orphan.
+func (p *parser) orphan(group []comment) {
+	last := group[len(group)-1]
+	l := location{loc: new(descriptorpb.SourceCodeInfo_Location), start: group[0].pos}
+	p.endLocAt(l, last.pos+token.Pos(len(last.text)))
+	p.orphans = append(p.orphans, Comment{Span: l.loc.Span, Text: *p.commentText(group)})
+}
+
+// donateComments decides whether the first of groups, none of which
+// starts on the line of the previous token, is the trailing comment of
+// that token. It returns the trailing comment, if any, and the remaining
+// groups.
+func (p *parser) donateComments(prevLine, line int, groups [][]comment) ([]comment, [][]comment) {
+	first := groups[0]
+	if p.file.Line(first[0].pos) > prevLine+1 {
+		// separated from the previous token by a blank line
+		return nil, groups
+	}
+	if len(groups) > 1 {
+		return first, groups[1:]
+	}
+	end := p.commentEndLine(first[len(first)-1])
+	if end < line-1 {
+		// separated from the current token by a blank line
+		return first, nil
+	}
+	switch p.tok {
+	case token.RBRACE, token.RBRACK, token.RPAREN, token.COMMA, token.SEMICOLON:
+		// the current token ends a scope, so needs no leading comment
+		if p.file.Line(first[0].pos) == prevLine && end == line {
+			return nil, groups
+		}
+		return first, nil
+	case token.EOF:
+		return first, nil
+	}
+	return nil, groups
+}
+
+// groupComments groups consecutive line comments together; each block
+// comment is a group of its own.
+func (p *parser) groupComments(comments []comment) [][]comment {
+	var groups [][]comment
+	start := 0
+	for i := 1; i < len(comments); i++ {
+		prev, c := comments[i-1], comments[i]
+		if !c.isLine() || !prev.isLine() || p.file.Line(c.pos) > p.commentEndLine(prev)+1 {
+			groups = append(groups, comments[start:i])
+			start = i
+		}
+	}
+	if start < len(comments) {
+		groups = append(groups, comments[start:])
+	}
+	return groups
+}
+
+// commentEndLine returns the line on which c ends.
+func (p *parser) commentEndLine(c comment) int {
+	return p.file.Line(c.pos + token.Pos(len(c.text)-1))
+}
+
+// commentText returns the text of a group of comments as protoc records
+// it: without the comment markers, keeping the newline that ends a line
+// comment, and without the leading whitespace and '*' of each line after
+// the first of a block comment. It returns nil for an empty group.
+func (p *parser) commentText(group []comment) *string {
+	if len(group) == 0 {
+		return nil
+	}
+	var sb strings.Builder
+	for _, c := range group {
+		if c.isLine() {
+			sb.WriteString(c.text[2:])
+			if end := p.file.Offset(c.pos) + len(c.text); end < len(p.src) && p.src[end] == '\n' {
+				sb.WriteByte('\n')
+			}
+			continue
+		}
+		text := strings.TrimPrefix(c.text, "/*")
+		text = strings.TrimSuffix(text, "*/")
+		for i, line := range strings.Split(text, "\n") {
+			if i > 0 {
+				sb.WriteByte('\n')
+				line = strings.TrimLeft(line, " \t")
+				line = strings.TrimPrefix(line, "*")
+			}
+			sb.WriteString(line)
+		}
+	}
+	s := sb.String()
+	return &s
+}
@@ -0,0 +1,103 @@
+package parser
+
+import (
+	"io"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"rogchap.com/protoparser/internal/scanner"
+)
+
+// WriteDescriptorSet parses the files filenames and the files they import,
+// as ParseFiles does, and writes them to w as a serialized
+// FileDescriptorSet, as protoc --descriptor_set_out writes it: custom
+// options are interpreted, those of source retention are removed, the
+// syntax of proto2 files is left unset, and the set is marshalled
+// deterministically.
+//
+// Unless includeImports is set, the set holds only the files filenames;
+// otherwise it holds the files they import too, as with protoc's
+// --include_imports. Either way, every file comes after the files it
+// imports, and files that do not depend on each other are in the order
+// given. The files have source info only in the IncludeSourceInfo mode.
+// The well-known files that are not parsed, other than descriptor.proto,
+// whose source is built in, are written as the Go protobuf runtime holds
+// them, which may differ from the protoc release's.
+//
+// Nothing is written if errors were found.
+func (c *Config) WriteDescriptorSet(w io.Writer, includeImports bool, filenames ...string) (scanner.ErrorList, error) {
+	conf := *c
+	conf.Mode |= InterpretOptions
+	l, err := conf.loadFiles(filenames)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.errors.Errors().Err(); err != nil {
+		return l.errors, err
+	}
+
+	files := l.order
+	if !includeImports {
+		files = l.requested(filenames)
+	}
+	s := newRetentionStripper(l)
+	set := &descriptorpb.FileDescriptorSet{File: make([]*descriptorpb.FileDescriptorProto, len(files))}
+	for i, f := range files {
+		fd := s.strip(f.fd)
+		if fd.GetSyntax() == "proto2" {
+			// protoc only records the syntax of proto3 files
+			fd.Syntax = nil
+		}
+		set.File[i] = fd
+	}
+
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(set)
+	if err != nil {
+		return l.errors, err
+	}
+	_, err = w.Write(b)
+	return l.errors, err
+}
+
+// requested returns the files filenames, each after those of them that it
+// imports, as protoc orders them: a file's imports come first, unless
+// they are not among filenames, in which case the files they import are
+// not looked at either.
+func (l *loader) requested(filenames []string) []*loadedFile {
+	var names []string
+	want := make(map[string]bool)
+	for _, filename := range filenames {
+		name := l.conf.ImportName(filename)
+		if !want[name] {
+			want[name] = true
+			names = append(names, name)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		for _, dep := range l.files[name].fd.Dependency {
+			if !want[dep] {
+				seen[dep] = true
+			}
+		}
+	}
+
+	var files []*loadedFile
+	var add func(name string)
+	add = func(name string) {
+		f := l.files[name]
+		if seen[name] || f == nil {
+			return
+		}
+		seen[name] = true
+		for _, dep := range f.fd.Dependency {
+			add(dep)
+		}
+		files = append(files, f)
+	}
+	for _, name := range names {
+		add(name)
+	}
+	return files
+}
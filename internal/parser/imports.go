@@ -0,0 +1,357 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"rogchap.com/protoparser/internal/descriptor"
+	"rogchap.com/protoparser/internal/scanner"
+	"rogchap.com/protoparser/internal/token"
+)
+
+// A Config controls the parsing of proto files together with the files
+// they import.
+type Config struct {
+	// Mode controls optional parser functionality.
+	Mode Mode
+
+	// ImportPaths are the directories in which imported files are looked
+	// up, in order, as with protoc's -I flag. If empty, imports are looked
+	// up relative to the current directory. Well-known files, such as
+	// google/protobuf/timestamp.proto and google/protobuf/descriptor.proto,
+	// that are not found in the import paths are provided by the parser.
+	ImportPaths []string
+
+	// Accessor opens the file at path for reading. If nil, os.Open is
+	// used.
+	Accessor func(path string) (io.ReadCloser, error)
+
+	// Resolver, if not nil, provides the imported files that are not
+	// found in the import paths, such as the files registered in
+	// protoregistry.GlobalFiles by generated Go packages.
+	Resolver protodesc.Resolver
+}
+
+// ParseFile parses the source of a proto file and of the files it
+// imports, transitively, and returns the FileDescriptorProto of the file
+// with the type names it uses resolved. If src != nil, it is the source
+// of the file, as for the package function ParseFile; otherwise the file
+// is read as described for ParseFiles.
+//
+// The Name of the descriptor is the path of the file relative to the
+// import path that contains it, which is how other files import it. The
+// diagnostics are those of every file parsed.
+func (c *Config) ParseFile(filename string, src interface{}) (*descriptorpb.FileDescriptorProto, scanner.ErrorList, error) {
+	l := newLoader(c)
+	name := c.ImportName(filename)
+	if src != nil {
+		source, err := readSource(filename, src)
+		if err != nil {
+			return nil, nil, err
+		}
+		l.parse(name, filename, source)
+	} else if err := l.parseFile(filename); err != nil {
+		return nil, nil, err
+	}
+	l.errors.RemoveDuplicates()
+	return l.files[name].fd, l.errors, l.errors.Errors().Err()
+}
+
+// ParseFiles parses the files filenames and the files they import,
+// transitively, and returns their descriptors as a FileDescriptorSet, in
+// which every file comes after the files it imports. A file imported by
+// several others is parsed only once.
+//
+// Each file is opened by its path, as given. A file that cannot be found
+// that way is looked up in the import paths, as an import would be.
+//
+// If a file can't be read, the result is nil and the error indicates the
+// specific failure. Otherwise, the diagnostics are those of every file
+// parsed, and the error is a scanner.ErrorList of those with severity
+// SeverityError.
+func (c *Config) ParseFiles(filenames ...string) (*descriptorpb.FileDescriptorSet, scanner.ErrorList, error) {
+	l, err := c.loadFiles(filenames)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	set := &descriptorpb.FileDescriptorSet{File: make([]*descriptorpb.FileDescriptorProto, len(l.order))}
+	for i, f := range l.order {
+		set.File[i] = f.fd
+	}
+	return set, l.errors, l.errors.Errors().Err()
+}
+
+// loadFiles parses the files filenames and the files they import.
+func (c *Config) loadFiles(filenames []string) (*loader, error) {
+	l := newLoader(c)
+	for _, filename := range filenames {
+		if err := l.parseFile(filename); err != nil {
+			return nil, err
+		}
+	}
+	l.errors.RemoveDuplicates()
+	return l, nil
+}
+
+// importPaths returns the directories in which imports are looked up.
+func (c *Config) importPaths() []string {
+	if len(c.ImportPaths) == 0 {
+		return []string{"."}
+	}
+	return c.ImportPaths
+}
+
+// ImportName returns the name by which the file filename is imported:
+// its path relative to the first import path that contains it, or the
+// path itself if there is none.
+func (c *Config) ImportName(filename string) string {
+	filename = filepath.Clean(filename)
+	for _, dir := range c.importPaths() {
+		rel, err := filepath.Rel(filepath.Clean(dir), filename)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.ToSlash(filename)
+}
+
+// read returns the contents of the file at path.
+func (c *Config) read(path string) ([]byte, error) {
+	open := c.Accessor
+	if open == nil {
+		open = func(path string) (io.ReadCloser, error) { return os.Open(path) }
+	}
+	rc, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// find looks up the file imported as name in the import paths, and
+// returns its path and contents.
+func (c *Config) find(name string) (string, []byte, bool) {
+	for _, dir := range c.importPaths() {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if src, err := c.read(path); err == nil {
+			return path, src, true
+		}
+	}
+	return "", nil, false
+}
+
+// A loader parses files and the files they import, each only once.
+type loader struct {
+	conf   *Config
+	files  map[string]*loadedFile // by import name; nil if not found
+	order  []*loadedFile          // files, each after those it imports
+	stack  []*importing           // files whose imports are being loaded
+	errors scanner.ErrorList      // diagnostics of every file
+}
+
+// An importing file is one whose imports are being loaded.
+type importing struct {
+	p   *parser
+	fd  *descriptorpb.FileDescriptorProto
+	dep int // index of the import being loaded
+}
+
+func newLoader(conf *Config) *loader {
+	return &loader{conf: conf, files: make(map[string]*loadedFile)}
+}
+
+// parseFile parses the file filename, unless it has already been parsed
+// as an import.
+func (l *loader) parseFile(filename string) error {
+	name := l.conf.ImportName(filename)
+	if l.files[name] != nil {
+		return nil
+	}
+	src, err := l.conf.read(filename)
+	if err != nil {
+		path, found, ok := l.conf.find(name)
+		if !ok {
+			return err
+		}
+		filename, src = path, found
+	}
+	l.parse(name, filename, src)
+	return nil
+}
+
+type loadedFile struct {
+	fd      *descriptorpb.FileDescriptorProto
+	p       *parser // the parser of the file; nil if it was not parsed
+	done    bool    // its imports have been loaded
+	failed  bool    // errors were found in it
+	builtin bool    // the built-in descriptor.proto, or a copy of it
+}
+
+// load returns the file imported as name, parsing it if it has not been
+// parsed yet, or nil if it cannot be found.
+func (l *loader) load(name string) *loadedFile {
+	if f, ok := l.files[name]; ok {
+		return f
+	}
+	if path, src, ok := l.conf.find(name); ok {
+		return l.parse(name, path, src)
+	}
+	if r := l.conf.Resolver; r != nil {
+		if fd, err := r.FindFileByPath(name); err == nil {
+			return l.loadDescriptor(name, fd)
+		}
+	}
+	if name == descriptorFile {
+		// parsed from source, as protoc parses the one in its include
+		// directory
+		return l.parse(name, name, descriptorSource)
+	}
+	if fd, ok := wellKnownFiles[name]; ok {
+		return l.loadDescriptor(name, fd)
+	}
+	l.files[name] = nil
+	return nil
+}
+
+// loadDescriptor returns the file imported as name, which is compiled as
+// fd, and loads its imports.
+func (l *loader) loadDescriptor(name string, fd protoreflect.FileDescriptor) *loadedFile {
+	f := &loadedFile{fd: protodesc.ToFileDescriptorProto(fd)}
+	l.files[name] = f
+	for _, dep := range f.fd.Dependency {
+		l.load(dep)
+	}
+	f.done = true
+	l.order = append(l.order, f)
+	return f
+}
+
+// parse parses src, the contents of the file at path imported as name,
+// loads its imports and resolves the type names it uses.
+func (l *loader) parse(name, path string, src []byte) *loadedFile {
+	mode := l.conf.Mode
+	if mode&ProtocErrors != 0 {
+		// protoc reports positions in files by their import names
+		path = name
+	}
+
+	var p parser
+	p.init(path, src, mode)
+	fd := p.parseFile()
+	fd.Name = strPtr(name)
+	valid := len(p.errors.Errors()) == 0
+	f := &loadedFile{fd: fd, p: &p, builtin: name == descriptorFile && bytes.Equal(src, descriptorSource)}
+	l.files[name] = f
+
+	top := &importing{p: &p, fd: fd}
+	l.stack = append(l.stack, top)
+	failed := make(map[string]bool) // the imports reported
+	for i, dep := range fd.Dependency {
+		top.dep = i
+		pos := p.pathPos(descriptor.FileDependencyTag, int32(i))
+		switch d := l.load(dep); {
+		case d == nil:
+			p.report(pos, 0, scanner.SeverityError, scanner.CodeImport, fmt.Sprintf("import %q was not found", dep))
+		case !d.done:
+			l.cycle(pos, dep)
+		case d.failed:
+			p.report(pos, 0, scanner.SeverityError, scanner.CodeImport, fmt.Sprintf("import %q had errors", dep))
+		default:
+			continue
+		}
+		failed[dep] = true
+	}
+	l.stack = l.stack[:len(l.stack)-1]
+	f.done = true
+	// Names that can't be resolved in a file with syntax errors are most
+	// likely left by the recovery, so they are only reported if it has none.
+	resolveTypes(fd, l.others(name), valid, failed, p.reportPath)
+	if valid {
+		p.validate(fd, l.finished())
+	}
+	if len(p.errors.Errors()) == 0 {
+		// The options messages are those of the descriptor.proto parsed
+		// from source, or else protoc's own, whose options protoc merges.
+		desc, merge := builtinDescriptor, true
+		if d := l.files[descriptorFile]; d != nil && d.p != nil {
+			desc = func() *descriptorpb.FileDescriptorProto { return d.fd }
+			merge = d.builtin
+		}
+		p.interpretOptions(fd, l.others(name), desc, merge, mode&InterpretOptions != 0)
+	}
+	l.order = append(l.order, f)
+
+	if mode&WarningsAsErrors != 0 {
+		p.errors.PromoteWarnings()
+	}
+	f.failed = len(p.errors.Errors()) > 0
+	l.errors = append(l.errors, p.errors...)
+	return f
+}
+
+// cycle reports that the import of name at pos, by the file on top of
+// the stack, completes a cycle. The error lists the files in the cycle,
+// with a note at each of the other imports that form it.
+func (l *loader) cycle(pos token.Pos, name string) {
+	i := len(l.stack) - 1
+	for i > 0 && l.stack[i].fd.GetName() != name {
+		i--
+	}
+	cycle := l.stack[i:]
+
+	names := make([]string, 0, len(cycle)+1)
+	var notes []*scanner.Note
+	for _, f := range cycle {
+		names = append(names, f.fd.GetName())
+	}
+	for _, f := range cycle[:len(cycle)-1] {
+		dep := f.fd.Dependency[f.dep]
+		pos := f.p.pathPos(descriptor.FileDependencyTag, int32(f.dep))
+		notes = append(notes, f.p.note(pos, 0, fmt.Sprintf("%s imports %q here", f.fd.GetName(), dep)))
+	}
+	names = append(names, name)
+
+	p := cycle[len(cycle)-1].p
+	p.report(pos, 0, scanner.SeverityError, scanner.CodeImport, "import cycle: "+strings.Join(names, " -> "), notes...)
+}
+
+// finished returns the files that were loaded without errors, in the
+// order in which their loading finished.
+func (l *loader) finished() []*descriptorpb.FileDescriptorProto {
+	var files []*descriptorpb.FileDescriptorProto
+	for _, f := range l.order {
+		if !f.failed {
+			files = append(files, f.fd)
+		}
+	}
+	return files
+}
+
+// others returns the files loaded so far, other than the file imported
+// as name, sorted by name.
+func (l *loader) others(name string) []*descriptorpb.FileDescriptorProto {
+	names := make([]string, 0, len(l.files))
+	for n, f := range l.files {
+		if f != nil && n != name {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	files := make([]*descriptorpb.FileDescriptorProto, len(names))
+	for i, n := range names {
+		files[i] = l.files[n].fd
+	}
+	return files
+}
@@ -7,6 +7,8 @@ import (
 	"io/ioutil"
 
 	"google.golang.org/protobuf/types/descriptorpb"
+
+	"rogchap.com/protoparser/internal/scanner"
 )
 
 func readSource(filename string, src interface{}) ([]byte, error) {
@@ -28,15 +30,80 @@ func readSource(filename string, src interface{}) ([]byte, error) {
 	return ioutil.ReadFile(filename)
 }
 
-// ParseFile TODO
-func ParseFile(filename string, src interface{}) (*descriptorpb.FileDescriptorProto, error) {
+// A Mode value is a set of flags (or 0).
+// They control optional parser functionality.
+type Mode uint
+
+const (
+	// WarningsAsErrors reports warnings with severity SeverityError.
+	WarningsAsErrors Mode = 1 << iota
+
+	// ProtocErrors words diagnostics as protoc does, and counts columns
+	// as protoc does, expanding tabs to multiples of eight.
+	ProtocErrors
+
+	// IncludeSourceInfo records the source location of every element of
+	// the file in its SourceCodeInfo, using the paths and spans that protoc
+	// would use, along with the comments attached to each declaration.
+	IncludeSourceInfo
+
+	// InterpretOptions interprets the custom options of linked files as
+	// protoc does: their values are encoded into the unknown fields of the
+	// options messages, rather than kept as uninterpreted options.
+	InterpretOptions
+)
+
+// ParseFile parses the source of a single proto file and returns the
+// corresponding FileDescriptorProto, along with every diagnostic found
+// sorted by source position.
+//
+// If the source couldn't be read, the returned descriptor is nil and the
+// error indicates the specific failure. If the source was read but errors
+// were found, the result is a partial descriptor containing every
+// declaration that could be recovered, and the error is a
+// scanner.ErrorList of the diagnostics with severity SeverityError.
+func ParseFile(filename string, src interface{}, mode Mode) (*descriptorpb.FileDescriptorProto, scanner.ErrorList, error) {
 	source, err := readSource(filename, src)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	var p parser
-	p.init(source)
+	p.init(filename, source, mode)
+	fd := p.parseFile()
+	valid := len(p.errors.Errors()) == 0
+	resolveTypes(fd, nil, false, nil, p.reportPath)
+	if valid {
+		p.validate(fd, nil)
+	}
+	if len(p.errors.Errors()) == 0 {
+		// custom options are only interpreted in linked files
+		p.interpretOptions(fd, nil, builtinDescriptor, true, false)
+	}
 
-	return p.parseFile(), nil
+	if mode&WarningsAsErrors != 0 {
+		p.errors.PromoteWarnings()
+	}
+	p.errors.RemoveDuplicates()
+	return fd, p.errors, p.errors.Errors().Err()
+}
+
+// ParseSource parses the source of a single proto file that is to be
+// printed back as source, as a formatter does. The file is parsed as in
+// the IncludeSourceInfo mode, but the names it uses are kept as they are
+// written, and its declarations are not checked: only syntax errors are
+// reported, as a scanner.ErrorList.
+//
+// Along with the file, ParseSource returns the comments that its
+// SourceCodeInfo does not record, in source order.
+func ParseSource(filename string, src []byte) (*descriptorpb.FileDescriptorProto, []Comment, error) {
+	var p parser
+	p.init(filename, src, IncludeSourceInfo)
+	fd := p.parseFile()
+	p.keepOrphans()
+	p.errors.Sort()
+	if err := p.errors.Errors().Err(); err != nil {
+		return nil, nil, err
+	}
+	return fd, p.orphans, nil
 }
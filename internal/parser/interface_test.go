@@ -1,19 +1,32 @@
 package parser_test
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
 
 	"rogchap.com/protoparser/internal/parser"
+	"rogchap.com/protoparser/internal/scanner"
 )
 
 func TestParseFile(t *testing.T) {
 	filename := filepath.Join("testdata", "test.proto")
-	pb, err := parser.ParseFile(filename, nil)
+	pb, _, err := parser.ParseFile(filename, nil, 0)
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
@@ -31,3 +44,1712 @@ func TestParseFile(t *testing.T) {
 		t.Errorf("ParseFile() mismatch (-want +got):\n%s", diff)
 	}
 }
+
+func TestParseFileErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		errs   []string
+		msgs   []string // names of the messages that were recovered
+		fields int      // number of fields recovered in the first message
+	}{
+		{
+			name: "missing field number",
+			src:  "syntax = \"proto3\";\nmessage Foo {\n  int32 a = ;\n  string b = 2;\n}\nmessage Bar {\n  Foo f = 1\n}\n",
+			errs: []string{
+				"test.proto:3:13: expected field number, found ';'",
+				"test.proto:8:1: expected ';', found '}'",
+			},
+			msgs:   []string{"Foo", "Bar"},
+			fields: 2,
+		},
+		{
+			name:   "missing semicolon after syntax",
+			src:    "syntax = \"proto3\"\npackage foo;\nmessage A { int32 x = 1; }",
+			errs:   []string{"test.proto:2:1: expected ';', found 'package'"},
+			msgs:   []string{"A"},
+			fields: 1,
+		},
+		{
+			name: "stray tokens",
+			src:  "syntax = \"proto3\";\n}}}} message A {}\n;;; foo bar baz;\nmessage B { int32 a = 1; }",
+			errs: []string{
+				"test.proto:2:1: expected top-level declaration, found '}'",
+				"test.proto:3:5: expected top-level declaration, found foo",
+			},
+			msgs: []string{"A", "B"},
+		},
+		{
+			name:   "unterminated message",
+			src:    "syntax = \"proto3\";\nmessage A {\n  int32 x = 1;\nmessage B {}\n",
+			errs:   []string{"test.proto:4:14: expected '}', found 'EOF'"},
+			msgs:   []string{"A"},
+			fields: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pb, _, err := parser.ParseFile("test.proto", tt.src, 0)
+			if pb == nil {
+				t.Fatal("expected a partial descriptor")
+			}
+
+			var got []string
+			if errs, ok := err.(scanner.ErrorList); ok {
+				for _, e := range errs {
+					got = append(got, e.Error())
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error type %T: %v", err, err)
+			}
+			if diff := cmp.Diff(tt.errs, got); diff != "" {
+				t.Errorf("errors mismatch (-want +got):\n%s", diff)
+			}
+
+			var msgs []string
+			for _, m := range pb.GetMessageType() {
+				msgs = append(msgs, m.GetName())
+			}
+			if diff := cmp.Diff(tt.msgs, msgs); diff != "" {
+				t.Errorf("messages mismatch (-want +got):\n%s", diff)
+			}
+			if len(msgs) > 0 {
+				if n := len(pb.GetMessageType()[0].GetField()); n != tt.fields {
+					t.Errorf("got %d fields, want %d", n, tt.fields)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFileNesting(t *testing.T) {
+	src := strings.Repeat("message M {", 10000) + strings.Repeat("}", 10000)
+	_, _, err := parser.ParseFile("test.proto", src, 0)
+	if err == nil || !strings.Contains(err.Error(), "exceeded maximum message nesting depth") {
+		t.Errorf("expected nesting depth error, got %v", err)
+	}
+}
+
+func FuzzParseFile(f *testing.F) {
+	files, _ := filepath.Glob(filepath.Join("testdata", "*.proto"))
+	for _, name := range files {
+		src, err := ioutil.ReadFile(name)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(src)
+	}
+
+	f.Fuzz(func(t *testing.T, src []byte) {
+		pb, _, err := parser.ParseFile("fuzz.proto", src, parser.IncludeSourceInfo)
+		if pb == nil {
+			t.Fatalf("no descriptor returned, err = %v", err)
+		}
+		if _, merr := proto.Marshal(pb); merr != nil {
+			t.Fatalf("invalid descriptor: %v", merr)
+		}
+		if err == nil {
+			return
+		}
+		errs, ok := err.(scanner.ErrorList)
+		if !ok {
+			t.Fatalf("unexpected error type %T: %v", err, err)
+		}
+		for _, e := range errs {
+			if !e.Pos.IsValid() || e.Pos.Offset > len(src) {
+				t.Errorf("error with invalid position: %v", e)
+			}
+		}
+	})
+}
+
+func TestParseFileDiagnostics(t *testing.T) {
+	src := "message foo_bar {\n  optional int32 BadName = 1;\n}\nenum E { zero = 0; }\n"
+	want := []struct {
+		sev  scanner.Severity
+		code string
+		msg  string
+	}{
+		{scanner.SeverityWarning, scanner.CodeMissingSyntax, "test.proto:1:1: warning: no syntax specified; defaulting to proto2 syntax"},
+		{scanner.SeverityInfo, scanner.CodeNaming, "test.proto:1:9: info: message name foo_bar should be CamelCase"},
+		{scanner.SeverityInfo, scanner.CodeNaming, "test.proto:2:18: info: field name BadName should be lower_snake_case"},
+		{scanner.SeverityInfo, scanner.CodeNaming, "test.proto:4:10: info: enum value name zero should be UPPER_SNAKE_CASE"},
+	}
+
+	_, diags, err := parser.ParseFile("test.proto", src, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("got %d diagnostics, want %d: %v", len(diags), len(want), diags)
+	}
+	for i, d := range diags {
+		if d.Severity != want[i].sev || d.Code != want[i].code || d.Error() != want[i].msg {
+			t.Errorf("diagnostic %d = (%v, %s, %q), want (%v, %s, %q)", i, d.Severity, d.Code, d.Error(), want[i].sev, want[i].code, want[i].msg)
+		}
+	}
+
+	// In strict mode the missing syntax warning fails the parse.
+	_, _, err = parser.ParseFile("test.proto", src, parser.WarningsAsErrors)
+	if got := "test.proto:1:1: no syntax specified; defaulting to proto2 syntax"; err == nil || err.Error() != got {
+		t.Errorf("strict mode: got error %v, want %q", err, got)
+	}
+}
+
+func TestParseFileProtocErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"syntax = \"proto3\";\nmessage Foo {\n\tint32 a = ;\n}\n", "foo/test.proto:3:19: Expected field number."},
+		{"syntax = \"proto3\"\nmessage Foo {}\n", `foo/test.proto:2:1: Expected ";".`},
+		{"syntax = \"proto4\";\n", `foo/test.proto:1:10: Unrecognized syntax identifier "proto4".  This parser only recognizes "proto2" and "proto3".`},
+		{"syntax = \"proto3\";\nfoo", `foo/test.proto:2:1: Expected top-level statement (e.g. "message").`},
+		{"syntax = \"proto3\";\nmessage Foo { required int32 a = 1; }", "foo/test.proto:2:15: Required fields are not allowed in proto3."},
+		{"syntax = \"proto3\";\noption java_pakage = \"x\";", `foo/test.proto:2:8: Option "java_pakage" unknown.`},
+		{"syntax = \"proto3\";\nmessage Foo { string s = 1 [default = \"a\\q\"]; }", "foo/test.proto:2:41: Invalid escape sequence in string literal."},
+	}
+
+	for _, tt := range tests {
+		_, _, err := parser.ParseFile("foo/test.proto", tt.src, parser.ProtocErrors)
+		errs, ok := err.(scanner.ErrorList)
+		if !ok || len(errs) == 0 {
+			t.Errorf("%q: expected errors, got %v", tt.src, err)
+			continue
+		}
+		if got := errs[0].Error(); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseFileSnippets(t *testing.T) {
+	src := "syntax = \"proto3\";\npackage foo;\nmessage Foo {\n\tint32 a = bar;\n}\npackage foo.bar;\n"
+	want := `test.proto:4:12: expected field number, found bar
+	int32 a = bar;
+	          ^~~
+test.proto:6:1: multiple package declarations
+package foo.bar;
+^
+test.proto:2:1: note: previously defined here
+package foo;
+^~~~~~~
+`
+	_, _, err := parser.ParseFile("test.proto", src, 0)
+	var buf bytes.Buffer
+	scanner.PrintError(&buf, err)
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("PrintError mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseFileSourceInfo(t *testing.T) {
+	src := `syntax = "proto2";
+package foo;
+import public "a.proto";
+option java_package = "foo";
+message Foo {
+  optional int32 a = 1 [default = -1, json_name = "A", deprecated = true];
+  repeated group G = 2 { required string s = 1; }
+  oneof o { Foo f = 3; }
+  map<string, Foo> m = 4;
+  extensions 10 to 20;
+  reserved 5;
+  reserved "b";
+}
+enum E { E_ZERO = 0 [deprecated = true]; }
+service S {
+  rpc M(stream Foo) returns (Foo);
+}
+extend Foo { optional E e = 10; }
+`
+	// paths and spans in the order protoc records them
+	want := []string{
+		"[] [0 0 17 33]",
+		"[12] [0 0 18]",
+		"[2] [1 0 12]",
+		"[3 0] [2 0 24]",
+		"[10 0] [2 7 13]",
+		"[8] [3 0 28]",
+		"[8 1] [3 0 28]",
+		"[4 0] [4 0 12 1]",
+		"[4 0 1] [4 8 11]",
+		"[4 0 2 0] [5 2 74]",
+		"[4 0 2 0 4] [5 2 10]",
+		"[4 0 2 0 5] [5 11 16]",
+		"[4 0 2 0 1] [5 17 18]",
+		"[4 0 2 0 3] [5 21 22]",
+		"[4 0 2 0 8] [5 23 73]",
+		"[4 0 2 0 7] [5 34 36]",
+		"[4 0 2 0 10] [5 38 53]",
+		"[4 0 2 0 10] [5 50 53]",
+		"[4 0 2 0 8 3] [5 55 72]",
+		"[4 0 2 1] [6 2 49]",
+		"[4 0 2 1 4] [6 2 10]",
+		"[4 0 2 1 5] [6 11 16]",
+		"[4 0 2 1 1] [6 17 18]",
+		"[4 0 2 1 3] [6 21 22]",
+		"[4 0 3 0] [6 2 49]",
+		"[4 0 3 0 1] [6 17 18]",
+		"[4 0 2 1 6] [6 17 18]",
+		"[4 0 3 0 2 0] [6 25 47]",
+		"[4 0 3 0 2 0 4] [6 25 33]",
+		"[4 0 3 0 2 0 5] [6 34 40]",
+		"[4 0 3 0 2 0 1] [6 41 42]",
+		"[4 0 3 0 2 0 3] [6 45 46]",
+		"[4 0 8 0] [7 2 24]",
+		"[4 0 8 0 1] [7 8 9]",
+		"[4 0 2 2] [7 12 22]",
+		"[4 0 2 2 6] [7 12 15]",
+		"[4 0 2 2 1] [7 16 17]",
+		"[4 0 2 2 3] [7 20 21]",
+		"[4 0 2 3] [8 2 25]",
+		"[4 0 2 3 6] [8 2 18]",
+		"[4 0 2 3 1] [8 19 20]",
+		"[4 0 2 3 3] [8 23 24]",
+		"[4 0 5] [9 2 22]",
+		"[4 0 5 0] [9 13 21]",
+		"[4 0 5 0 1] [9 13 15]",
+		"[4 0 5 0 2] [9 19 21]",
+		"[4 0 9] [10 2 13]",
+		"[4 0 9 0] [10 11 12]",
+		"[4 0 9 0 1] [10 11 12]",
+		"[4 0 9 0 2] [10 11 12]",
+		"[4 0 10] [11 2 15]",
+		"[4 0 10 0] [11 11 14]",
+		"[5 0] [13 0 42]",
+		"[5 0 1] [13 5 6]",
+		"[5 0 2 0] [13 9 40]",
+		"[5 0 2 0 1] [13 9 15]",
+		"[5 0 2 0 2] [13 18 19]",
+		"[5 0 2 0 3] [13 20 39]",
+		"[5 0 2 0 3 1] [13 21 38]",
+		"[6 0] [14 0 16 1]",
+		"[6 0 1] [14 8 9]",
+		"[6 0 2 0] [15 2 34]",
+		"[6 0 2 0 1] [15 6 7]",
+		"[6 0 2 0 5] [15 8 14]",
+		"[6 0 2 0 2] [15 15 18]",
+		"[6 0 2 0 3] [15 29 32]",
+		"[7] [17 0 33]",
+		"[7 0] [17 13 31]",
+		"[7 0 2] [17 7 10]",
+		"[7 0 4] [17 13 21]",
+		"[7 0 6] [17 22 23]",
+		"[7 0 1] [17 24 25]",
+		"[7 0 3] [17 28 30]",
+	}
+
+	fd, _, err := parser.ParseFile("test.proto", src, parser.IncludeSourceInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, loc := range fd.GetSourceCodeInfo().GetLocation() {
+		got = append(got, fmt.Sprint(loc.Path, " ", loc.Span))
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("locations mismatch (-want +got):\n%s", diff)
+	}
+
+	fd, _, _ = parser.ParseFile("test.proto", src, 0)
+	if fd.SourceCodeInfo != nil {
+		t.Errorf("got SourceCodeInfo without IncludeSourceInfo")
+	}
+}
+
+func TestParseFileComments(t *testing.T) {
+	src := `// detached
+
+// Leading for syntax.
+syntax = "proto3"; // trailing for syntax
+
+/*
+ * Foo is a message.
+ */
+message Foo { // trailing for Foo
+  // leading for a
+  int32 a = 1;
+  // trailing for a
+
+  int32 b = 2; /* trailing for b */ /* detached */ /* leading for c */ int32 c = 3;
+}
+`
+	type comments struct {
+		Leading, Trailing string
+		Detached          []string
+	}
+	want := map[string]comments{
+		"[12]":      {Leading: " Leading for syntax.\n", Trailing: " trailing for syntax\n", Detached: []string{" detached\n"}},
+		"[4 0]":     {Leading: "\n Foo is a message.\n", Trailing: " trailing for Foo\n"},
+		"[4 0 2 0]": {Leading: " leading for a\n", Trailing: " trailing for a\n"},
+		"[4 0 2 1]": {Trailing: " trailing for b "},
+		"[4 0 2 2]": {Leading: " leading for c ", Detached: []string{" detached "}},
+	}
+
+	fd, _, err := parser.ParseFile("test.proto", src, parser.IncludeSourceInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]comments)
+	for _, loc := range fd.GetSourceCodeInfo().GetLocation() {
+		c := comments{loc.GetLeadingComments(), loc.GetTrailingComments(), loc.LeadingDetachedComments}
+		if c.Leading != "" || c.Trailing != "" || len(c.Detached) > 0 {
+			got[fmt.Sprint(loc.Path)] = c
+		}
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("comments mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseSource(t *testing.T) {
+	src := `syntax = "proto3";
+message Foo {
+  int32 a = 1 /* within a */;
+
+  // at the end of Foo
+}
+// at the end of the file
+`
+	want := []parser.Comment{
+		{Span: []int32{2, 14, 28}, Text: " within a "},
+		{Span: []int32{4, 2, 22}, Text: " at the end of Foo\n"},
+		{Span: []int32{6, 0, 25}, Text: " at the end of the file\n"},
+	}
+
+	fd, got, err := parser.ParseSource("test.proto", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fd.SourceCodeInfo == nil {
+		t.Error("ParseSource() returned no source info")
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ParseSource() comments mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, _, err := parser.ParseSource("test.proto", []byte("message {")); err == nil {
+		t.Error("ParseSource() of an invalid file returned no error")
+	}
+}
+
+// mapAccessor returns an Accessor that reads files from m.
+func mapAccessor(m map[string]string) func(string) (io.ReadCloser, error) {
+	return func(path string) (io.ReadCloser, error) {
+		src, ok := m[filepath.ToSlash(path)]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return ioutil.NopCloser(strings.NewReader(src)), nil
+	}
+}
+
+func TestConfigParseFile(t *testing.T) {
+	files := map[string]string{
+		"proto/foo/foo.proto": `syntax = "proto3";
+package foo;
+import "bar/bar.proto";
+message Foo {
+  bar.Bar bar = 1;
+  baz.Baz baz = 2;
+}
+`,
+		"vendor/bar/bar.proto": `syntax = "proto3";
+package bar;
+import public "baz.proto";
+message Bar {}
+`,
+		"vendor/baz.proto": `syntax = "proto3";
+package baz;
+enum Baz { BAZ_ZERO = 0; }
+`,
+	}
+	conf := parser.Config{
+		ImportPaths: []string{"proto", "vendor"},
+		Accessor:    mapAccessor(files),
+	}
+	fd, _, err := conf.ParseFile(filepath.FromSlash("proto/foo/foo.proto"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fd.GetName(), "foo/foo.proto"; got != want {
+		t.Errorf("got name %q, want %q", got, want)
+	}
+	fields := fd.MessageType[0].Field
+	if got, want := fields[0].GetTypeName(), ".bar.Bar"; got != want {
+		t.Errorf("got type name %q, want %q", got, want)
+	}
+	if got, want := fields[1].GetType(), descriptorpb.FieldDescriptorProto_TYPE_ENUM; got != want {
+		t.Errorf("got type %v, want %v", got, want)
+	}
+}
+
+func TestConfigParseFiles(t *testing.T) {
+	files := map[string]string{
+		"a.proto": "syntax = \"proto3\";\nimport \"b.proto\";\nimport \"c.proto\";\nmessage A { B b = 1; C c = 2; }\n",
+		"b.proto": "syntax = \"proto3\";\nimport \"d.proto\";\nmessage B { D d = 1; }\n",
+		"c.proto": "syntax = \"proto3\";\nimport \"d.proto\";\nmessage C { D d = 1; }\n",
+		"d.proto": "syntax = \"proto3\";\nmessage D {}\n",
+	}
+	conf := parser.Config{Accessor: mapAccessor(files)}
+	set, _, err := conf.ParseFiles("c.proto", "a.proto", "d.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, fd := range set.File {
+		names = append(names, fd.GetName())
+	}
+	want := []string{"d.proto", "c.proto", "b.proto", "a.proto"}
+	if diff := cmp.Diff(want, names); diff != "" {
+		t.Errorf("files mismatch (-want +got):\n%s", diff)
+	}
+
+	// every file can be built from the files before it
+	reg := new(protoregistry.Files)
+	for _, fd := range set.File {
+		f, err := protodesc.NewFile(fd, reg)
+		if err != nil {
+			t.Fatalf("%s: %v", fd.GetName(), err)
+		}
+		if err := reg.RegisterFile(f); err != nil {
+			t.Fatalf("%s: %v", fd.GetName(), err)
+		}
+	}
+
+	if _, _, err := conf.ParseFiles("a.proto", "missing.proto"); !os.IsNotExist(err) {
+		t.Errorf("got error %v, want not exist", err)
+	}
+}
+
+func TestConfigParseFilesWellKnown(t *testing.T) {
+	files := map[string]string{
+		"foo.proto": `syntax = "proto3";
+import "google/protobuf/descriptor.proto";
+import "google/protobuf/field_mask.proto";
+import "google/protobuf/timestamp.proto";
+extend google.protobuf.FieldOptions { string label = 50000; }
+message Foo {
+  google.protobuf.Timestamp time = 1;
+  google.protobuf.FieldMask mask = 2;
+}
+`,
+	}
+	conf := parser.Config{Accessor: mapAccessor(files)}
+	set, _, err := conf.ParseFiles("foo.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, fd := range set.File {
+		names = append(names, fd.GetName())
+	}
+	want := []string{
+		"google/protobuf/descriptor.proto",
+		"google/protobuf/field_mask.proto",
+		"google/protobuf/timestamp.proto",
+		"foo.proto",
+	}
+	if diff := cmp.Diff(want, names); diff != "" {
+		t.Errorf("files mismatch (-want +got):\n%s", diff)
+	}
+	foo := set.File[len(set.File)-1]
+	if got, want := foo.Extension[0].GetExtendee(), ".google.protobuf.FieldOptions"; got != want {
+		t.Errorf("got extendee %q, want %q", got, want)
+	}
+	if got, want := foo.MessageType[0].Field[1].GetTypeName(), ".google.protobuf.FieldMask"; got != want {
+		t.Errorf("got type name %q, want %q", got, want)
+	}
+}
+
+func TestConfigParseFileCycle(t *testing.T) {
+	files := map[string]string{
+		"a.proto": "syntax = \"proto3\";\nimport \"b.proto\";\n",
+		"b.proto": "syntax = \"proto3\";\n\nimport \"c.proto\";\n",
+		"c.proto": "syntax = \"proto3\";\nimport \"google/protobuf/empty.proto\";\nimport \"b.proto\";\n",
+	}
+	conf := parser.Config{Accessor: mapAccessor(files)}
+	_, _, err := conf.ParseFiles("a.proto")
+	errs, _ := err.(scanner.ErrorList)
+	var got []string
+	for _, e := range errs {
+		got = append(got, e.Error())
+	}
+	want := []string{
+		`a.proto:2:1: import "b.proto" had errors`,
+		`b.proto:3:1: import "c.proto" had errors`,
+		`c.proto:3:1: import cycle: b.proto -> c.proto -> b.proto`,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("errors mismatch (-want +got):\n%s", diff)
+	}
+
+	e := errs[2]
+	var notes []string
+	for _, n := range e.Notes {
+		notes = append(notes, n.Pos.String()+": "+n.Msg)
+	}
+	want = []string{`b.proto:3:1: b.proto imports "c.proto" here`}
+	if diff := cmp.Diff(want, notes); diff != "" {
+		t.Errorf("notes mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestConfigParseFileUnusedImports(t *testing.T) {
+	files := map[string]string{
+		"foo.proto": `syntax = "proto3";
+import "opts.proto";
+import "public.proto";
+import "unused.proto";
+message Foo {
+  option (opts.level) = 1;
+  types.T t = 1;
+}
+`,
+		"opts.proto": `syntax = "proto3";
+package opts;
+import "google/protobuf/descriptor.proto";
+extend google.protobuf.MessageOptions { int32 level = 50000; }
+`,
+		"public.proto": "syntax = \"proto3\";\nimport public \"types.proto\";\n",
+		"types.proto":  "syntax = \"proto3\";\npackage types;\nmessage T {}\n",
+		"unused.proto": "syntax = \"proto3\";\npackage unused;\nmessage U {}\n",
+	}
+	conf := parser.Config{Accessor: mapAccessor(files)}
+	_, diags, err := conf.ParseFile("foo.proto", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range diags {
+		if d.Severity == scanner.SeverityWarning {
+			got = append(got, d.Error()+" ["+d.Code+"]")
+		}
+	}
+	want := []string{`foo.proto:4:1: warning: import "unused.proto" is not used [unused-import]`}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("warnings mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestConfigParseFileResolver(t *testing.T) {
+	dep, _, err := parser.ParseFile("dep.proto", "syntax = \"proto3\";\npackage dep;\nmessage Dep {}\n", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := protodesc.NewFile(dep, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := new(protoregistry.Files)
+	if err := reg.RegisterFile(fd); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"foo.proto": "syntax = \"proto3\";\nimport \"dep.proto\";\nmessage Foo { dep.Dep dep = 1; }\n",
+	}
+	conf := parser.Config{Accessor: mapAccessor(files), Resolver: reg}
+	set, _, err := conf.ParseFiles("foo.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(set.File), 2; got != want {
+		t.Fatalf("got %d files, want %d", got, want)
+	}
+	if got, want := set.File[1].MessageType[0].Field[0].GetTypeName(), ".dep.Dep"; got != want {
+		t.Errorf("got type name %q, want %q", got, want)
+	}
+}
+
+func TestConfigParseToRegistry(t *testing.T) {
+	files := map[string]string{
+		"foo.proto": `syntax = "proto2";
+package foo;
+import "bar.proto";
+message Foo {
+  optional bar.Color color = 1 [default = GREEN];
+  optional google.protobuf.Timestamp time = 2;
+}
+`,
+		"bar.proto": `syntax = "proto2";
+package bar;
+import public "google/protobuf/timestamp.proto";
+enum Color { RED = 0; GREEN = 1; }
+`,
+		"bad.proto": `syntax = "proto2";
+package bad;
+enum Color { RED = 0; }
+message Bad {
+  optional Color color = 1 [default = BLUE];
+}
+`,
+	}
+	conf := parser.Config{Accessor: mapAccessor(files)}
+	reg, fds, _, err := conf.ParseToRegistry("foo.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reg.NumFiles(), 3; got != want {
+		t.Errorf("got %d files, want %d", got, want)
+	}
+	field := fds[0].Messages().ByName("Foo").Fields().ByName("color")
+	if got, want := field.Default().Enum(), protoreflect.EnumNumber(1); got != want {
+		t.Errorf("got default %v, want %v", got, want)
+	}
+	if _, err := reg.FindDescriptorByName("google.protobuf.Timestamp"); err != nil {
+		t.Error(err)
+	}
+
+	_, _, _, err = conf.ParseToRegistry("bad.proto")
+	errs, _ := err.(scanner.ErrorList)
+	if len(errs) != 1 {
+		t.Fatalf("got error %v, want 1 error", err)
+	}
+	if got, want := errs[0].Pos.String(), "bad.proto:5:3"; got != want {
+		t.Errorf("got position %s, want %s", got, want)
+	}
+	if got, want := errs[0].Code, scanner.CodeInvalid; got != want {
+		t.Errorf("got code %q, want %q", got, want)
+	}
+}
+
+func TestConfigParseFileErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		mode parser.Mode
+		want []string
+	}{
+		{
+			"syntax = \"proto3\";\nimport \"missing.proto\";\nmessage Foo { Bar bar = 1; }\n",
+			0,
+			[]string{
+				`foo.proto:2:1: import "missing.proto" was not found`,
+				`foo.proto:3:15: "Bar" is not defined`,
+			},
+		},
+		{
+			"syntax = \"proto3\";\nimport \"missing.proto\";\n",
+			parser.ProtocErrors,
+			[]string{`foo.proto:2:1: Import "missing.proto" was not found or had errors.`},
+		},
+		{
+			"syntax = \"proto3\";\nimport \"bad.proto\";\nservice S { rpc M(bad.Bad) returns (bad.Bad); }\n",
+			0,
+			[]string{
+				`bad.proto:1:8: expected '=', found "proto3"`,
+				`foo.proto:2:1: import "bad.proto" had errors`,
+			},
+		},
+		{
+			"syntax = \"proto3\";\nimport \"cycle.proto\";\n",
+			0,
+			[]string{
+				`cycle.proto:2:1: import cycle: foo.proto -> cycle.proto -> foo.proto`,
+				`foo.proto:2:1: import "cycle.proto" had errors`,
+			},
+		},
+		{
+			"syntax = \"proto3\";\nimport \"foo.proto\";\n",
+			parser.ProtocErrors,
+			[]string{`foo.proto:2:1: File recursively imports itself: foo.proto -> foo.proto.`},
+		},
+		{
+			"syntax = \"proto3\";\nimport \"dep.proto\";\nmessage Foo { dep.Dep d = 1; base.Base b = 2; }\n",
+			0,
+			[]string{`foo.proto:3:30: "base.Base" seems to be defined in "base.proto", which is not imported by "foo.proto"`},
+		},
+		{
+			// reported once, not for each extension
+			"syntax = \"proto2\";\nextend Bar {\n  optional int32 a = 1;\n  optional int32 b = 2;\n}\n",
+			0,
+			[]string{`foo.proto:2:8: "Bar" is not defined`},
+		},
+		{
+			"syntax = \"proto3\";\nmessage Foo { int32 a = 1; Foo.a b = 2; }\n",
+			0,
+			[]string{`foo.proto:2:28: "Foo.a" is not a type`},
+		},
+		{
+			"syntax = \"proto3\";\npackage base;\nimport \"base.proto\";\nmessage Base {}\nmessage Foo { Base b = 1; }\n",
+			0,
+			[]string{`foo.proto:4:9: "base.Base" is already defined in file "base.proto"`},
+		},
+		{
+			"syntax = \"proto3\";\nmessage Foo {\n  Bar = 1;\n  message = 2;\n  Baz b = 3;\n}\n",
+			0,
+			[]string{
+				`foo.proto:3:7: expected field name, found '='`,
+				`foo.proto:4:11: expected message name, found '='`,
+				`foo.proto:6:3: expected '}', found 'EOF'`,
+			},
+		},
+	}
+
+	files := map[string]string{
+		"bad.proto":   "syntax \"proto3\";\npackage bad;\nmessage Bad {}\n",
+		"cycle.proto": "syntax = \"proto3\";\nimport \"foo.proto\";\n",
+		"dep.proto":   "syntax = \"proto3\";\npackage dep;\nimport \"base.proto\";\nmessage Dep { base.Base b = 1; }\n",
+		"base.proto":  "syntax = \"proto3\";\npackage base;\nmessage Base {}\n",
+	}
+	for _, tt := range tests {
+		conf := parser.Config{Mode: tt.mode, Accessor: mapAccessor(files)}
+		_, _, err := conf.ParseFile("foo.proto", tt.src)
+		var got []string
+		if errs, ok := err.(scanner.ErrorList); ok {
+			for _, e := range errs {
+				got = append(got, e.Error())
+			}
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("%q: errors mismatch (-want +got):\n%s", tt.src, diff)
+		}
+	}
+}
+
+func TestConfigImportErrorCodes(t *testing.T) {
+	files := map[string]string{
+		"bad.proto":   "syntax \"proto3\";\n",
+		"cycle.proto": "syntax = \"proto3\";\nimport \"foo.proto\";\n",
+	}
+	src := "syntax = \"proto3\";\nimport \"missing.proto\";\nimport \"bad.proto\";\nimport \"cycle.proto\";\n"
+	conf := parser.Config{Accessor: mapAccessor(files)}
+	_, diags, err := conf.ParseFile("foo.proto", src)
+	for _, d := range diags {
+		// imports that failed are not reported unused too
+		if d.Severity == scanner.SeverityWarning {
+			t.Errorf("unexpected warning %s", d)
+		}
+	}
+	errs, _ := err.(scanner.ErrorList)
+	want := map[string]string{
+		`bad.proto:1:8: expected '=', found "proto3"`:                          scanner.CodeSyntax,
+		`cycle.proto:2:1: import cycle: foo.proto -> cycle.proto -> foo.proto`: scanner.CodeImport,
+		`foo.proto:2:1: import "missing.proto" was not found`:                  scanner.CodeImport,
+		`foo.proto:3:1: import "bad.proto" had errors`:                         scanner.CodeImport,
+		`foo.proto:4:1: import "cycle.proto" had errors`:                       scanner.CodeImport,
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("got errors %v, want %d errors", err, len(want))
+	}
+	for _, e := range errs {
+		if code, ok := want[e.Error()]; !ok || e.Code != code {
+			t.Errorf("%s: got code %q, want %q", e, e.Code, code)
+		}
+	}
+}
+
+func TestConfigWriteDescriptorSet(t *testing.T) {
+	const src = `syntax = "proto2";
+package foo;
+import "google/protobuf/descriptor.proto";
+message Rule { optional string name = 1; repeated int32 ids = 2; }
+extend google.protobuf.MessageOptions {
+  optional Rule rule = 50000;
+  optional int32 level = 50001;
+}
+message Foo {
+  option (level) = 1;
+  option (rule).ids = 2;
+  option (rule) = { name: "x" ids: [3] };
+}
+`
+	// a descriptor.proto compiled from source, rather than protoc's own
+	const descriptor = `syntax = "proto2";
+package google.protobuf;
+message MessageOptions {
+  repeated UninterpretedOption uninterpreted_option = 999;
+  extensions 1000 to max;
+}
+message UninterpretedOption {
+  message NamePart {
+    required string name_part = 1;
+    required bool is_extension = 2;
+  }
+  repeated NamePart name = 2;
+  optional string identifier_value = 3;
+  optional uint64 positive_int_value = 4;
+  optional int64 negative_int_value = 5;
+  optional double double_value = 6;
+  optional bytes string_value = 7;
+  optional string aggregate_value = 8;
+}
+`
+
+	rule := func(fields ...[]byte) []byte {
+		b := protowire.AppendTag(nil, 50000, protowire.BytesType)
+		return protowire.AppendBytes(b, bytes.Join(fields, nil))
+	}
+	name := protowire.AppendString(protowire.AppendTag(nil, 1, protowire.BytesType), "x")
+	id := func(v uint64) []byte {
+		return protowire.AppendVarint(protowire.AppendTag(nil, 2, protowire.VarintType), v)
+	}
+	level := protowire.AppendVarint(protowire.AppendTag(nil, 50001, protowire.VarintType), 1)
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		opts  []byte
+	}{
+		{
+			// protoc merges the options that set the same extension
+			name:  "merged",
+			files: map[string]string{"foo.proto": src},
+			opts:  bytes.Join([][]byte{rule(name, id(2), id(3)), level}, nil),
+		},
+		{
+			// and otherwise keeps each as it was set
+			name:  "per option",
+			files: map[string]string{"foo.proto": src, "google/protobuf/descriptor.proto": descriptor},
+			opts:  bytes.Join([][]byte{level, rule(id(2)), rule(name, id(3))}, nil),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := parser.Config{Mode: parser.IncludeSourceInfo, Accessor: mapAccessor(tt.files)}
+			var buf bytes.Buffer
+			if _, err := conf.WriteDescriptorSet(&buf, false, "foo.proto"); err != nil {
+				t.Fatal(err)
+			}
+			set := new(descriptorpb.FileDescriptorSet)
+			if err := proto.Unmarshal(buf.Bytes(), set); err != nil {
+				t.Fatal(err)
+			}
+			if len(set.File) != 1 {
+				t.Fatalf("got %d files, want 1", len(set.File))
+			}
+			fd := set.File[0]
+			if fd.Syntax != nil {
+				t.Errorf("got syntax %q, want none for proto2", fd.GetSyntax())
+			}
+
+			opts := fd.MessageType[1].Options
+			if len(opts.UninterpretedOption) > 0 {
+				t.Errorf("got uninterpreted options %v", opts.UninterpretedOption)
+			}
+			if diff := cmp.Diff(tt.opts, []byte(opts.ProtoReflect().GetUnknown())); diff != "" {
+				t.Errorf("options mismatch (-want +got):\n%s", diff)
+			}
+
+			// the options are located by the fields they set
+			var paths []string
+			for _, l := range fd.SourceCodeInfo.Location {
+				if len(l.Path) > 3 && l.Path[0] == 4 && l.Path[1] == 1 && l.Path[2] == 7 {
+					paths = append(paths, fmt.Sprint(l.Path))
+				}
+			}
+			want := []string{"[4 1 7 50001]", "[4 1 7 50000 2 0]", "[4 1 7 50000]"}
+			if diff := cmp.Diff(want, paths); diff != "" {
+				t.Errorf("paths mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestConfigWriteDescriptorSetOrder(t *testing.T) {
+	files := map[string]string{
+		"a.proto": "syntax = \"proto3\";\nimport \"b.proto\";\nmessage A { B b = 1; }\n",
+		"b.proto": "syntax = \"proto3\";\nimport \"d.proto\";\nmessage B { D d = 1; }\n",
+		"c.proto": "syntax = \"proto3\";\nmessage C {}\n",
+		"d.proto": "syntax = \"proto3\";\nmessage D {}\n",
+	}
+	conf := parser.Config{Accessor: mapAccessor(files)}
+	var buf bytes.Buffer
+	// in reverse dependency order
+	if _, err := conf.WriteDescriptorSet(&buf, false, "a.proto", "c.proto", "b.proto", "d.proto"); err != nil {
+		t.Fatal(err)
+	}
+	set := new(descriptorpb.FileDescriptorSet)
+	if err := proto.Unmarshal(buf.Bytes(), set); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fd := range set.File {
+		names = append(names, fd.GetName())
+	}
+	want := []string{"d.proto", "b.proto", "a.proto", "c.proto"}
+	if diff := cmp.Diff(want, names); diff != "" {
+		t.Errorf("files mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := protodesc.NewFiles(set); err != nil {
+		t.Errorf("NewFiles: %v", err)
+	}
+}
+
+func TestConfigWriteDescriptorSetRetention(t *testing.T) {
+	files := map[string]string{
+		"foo.proto": `syntax = "proto2";
+import "google/protobuf/descriptor.proto";
+extend google.protobuf.MessageOptions {
+  optional int32 kept = 50000;
+  optional int32 dropped = 50001 [retention = RETENTION_SOURCE];
+}
+message Foo {
+  option (kept) = 1;
+  option (dropped) = 2;
+}
+message Bar {
+  option (dropped) = 3;
+}
+`,
+	}
+	conf := parser.Config{Mode: parser.IncludeSourceInfo, Accessor: mapAccessor(files)}
+	var buf bytes.Buffer
+	if _, err := conf.WriteDescriptorSet(&buf, false, "foo.proto"); err != nil {
+		t.Fatal(err)
+	}
+	set := new(descriptorpb.FileDescriptorSet)
+	if err := proto.Unmarshal(buf.Bytes(), set); err != nil {
+		t.Fatal(err)
+	}
+	fd := set.File[0]
+
+	kept := protowire.AppendVarint(protowire.AppendTag(nil, 50000, protowire.VarintType), 1)
+	if got := fd.MessageType[0].Options.ProtoReflect().GetUnknown(); !bytes.Equal(got, kept) {
+		t.Errorf("got Foo options % x, want % x", got, kept)
+	}
+	if fd.MessageType[1].Options != nil {
+		t.Errorf("got Bar options %v, want none", fd.MessageType[1].Options)
+	}
+	for _, l := range fd.SourceCodeInfo.Location {
+		if len(l.Path) == 4 && l.Path[2] == 7 && l.Path[3] == 50001 {
+			t.Errorf("got a location for the dropped option at %v", l.Path)
+		}
+	}
+}
+
+func TestConfigParseFileOptionErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{
+			"option (missing) = 1;\n",
+			[]string{`foo.proto:4:8: option "(missing)" unknown. Ensure that your proto definition file imports the proto which defines the option`},
+		},
+		{
+			"option (level) = \"high\";\n",
+			[]string{`foo.proto:4:1: value must be integer for int32 option "(level)"`},
+		},
+		{
+			"option (level) = 1;\noption (level) = 2;\n",
+			[]string{`foo.proto:5:8: option "(level)" was already set`},
+		},
+		{
+			"option (tree) = " + strings.Repeat("{ a ", 1000) + strings.Repeat("} ", 1000) + ";\n",
+			[]string{`foo.proto:4:1: error while parsing option value for "tree": Message is too deep, the parser exceeded the configured recursion limit of 100.`},
+		},
+		{
+			"option (rule) = { name: \"x\" size: 1 };\n",
+			[]string{`foo.proto:4:1: error while parsing option value for "rule": Message type "foo.Rule" has no field named "size".`},
+		},
+	}
+
+	files := map[string]string{
+		"opts.proto": `syntax = "proto2";
+package foo;
+import "google/protobuf/descriptor.proto";
+message Rule { optional string name = 1; }
+message Tree { optional Tree a = 1; }
+extend google.protobuf.FileOptions {
+  optional Rule rule = 50000;
+  optional int32 level = 50001;
+  optional Tree tree = 50002;
+}
+`,
+	}
+	for _, tt := range tests {
+		conf := parser.Config{Mode: parser.InterpretOptions, Accessor: mapAccessor(files)}
+		src := "syntax = \"proto2\";\npackage foo;\nimport \"opts.proto\";\n" + tt.src
+		_, _, err := conf.ParseFile("foo.proto", src)
+		var got []string
+		if errs, ok := err.(scanner.ErrorList); ok {
+			for _, e := range errs {
+				got = append(got, e.Error())
+			}
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("%q: errors mismatch (-want +got):\n%s", tt.src, diff)
+		}
+	}
+}
+
+func TestParseFileNewOptions(t *testing.T) {
+	// debug_redact, retention and targets are newer than the runtime's
+	// descriptor.proto; they resolve against the parser's own.
+	const src = `syntax = "proto3";
+message Foo {
+  string secret = 1 [debug_redact = true, retention = RETENTION_SOURCE, targets = TARGET_TYPE_FILE, deprecated = true];
+}
+`
+	want := protowire.AppendTag(nil, 16, protowire.VarintType)
+	want = protowire.AppendVarint(want, 1)
+	want = protowire.AppendTag(want, 17, protowire.VarintType)
+	want = protowire.AppendVarint(want, 2)
+	want = protowire.AppendTag(want, 19, protowire.VarintType)
+	want = protowire.AppendVarint(want, 1)
+
+	check := func(name string, fd *descriptorpb.FileDescriptorProto) {
+		t.Helper()
+		opts := fd.MessageType[0].Field[0].GetOptions()
+		if !opts.GetDeprecated() {
+			t.Errorf("%s: deprecated not set", name)
+		}
+		if len(opts.UninterpretedOption) != 0 {
+			t.Errorf("%s: got uninterpreted options %v", name, opts.UninterpretedOption)
+		}
+		if got := opts.ProtoReflect().GetUnknown(); !bytes.Equal(got, want) {
+			t.Errorf("%s: got unknown fields %x, want %x", name, got, want)
+		}
+	}
+
+	fd, _, err := parser.ParseFile("foo.proto", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("ParseFile", fd)
+
+	// a copy of descriptor.proto found on the import path is used as is
+	files := map[string]string{
+		"foo.proto": strings.Replace(src, "\n", "\nimport \"google/protobuf/descriptor.proto\";\n", 1),
+	}
+	var opened []string
+	conf := parser.Config{ImportPaths: []string{"include"}, Accessor: func(name string) (io.ReadCloser, error) {
+		name = filepath.ToSlash(name)
+		if s, ok := files[path.Base(name)]; ok {
+			return ioutil.NopCloser(strings.NewReader(s)), nil
+		}
+		opened = append(opened, name)
+		return os.Open(name)
+	}}
+	set, _, err := conf.ParseFiles("foo.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"include/google/protobuf/descriptor.proto"}; !cmp.Equal(opened, want) {
+		t.Errorf("got opened files %q, want %q", opened, want)
+	}
+	check("Config.ParseFiles", set.File[1])
+
+	_, _, err = parser.ParseFile("foo.proto", `syntax = "proto3";
+message Foo {
+  string secret = 1 [debug_redakt = true];
+}
+`, 0)
+	want2 := `foo.proto:3:22: option "debug_redakt" unknown`
+	if err == nil || err.Error() != want2 {
+		t.Errorf("got error %v, want %s", err, want2)
+	}
+}
+
+// validationErrors returns the errors of err, each followed by its notes.
+func validationErrors(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	errs, ok := err.(scanner.ErrorList)
+	if !ok {
+		t.Fatalf("unexpected error type %T: %v", err, err)
+	}
+	var got []string
+	for _, e := range errs {
+		got = append(got, e.Error())
+		for _, n := range e.Notes {
+			got = append(got, "\t"+n.Pos.String()+": "+n.Msg)
+		}
+	}
+	return got
+}
+
+func TestParseFileFieldNumbers(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "valid",
+			src:  "syntax = \"proto2\";\nmessage A {\n  optional int32 a = 1;\n  optional int32 b = 536870911;\n  reserved 2 to 10, 20;\n  extensions 100 to 1000;\n}\n",
+		},
+		{
+			name: "out of range",
+			src:  "syntax = \"proto3\";\nmessage A {\n  int32 a = 0;\n  int32 b = 536870912;\n  int32 c = 19500;\n}\n",
+			want: []string{
+				"test.proto:3:13: field numbers must be positive integers",
+				"test.proto:4:13: field numbers cannot be greater than 536870911",
+				"test.proto:5:13: field numbers 19000 through 19999 are reserved for the protocol buffer library implementation",
+			},
+		},
+		{
+			name: "duplicate",
+			src:  "syntax = \"proto3\";\nmessage A {\n  int32 a = 1;\n  oneof o { string b = 1; }\n}\n",
+			want: []string{
+				`test.proto:4:24: field number 1 has already been used in "A" by field "a"`,
+				"\ttest.proto:3:13: previously used here",
+			},
+		},
+		{
+			name: "reserved",
+			src:  "syntax = \"proto3\";\npackage p;\nmessage A {\n  reserved 1 to 3;\n  reserved \"b\";\n  int32 a = 2;\n  int32 b = 4;\n}\n",
+			want: []string{
+				`test.proto:6:13: field "a" uses reserved number 2`,
+				"\ttest.proto:4:12: reserved here",
+				`test.proto:7:9: field name "b" is reserved`,
+				"\ttest.proto:5:12: reserved here",
+			},
+		},
+		{
+			name: "ranges",
+			src:  "syntax = \"proto2\";\nmessage A {\n  optional int32 a = 15;\n  reserved 1 to 5, 4;\n  extensions 5 to 20;\n  extensions 0;\n}\n",
+			want: []string{
+				"test.proto:4:20: reserved range 4 to 4 overlaps with already-defined range 1 to 5",
+				"\ttest.proto:4:12: previously defined here",
+				`test.proto:5:14: extension range 5 to 20 includes field "a" (15)`,
+				"\ttest.proto:3:22: field defined here",
+				"test.proto:5:14: extension range 5 to 20 overlaps with reserved range 1 to 5",
+				"\ttest.proto:4:12: reserved here",
+				"test.proto:6:14: extension numbers must be positive integers",
+			},
+		},
+		{
+			name: "extensions",
+			src:  "syntax = \"proto2\";\nmessage A { extensions 1 to 100; }\nextend A {\n  optional int32 a = 1;\n  optional int32 b = 1;\n}\n",
+			want: []string{
+				`test.proto:5:22: extension number 1 has already been used in "A" by extension "a"`,
+				"\ttest.proto:4:22: previously used here",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parser.ParseFile("test.proto", tt.src, 0)
+			if diff := cmp.Diff(tt.want, validationErrors(t, err)); diff != "" {
+				t.Errorf("errors mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseFileJSONNames(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "distinct",
+			src:  "syntax = \"proto3\";\nmessage A {\n  int32 foo_bar = 1;\n  int32 foo_baz = 2 [json_name = \"fooBar2\"];\n}\n",
+		},
+		{
+			name: "default proto3",
+			src:  "syntax = \"proto3\";\nmessage A {\n  int32 foo_bar = 1;\n  int32 fooBar = 2;\n}\n",
+			want: []string{
+				`test.proto:4:9: the default JSON name of field "fooBar" ("fooBar") conflicts with the default JSON name of field "foo_bar"`,
+				"\ttest.proto:3:9: previously defined here",
+			},
+		},
+		{
+			name: "custom proto3",
+			src:  "syntax = \"proto3\";\nmessage A {\n  int32 a = 1;\n  int32 b = 2 [json_name = \"a\"];\n}\n",
+			want: []string{
+				`test.proto:4:16: the custom JSON name of field "b" ("a") conflicts with the default JSON name of field "a"`,
+				"\ttest.proto:3:9: previously defined here",
+			},
+		},
+		{
+			name: "default proto2",
+			src:  "syntax = \"proto2\";\nmessage A {\n  optional int32 foo_bar = 1;\n  optional int32 fooBar = 2;\n}\n",
+			want: []string{
+				`test.proto:4:18: warning: the default JSON name of field "fooBar" ("fooBar") conflicts with the default JSON name of field "foo_bar"`,
+				"\ttest.proto:3:18: previously defined here",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, diags, _ := parser.ParseFile("test.proto", tt.src, 0)
+			var got []string
+			for _, d := range diags {
+				if d.Code != scanner.CodeJSONName {
+					continue
+				}
+				got = append(got, d.Error())
+				for _, n := range d.Notes {
+					got = append(got, "\t"+n.Pos.String()+": "+n.Msg)
+				}
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("diagnostics mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseFileProto3Optional(t *testing.T) {
+	src := `syntax = "proto3";
+import "google/protobuf/descriptor.proto";
+message A {
+  optional int32 foo = 1;
+  oneof _bar { int32 x = 2; }
+  optional string bar = 3;
+  int32 baz = 4;
+}
+extend google.protobuf.FieldOptions { optional int32 ext = 50000; }
+`
+	fd, _, err := parser.ParseFile("test.proto", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// extensions are in no oneof
+	if ext := fd.Extension[0]; !ext.GetProto3Optional() || ext.OneofIndex != nil {
+		t.Errorf("got extension %v, want it proto3 optional, in no oneof", ext)
+	}
+
+	// each optional field is in a oneof of its own, after those declared
+	m := fd.MessageType[0]
+	var oneofs []string
+	for _, o := range m.OneofDecl {
+		oneofs = append(oneofs, o.GetName())
+	}
+	if diff := cmp.Diff([]string{"_bar", "_foo", "X_bar"}, oneofs); diff != "" {
+		t.Errorf("oneofs mismatch (-want +got):\n%s", diff)
+	}
+	var got []string
+	for _, f := range m.Field {
+		got = append(got, fmt.Sprintf("%s %v %v", f.GetName(), f.OneofIndex != nil, f.GetProto3Optional()))
+	}
+	want := []string{"foo true true", "x true false", "bar true true", "baz false false"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("fields mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := protodesc.NewFile(fd, protoregistry.GlobalFiles); err != nil {
+		t.Errorf("building the file: %v", err)
+	}
+}
+
+// TestConfigParseToRegistryProto3Optional checks that the optional fields
+// of proto3 files are linked as protoc links them: each in a synthetic
+// oneof of its own, with explicit presence.
+func TestConfigParseToRegistryProto3Optional(t *testing.T) {
+	files := map[string]string{
+		"foo.proto": `syntax = "proto3";
+package foo;
+import "google/protobuf/descriptor.proto";
+message Foo {
+  optional string name = 1;
+  optional Foo next = 2;
+  string plain = 3;
+  oneof kind { int32 id = 4; }
+}
+extend google.protobuf.FieldOptions { optional int32 level = 50000; }
+`,
+	}
+	conf := parser.Config{Mode: parser.IncludeSourceInfo, Accessor: mapAccessor(files)}
+	_, fds, _, err := conf.ParseToRegistry("foo.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	fields := fds[0].Messages().ByName("Foo").Fields()
+	for i := 0; i < fields.Len(); i++ {
+		f := fields.Get(i)
+		oneof := "-"
+		if o := f.ContainingOneof(); o != nil {
+			oneof = string(o.Name())
+			if o.IsSynthetic() {
+				oneof += " synthetic"
+			}
+		}
+		got = append(got, fmt.Sprintf("%s %v %s", f.Name(), f.HasPresence(), oneof))
+	}
+	want := []string{
+		"name true _name synthetic",
+		"next true _next synthetic",
+		"plain false -",
+		"id true kind",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("fields mismatch (-want +got):\n%s", diff)
+	}
+
+	// protoc records no source for the synthetic oneofs
+	locs := fds[0].SourceLocations()
+	for i := 0; i < locs.Len(); i++ {
+		// the oneofs of Foo at 1 and 2 are synthetic
+		if path := locs.Get(i).Path; len(path) >= 4 && path[0] == 4 && path[1] == 0 && path[2] == 8 && path[3] > 0 {
+			t.Errorf("got a location for synthetic oneof %v", path)
+		}
+	}
+}
+
+func TestParseFileEnums(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "valid",
+			src:  "syntax = \"proto3\";\nenum Color {\n  option allow_alias = true;\n  COLOR_UNSPECIFIED = 0;\n  RED = 1;\n  ROUGE = 1;\n  reserved 5 to 10;\n}\n",
+		},
+		{
+			name: "empty",
+			src:  "syntax = \"proto2\";\nenum E {}\n",
+			want: []string{"test.proto:2:6: enums must contain at least one value"},
+		},
+		{
+			name: "first value",
+			src:  "syntax = \"proto3\";\nenum E { A = 1; }\n",
+			want: []string{"test.proto:2:14: the first enum value must be zero in proto3"},
+		},
+		{
+			name: "alias",
+			src:  "syntax = \"proto3\";\npackage p;\nenum E {\n  A = 0;\n  B = 0;\n}\nenum F {\n  option allow_alias = true;\n  F_A = 0;\n}\n",
+			want: []string{
+				`test.proto:5:7: "p.B" uses the same enum value as "p.A". If this is intended, set 'option allow_alias = true;' to the enum definition`,
+				"\ttest.proto:4:7: previously used here",
+				`test.proto:8:3: "p.F" declares support for enum aliases but no enum values share field numbers. Please remove the unnecessary 'option allow_alias = true;' declaration`,
+			},
+		},
+		{
+			name: "reserved",
+			src:  "syntax = \"proto3\";\nenum E {\n  reserved 1 to 3, 3;\n  reserved \"C\";\n  A = 0;\n  B = 2;\n  C = 4;\n}\n",
+			want: []string{
+				"test.proto:3:20: reserved range 3 to 3 overlaps with already-defined range 1 to 3",
+				"\ttest.proto:3:12: previously defined here",
+				`test.proto:6:7: enum value "B" uses reserved number 2`,
+				"\ttest.proto:3:12: reserved here",
+				`test.proto:7:3: enum value "C" is reserved`,
+				"\ttest.proto:4:12: reserved here",
+			},
+		},
+		{
+			name: "prefix",
+			src:  "syntax = \"proto3\";\nenum FooBar {\n  FOO_BAR_UNSPECIFIED = 0;\n  UNSPECIFIED = 1;\n}\n",
+			want: []string{
+				"test.proto:4:3: enum name UNSPECIFIED has the same name as FOO_BAR_UNSPECIFIED if you ignore case and strip out the enum name prefix (if any). (If you are using allow_alias, please assign the same number to each enum value name.)",
+				"\ttest.proto:3:3: previously defined here",
+			},
+		},
+		{
+			name: "scope",
+			src:  "syntax = \"proto3\";\npackage p;\nenum E { A = 0; }\nmessage M {\n  enum F { A = 0; }\n  enum G { A = 0; }\n}\nenum H { E = 0; }\n",
+			want: []string{
+				`test.proto:6:12: "A" is already defined in "p.M". Note that enum values use C++ scoping rules, meaning that enum values are siblings of their type, not children of it.  Therefore, "A" must be unique within "p.M", not just within "G"`,
+				"\ttest.proto:5:12: previously defined here",
+				`test.proto:8:10: "E" is already defined in "p". Note that enum values use C++ scoping rules, meaning that enum values are siblings of their type, not children of it.  Therefore, "E" must be unique within "p", not just within "H"`,
+				"\ttest.proto:3:6: previously defined here",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parser.ParseFile("test.proto", tt.src, 0)
+			if diff := cmp.Diff(tt.want, validationErrors(t, err)); diff != "" {
+				t.Errorf("errors mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseFileDuplicates(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "messages",
+			src:  "syntax = \"proto3\";\npackage p;\nmessage A {}\nmessage A {}\n",
+			want: []string{
+				`test.proto:4:9: "A" is already defined in "p"`,
+				"\ttest.proto:3:9: previously defined here",
+			},
+		},
+		{
+			name: "no package",
+			src:  "syntax = \"proto3\";\nmessage A {}\nenum A { X = 0; }\n",
+			want: []string{
+				`test.proto:3:6: "A" is already defined`,
+				"\ttest.proto:2:9: previously defined here",
+			},
+		},
+		{
+			name: "nested",
+			src:  "syntax = \"proto3\";\npackage p;\nmessage M {\n  int32 a = 1;\n  string a = 2;\n  enum E { X = 0; }\n  message E {}\n}\n",
+			want: []string{
+				`test.proto:5:10: "a" is already defined in "p.M"`,
+				"\ttest.proto:4:9: previously defined here",
+				// protoc builds nested messages before enums
+				`test.proto:6:8: "E" is already defined in "p.M"`,
+				"\ttest.proto:7:11: previously defined here",
+			},
+		},
+		{
+			name: "enum values",
+			src:  "syntax = \"proto3\";\npackage p;\nenum E {\n  A = 0;\n  A = 1;\n}\n",
+			want: []string{
+				`test.proto:5:3: "A" is already defined in "p.E"`,
+				"\ttest.proto:4:3: previously defined here",
+			},
+		},
+		{
+			name: "methods",
+			src:  "syntax = \"proto3\";\npackage p;\nmessage M {}\nservice S {\n  rpc Get(M) returns (M);\n  rpc Get(M) returns (M);\n}\nservice S {}\n",
+			want: []string{
+				`test.proto:6:7: "Get" is already defined in "p.S"`,
+				"\ttest.proto:5:7: previously defined here",
+				`test.proto:8:9: "S" is already defined in "p"`,
+				"\ttest.proto:4:9: previously defined here",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parser.ParseFile("test.proto", tt.src, 0)
+			if diff := cmp.Diff(tt.want, validationErrors(t, err)); diff != "" {
+				t.Errorf("errors mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseFileKeywordNames(t *testing.T) {
+	src := `syntax = "proto3";
+package foo.message;
+message Service {
+  message message {
+    enum enum { max = 0; MAX_VALUE = 1; to = 2; }
+  }
+  int32 option = 1;
+  repeated message.enum syntax = 2;
+  foo.message.Service.message service = 3;
+  map m = 4;
+  map<string, stream> map = 5;
+  oneof oneof { stream stream = 7; }
+}
+message map {}
+message stream {}
+`
+	fd, _, err := parser.ParseFile("test.proto", src, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got []string
+	for _, f := range fd.GetMessageType()[0].GetField() {
+		got = append(got, f.GetName()+" "+f.GetTypeName())
+	}
+	want := []string{
+		"option ",
+		"syntax .foo.message.Service.message.enum",
+		"service .foo.message.Service.message",
+		"m .foo.message.map",
+		"map .foo.message.Service.MapEntry",
+		"stream .foo.message.stream",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("fields mismatch (-want +got):\n%s", diff)
+	}
+
+	got = nil
+	for _, v := range fd.GetMessageType()[0].GetNestedType()[0].GetEnumType()[0].GetValue() {
+		got = append(got, v.GetName())
+	}
+	if diff := cmp.Diff([]string{"max", "MAX_VALUE", "to"}, got); diff != "" {
+		t.Errorf("enum values mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseFileLeadingUnderscores(t *testing.T) {
+	src := `syntax = "proto3";
+package foo;
+message _Outer {
+  message _Inner { string _name = 1; }
+  _Inner _inner = 1;
+  foo._Outer._Inner __x = 2;
+}
+`
+	fd, _, err := parser.ParseFile("test.proto", src, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for _, f := range fd.GetMessageType()[0].GetField() {
+		got = append(got, f.GetName()+" "+f.GetTypeName())
+	}
+	want := []string{"_inner .foo._Outer._Inner", "__x .foo._Outer._Inner"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("fields mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	field := func(name string, number int32, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+			JsonName: proto.String(name),
+		}
+		if typeName != "" {
+			f.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	base := &descriptorpb.FileDescriptorProto{
+		Name:        proto.String("base.proto"),
+		Package:     proto.String("base"),
+		Syntax:      proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{Name: proto.String("Base")}},
+	}
+	other := &descriptorpb.FileDescriptorProto{
+		Name:        proto.String("other.proto"),
+		Package:     proto.String("other"),
+		Syntax:      proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{Name: proto.String("Other")}},
+	}
+	main := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("main.proto"),
+		Package:    proto.String("main"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"base.proto", "missing.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("M"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("a", 1, ".base.Base"),
+				field("b", 1, ""),
+				field("c", 2, ".main.Nope"),
+				field("d", 3, ".other.Other"),
+			},
+		}},
+		SourceCodeInfo: &descriptorpb.SourceCodeInfo{Location: []*descriptorpb.SourceCodeInfo_Location{
+			{Path: []int32{4, 0, 2, 0, 3}, Span: []int32{5, 19, 20}},
+			{Path: []int32{4, 0, 2, 1, 3}, Span: []int32{6, 13, 14}},
+		}},
+	}
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{base, other, main}}
+	orig := proto.Clone(set)
+
+	var got []string
+	for _, e := range parser.Validate(set, 0) {
+		got = append(got, e.Error())
+		for _, n := range e.Notes {
+			got = append(got, "\t"+n.Pos.String()+": "+n.Msg)
+		}
+	}
+	want := []string{
+		`main.proto: import "missing.proto" was not found`,
+		`main.proto: ".main.Nope" is not defined`,
+		`main.proto: ".other.Other" seems to be defined in "other.proto", which is not imported by "main.proto"`,
+		`main.proto:7:14: field number 1 has already been used in "main.M" by field "a"`,
+		"\tmain.proto:6:20: previously used here",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("errors mismatch (-want +got):\n%s", diff)
+	}
+	if !proto.Equal(orig, set) {
+		t.Errorf("Validate modified the set")
+	}
+}
+
+func TestValidateMalformed(t *testing.T) {
+	field := func(name string, label descriptorpb.FieldDescriptorProto_Label, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(1)}
+		if label != 0 {
+			f.Label = label.Enum()
+		}
+		if typ != 0 {
+			f.Type = typ.Enum()
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	const (
+		optional = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		required = descriptorpb.FieldDescriptorProto_LABEL_REQUIRED
+		int32T   = descriptorpb.FieldDescriptorProto_TYPE_INT32
+		message  = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+	)
+	base := &descriptorpb.FileDescriptorProto{
+		Name:        proto.String("base.proto"),
+		Package:     proto.String("x"),
+		Syntax:      proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{Name: proto.String("B")}},
+	}
+	main := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("main.proto"),
+		Package: proto.String("x"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("A")},
+			{
+				Name: proto.String("A"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{Name: proto.String("a"), Number: proto.Int32(1)},
+				},
+			},
+			{
+				Name: proto.String("M"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("b", required, int32T, ""),
+					field("c", optional, int32T, ".x.A"),
+					field("d", optional, message, ""),
+					field("", optional, int32T, ""),
+					field("e.f", optional, int32T, ""),
+				},
+			},
+			{Name: proto.String("B")},
+		},
+		SourceCodeInfo: &descriptorpb.SourceCodeInfo{Location: []*descriptorpb.SourceCodeInfo_Location{
+			{Path: []int32{4, 1, 1}, Span: []int32{3, 8, 9}},
+			{Path: []int32{4, 0, 1}, Span: []int32{2, 8, 9}},
+		}},
+	}
+	for i, f := range main.MessageType[2].Field {
+		f.Number = proto.Int32(int32(i + 1))
+	}
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{base, main}}
+	if _, err := protodesc.NewFiles(set); err == nil {
+		t.Fatal("protodesc accepted the malformed set")
+	}
+
+	var got []string
+	for _, e := range parser.Validate(set, 0) {
+		got = append(got, e.Error())
+		for _, n := range e.Notes {
+			got = append(got, "\t"+n.Pos.String()+": "+n.Msg)
+		}
+	}
+	want := []string{
+		`main.proto: field "a" has no label`,
+		`main.proto: field "a" has no type`,
+		`main.proto: required fields are not allowed in proto3`,
+		`main.proto: field "c" has a scalar type, but also a type name`,
+		`main.proto: field "d" has a message or enum type, but no type name`,
+		`main.proto: missing name`,
+		`main.proto: "e.f" is not a valid identifier`,
+		`main.proto: "x.B" is already defined in file "base.proto"`,
+		`main.proto:4:9: "A" is already defined in "x"`,
+		"\tmain.proto:3:9: previously defined here",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("errors mismatch (-want +got):\n%s", diff)
+	}
+}
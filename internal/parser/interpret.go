@@ -0,0 +1,765 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"rogchap.com/protoparser/internal/descriptor"
+	"rogchap.com/protoparser/internal/scanner"
+)
+
+// Custom options set extensions of the options messages, which may be
+// declared in the files that a file imports, so they are kept as
+// uninterpreted options while the file is parsed. In the InterpretOptions
+// mode they are interpreted once the file is linked, as protoc interprets
+// them: their values are encoded as protoc's C++ runtime encodes them and
+// kept as the unknown fields of the options messages, and the locations
+// of the options are given the paths of the fields they set.
+//
+// So are the options that set fields of the options messages that the
+// protobuf runtime does not have, such as debug_redact, which are
+// interpreted in every mode: their fields are those that descriptor.proto
+// declares, whether it is parsed from source or is protoc's own.
+//
+// How the values are encoded depends on the descriptor.proto that declares
+// the options messages. When it is protoc's own, protoc reparses the
+// options with their extensions known, merging the options that set the
+// same extension into one value whose fields are in number order. When it
+// is compiled from source, protoc keeps each option as it was encoded, in
+// the order they were set.
+
+// descriptorFile is the name of the file that declares the options
+// messages.
+const descriptorFile = "google/protobuf/descriptor.proto"
+
+// An optionType is a message or enum type that the value of an option may
+// have.
+type optionType struct {
+	name   string // fully-qualified, without the leading dot
+	msg    *descriptorpb.DescriptorProto
+	enum   *descriptorpb.EnumDescriptorProto
+	proto3 bool // declared in a proto3 file
+}
+
+// An optionField is a field that an option may set.
+type optionField struct {
+	fd     *descriptorpb.FieldDescriptorProto
+	proto3 bool // declared in a proto3 file
+}
+
+// A message is a message value set by options.
+type message struct {
+	typ    *optionType // nil for the unknown fields of an options message
+	fields map[int32]*fieldValue
+}
+
+// A fieldValue is the value of a field of a message: its values, in the
+// order they were set, of which there is one unless it is repeated.
+type fieldValue struct {
+	optionField
+	values []value
+}
+
+// A value is the value of a message field, or the encoding of a scalar
+// field's value without its tag.
+type value struct {
+	msg *message
+	b   []byte
+}
+
+func newMessage(typ *optionType) *message {
+	return &message{typ: typ, fields: make(map[int32]*fieldValue)}
+}
+
+// An interpreter interprets the options of a linked file.
+type interpreter struct {
+	fd     *descriptorpb.FileDescriptorProto
+	files  []*descriptorpb.FileDescriptorProto
+	desc   func() *descriptorpb.FileDescriptorProto // the file that declares the options messages
+	p      *parser
+	merge  bool               // merge the options that set the same extension
+	custom bool               // interpret custom options too
+	paths  map[string][]int32 // the paths of the options interpreted, by their old paths
+	counts map[string]int32   // the number of values set in each repeated field, by path
+	set    map[string]bool    // the paths of the singular fields set
+
+	// the names the options may use, loaded when first needed
+	r     *resolver
+	types map[string]*optionType
+	exts  map[string]optionField
+}
+
+// interpretOptions interprets the uninterpreted options of fd that set
+// fields of the options messages, which are those declared by desc, and
+// its custom options too if custom is set. Names are looked up in fd and
+// in files, the other files that have been loaded. If merge is set, the
+// options that set the same extension are merged, as protoc merges them
+// when descriptor.proto is its own.
+func (p *parser) interpretOptions(fd *descriptorpb.FileDescriptorProto, files []*descriptorpb.FileDescriptorProto, desc func() *descriptorpb.FileDescriptorProto, merge, custom bool) {
+	in := interpreter{
+		fd:     fd,
+		files:  files,
+		desc:   desc,
+		p:      p,
+		merge:  merge,
+		custom: custom,
+		paths:  make(map[string][]int32),
+		counts: make(map[string]int32),
+		set:    make(map[string]bool),
+	}
+
+	scope := fd.GetPackage()
+	in.interpret(fd.Options, fileScope(scope), []int32{descriptor.FileOptionsTag})
+	for i, m := range fd.MessageType {
+		in.interpretMessage(scope, m, []int32{descriptor.FileMessageTag, int32(i)})
+	}
+	for i, e := range fd.EnumType {
+		in.interpretEnum(scope, e, []int32{descriptor.FileEnumTag, int32(i)})
+	}
+	for i, f := range fd.Extension {
+		in.interpret(f.Options, join(scope, f.GetName()), []int32{descriptor.FileExtensionTag, int32(i), descriptor.FieldOptionsTag})
+	}
+	for i, sd := range fd.Service {
+		name := join(scope, sd.GetName())
+		path := []int32{descriptor.FileServiceTag, int32(i)}
+		in.interpret(sd.Options, name, subpath(path, descriptor.ServiceOptionsTag))
+		for j, md := range sd.Method {
+			in.interpret(md.Options, join(name, md.GetName()), subpath(path, descriptor.ServiceMethodTag, int32(j), descriptor.MethodOptionsTag))
+		}
+	}
+
+	if len(in.paths) > 0 {
+		for _, l := range p.locs {
+			if path, ok := in.paths[pathKey(l.loc.Path)]; ok {
+				l.loc.Path = path
+			}
+		}
+		p.locIndex = nil
+	}
+}
+
+// load loads the names that the options may use: those declared in the
+// files, and the options messages, as desc declares them.
+func (in *interpreter) load() {
+	if in.r != nil {
+		return
+	}
+	in.r = newResolver(in.fd, in.files, true, in.p.reportPath)
+	in.types = make(map[string]*optionType)
+	in.exts = make(map[string]optionField)
+	for _, f := range in.files {
+		in.addFile(f)
+	}
+	in.addFile(in.fd)
+	in.addFile(in.desc())
+}
+
+func (in *interpreter) addFile(fd *descriptorpb.FileDescriptorProto) {
+	proto3 := fd.GetSyntax() == "proto3"
+	scope := fd.GetPackage()
+	for _, m := range fd.MessageType {
+		in.addMessage(scope, m, proto3)
+	}
+	for _, e := range fd.EnumType {
+		name := join(scope, e.GetName())
+		in.types[name] = &optionType{name: name, enum: e, proto3: proto3}
+	}
+	for _, f := range fd.Extension {
+		in.exts[join(scope, f.GetName())] = optionField{f, proto3}
+	}
+}
+
+func (in *interpreter) addMessage(scope string, m *descriptorpb.DescriptorProto, proto3 bool) {
+	name := join(scope, m.GetName())
+	in.types[name] = &optionType{name: name, msg: m, proto3: proto3}
+	for _, n := range m.NestedType {
+		in.addMessage(name, n, proto3)
+	}
+	for _, e := range m.EnumType {
+		ename := join(name, e.GetName())
+		in.types[ename] = &optionType{name: ename, enum: e, proto3: proto3}
+	}
+	for _, f := range m.Extension {
+		in.exts[join(name, f.GetName())] = optionField{f, proto3}
+	}
+}
+
+func (in *interpreter) interpretMessage(scope string, m *descriptorpb.DescriptorProto, path []int32) {
+	name := join(scope, m.GetName())
+	in.interpret(m.Options, name, subpath(path, descriptor.MessageOptionsTag))
+	for i, f := range m.Field {
+		in.interpret(f.Options, join(name, f.GetName()), subpath(path, descriptor.MessageFieldTag, int32(i), descriptor.FieldOptionsTag))
+	}
+	for i, f := range m.Extension {
+		in.interpret(f.Options, join(name, f.GetName()), subpath(path, descriptor.MessageExtensionTag, int32(i), descriptor.FieldOptionsTag))
+	}
+	for i, n := range m.NestedType {
+		in.interpretMessage(name, n, subpath(path, descriptor.MessageNestedTag, int32(i)))
+	}
+	for i, e := range m.EnumType {
+		in.interpretEnum(name, e, subpath(path, descriptor.MessageEnumTag, int32(i)))
+	}
+	for i, o := range m.OneofDecl {
+		in.interpret(o.Options, join(name, o.GetName()), subpath(path, descriptor.MessageOneofTag, int32(i), descriptor.OneofOptionsTag))
+	}
+	for i, er := range m.ExtensionRange {
+		in.interpret(er.Options, name, subpath(path, descriptor.MessageExtensionRangeTag, int32(i), descriptor.RangeOptionsTag))
+	}
+}
+
+func (in *interpreter) interpretEnum(scope string, e *descriptorpb.EnumDescriptorProto, path []int32) {
+	in.interpret(e.Options, join(scope, e.GetName()), subpath(path, descriptor.EnumOptionsTag))
+	for i, v := range e.Value {
+		// enum values are siblings of their enum
+		in.interpret(v.Options, join(scope, v.GetName()), subpath(path, descriptor.EnumValueTag, int32(i), descriptor.EnumValueOptionsTag))
+	}
+}
+
+// interpret interprets the uninterpreted options of opts, the options of
+// the element relativeTo, found at path, keeping those that are custom
+// unless custom options are interpreted. Their encoding follows the
+// fields of opts that are set directly.
+func (in *interpreter) interpret(opts interface {
+	proto.Message
+	GetUninterpretedOption() []*descriptorpb.UninterpretedOption
+}, relativeTo string, path []int32) {
+	uos := opts.GetUninterpretedOption()
+	n := 0 // the number of options to interpret
+	for _, uo := range uos {
+		if in.custom || !isCustom(uo) {
+			n++
+		}
+	}
+	if n == 0 {
+		return
+	}
+	in.load()
+
+	m := opts.ProtoReflect()
+	b := m.GetUnknown()
+	unknown := newMessage(nil)
+	var kept []*descriptorpb.UninterpretedOption // the custom options, unless they are interpreted
+	for i, uo := range uos {
+		uoPath := subpath(path, descriptor.UninterpretedOptionField, int32(i))
+		if !in.custom && isCustom(uo) {
+			in.paths[pathKey(uoPath)] = subpath(path, descriptor.UninterpretedOptionField, int32(len(kept)))
+			kept = append(kept, uo)
+			continue
+		}
+		fields, v, ok := in.option(m.Descriptor(), uo, relativeTo, path, uoPath)
+		switch {
+		case !ok:
+		case in.merge:
+			unknown.setPath(in, fields, v)
+		default:
+			f := fields[len(fields)-1].fd
+			opt := appendValue(nil, f, v)
+			for i := len(fields) - 2; i >= 0; i-- {
+				opt = appendMessage(nil, fields[i].fd, opt)
+			}
+			b = append(b, opt...)
+		}
+	}
+	uninterpreted := m.Descriptor().Fields().ByNumber(descriptor.UninterpretedOptionField)
+	m.Clear(uninterpreted)
+	if len(kept) > 0 {
+		l := m.Mutable(uninterpreted).List()
+		for _, uo := range kept {
+			l.Append(protoreflect.ValueOfMessage(uo.ProtoReflect()))
+		}
+	}
+	m.SetUnknown(append(b, unknown.encode()...))
+}
+
+// isCustom reports whether uo is a custom option: one whose name has an
+// extension in it.
+func isCustom(uo *descriptorpb.UninterpretedOption) bool {
+	for _, part := range uo.Name {
+		if part.GetIsExtension() {
+			return true
+		}
+	}
+	return false
+}
+
+// option interprets uo, found at path among the options at optsPath,
+// whose type is opts. It returns the fields that uo names, the last of
+// which it sets, and its value.
+func (in *interpreter) option(opts protoreflect.MessageDescriptor, uo *descriptorpb.UninterpretedOption, relativeTo string, optsPath, path []int32) ([]optionField, value, bool) {
+	var (
+		fields []optionField
+		name   strings.Builder
+	)
+	typName := string(opts.FullName())
+	typ := in.types[typName] // the type of the message named so far
+	dest := append([]int32(nil), optsPath...)
+	for i, part := range uo.Name {
+		var f optionField
+		if i > 0 {
+			name.WriteByte('.')
+		}
+		if part.GetIsExtension() {
+			name.WriteString("(" + part.GetNamePart() + ")")
+			ext, ok := in.extension(part.GetNamePart(), relativeTo, path)
+			if !ok {
+				in.nameError(path, fmt.Sprintf("option %q unknown. Ensure that your proto definition file imports the proto which defines the option", name.String()))
+				return nil, value{}, false
+			}
+			if strings.TrimPrefix(ext.fd.GetExtendee(), ".") != typName {
+				in.nameError(path, fmt.Sprintf("option field %q is not a field or extension of message %q", name.String(), shortTypeName(typName)))
+				return nil, value{}, false
+			}
+			f = ext
+		} else {
+			name.WriteString(part.GetNamePart())
+			if typ != nil && typ.msg != nil {
+				f.fd = fieldNamed(typ.msg, part.GetNamePart())
+			}
+			switch {
+			case i == 0 && (f.fd == nil || f.fd.GetNumber() == descriptor.UninterpretedOptionField):
+				in.nameError(path, fmt.Sprintf("option %q unknown", name.String()))
+				return nil, value{}, false
+			case f.fd == nil:
+				in.nameError(path, fmt.Sprintf("option field %q is not a field or extension of message %q", name.String(), shortTypeName(typName)))
+				return nil, value{}, false
+			}
+			f.proto3 = typ.proto3
+		}
+		fields = append(fields, f)
+		dest = append(dest, f.fd.GetNumber())
+		if i == len(uo.Name)-1 {
+			break
+		}
+
+		if !isMessageField(f.fd) {
+			in.nameError(path, fmt.Sprintf("option %q is an atomic type, not a message", name.String()))
+			return nil, value{}, false
+		}
+		if f.fd.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+			in.nameError(path, fmt.Sprintf("option field %q is a repeated message. Repeated message options must be initialized using an aggregate value", name.String()))
+			return nil, value{}, false
+		}
+		typName = strings.TrimPrefix(f.fd.GetTypeName(), ".")
+		typ = in.types[typName]
+	}
+
+	f := fields[len(fields)-1].fd
+	v, ok := in.optionValue(f, uo, name.String(), relativeTo, path)
+	if !ok {
+		return nil, value{}, false
+	}
+	key := pathKey(dest)
+	if f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+		dest = append(dest, in.counts[key])
+		in.counts[key]++
+	} else {
+		if in.set[key] {
+			in.nameError(path, fmt.Sprintf("option %q was already set", name.String()))
+			return nil, value{}, false
+		}
+		in.markSet(dest, v)
+	}
+	in.paths[pathKey(path)] = dest
+	return fields, v, true
+}
+
+// markSet records that the singular field at path has been set to v, and
+// so have the singular fields of v, if it is a message.
+func (in *interpreter) markSet(path []int32, v value) {
+	in.set[pathKey(path)] = true
+	if v.msg == nil {
+		return
+	}
+	for n, fv := range v.msg.fields {
+		if fv.fd.GetLabel() != descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+			in.markSet(subpath(path, n), fv.values[0])
+		}
+	}
+}
+
+func (in *interpreter) error(path []int32, msg string) {
+	in.p.reportPath(path, scanner.SeverityError, scanner.CodeInvalid, msg)
+}
+
+// nameError reports a problem with the name of the option at path.
+func (in *interpreter) nameError(path []int32, msg string) {
+	if pos, ok := in.p.optionNames[pathKey(path)]; ok {
+		in.p.report(pos, 0, scanner.SeverityError, scanner.CodeInvalid, msg)
+		return
+	}
+	in.error(path, msg)
+}
+
+// extension returns the extension that name refers to when used from
+// within the element relativeTo, by the option at path.
+func (in *interpreter) extension(name, relativeTo string, path []int32) (optionField, bool) {
+	full, sym := in.r.syms.lookup(name, relativeTo, symbolKind.isField)
+	ext, ok := in.exts[full]
+	if !ok || !in.r.use(name, sym, path) {
+		return optionField{}, false
+	}
+	return ext, true
+}
+
+// optionValue returns the value that uo gives to f, the field named name.
+func (in *interpreter) optionValue(f *descriptorpb.FieldDescriptorProto, uo *descriptorpb.UninterpretedOption, name, relativeTo string, path []int32) (value, bool) {
+	if isMessageField(f) {
+		if uo.AggregateValue == nil {
+			in.error(path, fmt.Sprintf(`option %q is a message. To set the entire message, use syntax like "%s = { <proto text format> }". To set fields within it, use syntax like "%s.foo = value"`, name, name, name))
+			return value{}, false
+		}
+		m, err := in.parseAggregate(in.types[strings.TrimPrefix(f.GetTypeName(), ".")], uo.GetAggregateValue(), relativeTo)
+		if err != "" {
+			in.error(path, fmt.Sprintf("error while parsing option value for %q: %s", f.GetName(), err))
+			return value{}, false
+		}
+		return value{msg: m}, true
+	}
+	if uo.AggregateValue != nil {
+		in.error(path, fmt.Sprintf("option %q is a message", name))
+		return value{}, false
+	}
+
+	var b []byte
+	typ := f.GetType()
+	kind := protoreflect.Kind(typ)
+	var err error
+	switch kind {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		var v int64
+		v, err = intOptionValue(name, kind, uo, math.MinInt32, math.MaxInt32)
+		b = encodeInt(typ, v)
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		var v int64
+		v, err = intOptionValue(name, kind, uo, math.MinInt64, math.MaxInt64)
+		b = encodeInt(typ, v)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		var v uint64
+		v, err = uintOptionValue(name, kind, uo, math.MaxUint32)
+		b = encodeUint(typ, v)
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		var v uint64
+		v, err = uintOptionValue(name, kind, uo, math.MaxUint64)
+		b = encodeUint(typ, v)
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		var v float64
+		v, err = floatOptionValue(name, kind, uo)
+		b = encodeFloat(typ, v)
+	case protoreflect.BoolKind:
+		switch uo.GetIdentifierValue() {
+		case "true":
+			b = []byte{1}
+		case "false":
+			b = []byte{0}
+		default:
+			err = fmt.Errorf("value must be \"true\" or \"false\" for boolean option %q", name)
+		}
+	case protoreflect.EnumKind:
+		if uo.IdentifierValue == nil {
+			err = fmt.Errorf("value must be identifier for enum-valued option %q", name)
+			break
+		}
+		typ := in.types[strings.TrimPrefix(f.GetTypeName(), ".")]
+		ev := enumValueNamed(typ, uo.GetIdentifierValue())
+		if ev == nil {
+			err = fmt.Errorf("enum type %q has no value named %q for option %q", typ.name, uo.GetIdentifierValue(), name)
+			break
+		}
+		b = encodeInt(descriptorpb.FieldDescriptorProto_TYPE_ENUM, int64(ev.GetNumber()))
+	case protoreflect.StringKind, protoreflect.BytesKind:
+		if uo.StringValue == nil {
+			err = fmt.Errorf("value must be quoted string for %s option %q", kind, name)
+			break
+		}
+		b = uo.StringValue
+	}
+	if err != nil {
+		in.error(path, err.Error())
+		return value{}, false
+	}
+	return value{b: b}, true
+}
+
+// setPath sets the last of fields, a chain of fields of nested messages
+// within m, to v, as parsing the encoding of the value would.
+func (m *message) setPath(in *interpreter, fields []optionField, v value) {
+	for _, f := range fields[:len(fields)-1] {
+		fv := m.fields[f.fd.GetNumber()]
+		if fv == nil {
+			msg := newMessage(in.types[strings.TrimPrefix(f.fd.GetTypeName(), ".")])
+			m.add(f, value{msg: msg})
+			fv = m.fields[f.fd.GetNumber()]
+		}
+		m = fv.values[0].msg
+	}
+	m.merge(fields[len(fields)-1], v)
+}
+
+// merge merges v into the field f of m, as parsing its encoding would:
+// the values of repeated fields are added, messages are merged, and
+// scalars are replaced.
+func (m *message) merge(f optionField, v value) {
+	if v.msg == nil && !(&fieldValue{optionField: f}).hasPresence() && isZero(f.fd, v) {
+		return // not encoded, so not merged
+	}
+	fv := m.fields[f.fd.GetNumber()]
+	if fv == nil || v.msg == nil || f.fd.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+		m.add(f, v)
+		return
+	}
+	into := fv.values[0].msg
+	for _, n := range v.msg.numbers() {
+		for _, w := range v.msg.fields[n].values {
+			into.merge(v.msg.fields[n].optionField, w)
+		}
+	}
+}
+
+// add adds v to the values of the field f of m. Setting a field of a oneof
+// clears its other fields, and adding an entry to a map replaces the entry
+// with the same key.
+func (m *message) add(f optionField, v value) {
+	fv := m.fields[f.fd.GetNumber()]
+	if fv == nil || f.fd.GetLabel() != descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+		if m.typ != nil && f.fd.OneofIndex != nil && f.fd.Extendee == nil {
+			for _, g := range m.typ.msg.Field {
+				if g.OneofIndex != nil && g.GetOneofIndex() == f.fd.GetOneofIndex() {
+					delete(m.fields, g.GetNumber())
+				}
+			}
+		}
+		m.fields[f.fd.GetNumber()] = &fieldValue{f, []value{v}}
+		return
+	}
+	if v.msg != nil && v.msg.typ != nil && v.msg.typ.msg.GetOptions().GetMapEntry() {
+		for i, w := range fv.values {
+			if bytes.Equal(w.msg.mapKey(), v.msg.mapKey()) {
+				fv.values[i] = v
+				return
+			}
+		}
+	}
+	fv.values = append(fv.values, v)
+}
+
+// mapKey returns the encoding of the key of m, a map entry.
+func (m *message) mapKey() []byte {
+	if fv := m.fields[1]; fv != nil {
+		return fv.values[0].b
+	}
+	return nil
+}
+
+// numbers returns the numbers of the fields of m that are set, in order.
+func (m *message) numbers() []int32 {
+	nums := make([]int32, 0, len(m.fields))
+	for n := range m.fields {
+		nums = append(nums, n)
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+	return nums
+}
+
+// hasPresence reports whether fv is set by its zero value, which only
+// singular scalar fields declared in proto3 files outside of oneofs
+// are not.
+func (fv *fieldValue) hasPresence() bool {
+	f := fv.fd
+	return !fv.proto3 || f.Extendee != nil || f.OneofIndex != nil || isMessageField(f) ||
+		f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+}
+
+// isZero reports whether v is the zero value of the scalar field f.
+func isZero(f *descriptorpb.FieldDescriptorProto, v value) bool {
+	switch f.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING, descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		return len(v.b) == 0
+	}
+	for _, c := range v.b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// encode returns the encoding of m, its fields in number order.
+func (m *message) encode() []byte {
+	if m.typ != nil && m.typ.msg.GetOptions().GetMapEntry() {
+		m.addMapDefaults()
+	}
+	var b []byte
+	for _, n := range m.numbers() {
+		fv := m.fields[n]
+		if !fv.hasPresence() && isZero(fv.fd, fv.values[0]) {
+			continue
+		}
+		if fv.packed() {
+			var packed []byte
+			for _, v := range fv.values {
+				packed = append(packed, v.b...)
+			}
+			b = protowire.AppendTag(b, protowire.Number(n), protowire.BytesType)
+			b = protowire.AppendBytes(b, packed)
+			continue
+		}
+		for _, v := range fv.values {
+			b = appendValue(b, fv.fd, v)
+		}
+	}
+	return b
+}
+
+// appendValue appends the encoding of v, a value of the field f, with its
+// tag.
+func appendValue(b []byte, f *descriptorpb.FieldDescriptorProto, v value) []byte {
+	if v.msg != nil {
+		return appendMessage(b, f, v.msg.encode())
+	}
+	typ := f.GetType()
+	b = protowire.AppendTag(b, protowire.Number(f.GetNumber()), wireType(typ))
+	if wireType(typ) == protowire.BytesType {
+		return protowire.AppendBytes(b, v.b)
+	}
+	return append(b, v.b...)
+}
+
+// appendMessage appends msg, the encoding of a value of the message or
+// group field f, with its tag.
+func appendMessage(b []byte, f *descriptorpb.FieldDescriptorProto, msg []byte) []byte {
+	num := protowire.Number(f.GetNumber())
+	if f.GetType() == descriptorpb.FieldDescriptorProto_TYPE_GROUP {
+		b = protowire.AppendTag(b, num, protowire.StartGroupType)
+		b = append(b, msg...)
+		return protowire.AppendTag(b, num, protowire.EndGroupType)
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+// addMapDefaults sets the key and value of a map entry that are not set
+// to their zero values, as map entries always have both.
+func (m *message) addMapDefaults() {
+	for _, f := range m.typ.msg.Field {
+		if m.fields[f.GetNumber()] != nil {
+			continue
+		}
+		var v value
+		switch f.GetType() {
+		case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+			v.msg = newMessage(nil)
+		case descriptorpb.FieldDescriptorProto_TYPE_STRING, descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+			v.b = []byte{}
+		case descriptorpb.FieldDescriptorProto_TYPE_FLOAT, descriptorpb.FieldDescriptorProto_TYPE_FIXED32, descriptorpb.FieldDescriptorProto_TYPE_SFIXED32:
+			v.b = make([]byte, 4)
+		case descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, descriptorpb.FieldDescriptorProto_TYPE_FIXED64, descriptorpb.FieldDescriptorProto_TYPE_SFIXED64:
+			v.b = make([]byte, 8)
+		default:
+			v.b = []byte{0}
+		}
+		m.fields[f.GetNumber()] = &fieldValue{optionField{f, m.typ.proto3}, []value{v}}
+	}
+}
+
+// packed reports whether the values of fv are encoded packed: those of
+// repeated scalar fields declared with [packed = true], or declared in a
+// proto3 file without [packed = false].
+func (fv *fieldValue) packed() bool {
+	f := fv.fd
+	if f.GetLabel() != descriptorpb.FieldDescriptorProto_LABEL_REPEATED || wireType(f.GetType()) == protowire.BytesType || isMessageField(f) {
+		return false
+	}
+	if f.GetOptions() != nil && f.Options.Packed != nil {
+		return f.Options.GetPacked()
+	}
+	return fv.proto3
+}
+
+func isMessageField(f *descriptorpb.FieldDescriptorProto) bool {
+	typ := f.GetType()
+	return typ == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE || typ == descriptorpb.FieldDescriptorProto_TYPE_GROUP
+}
+
+// wireType returns the wire type of scalar fields of type typ.
+func wireType(typ descriptorpb.FieldDescriptorProto_Type) protowire.Type {
+	switch typ {
+	case descriptorpb.FieldDescriptorProto_TYPE_FIXED32, descriptorpb.FieldDescriptorProto_TYPE_SFIXED32, descriptorpb.FieldDescriptorProto_TYPE_FLOAT:
+		return protowire.Fixed32Type
+	case descriptorpb.FieldDescriptorProto_TYPE_FIXED64, descriptorpb.FieldDescriptorProto_TYPE_SFIXED64, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE:
+		return protowire.Fixed64Type
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING, descriptorpb.FieldDescriptorProto_TYPE_BYTES, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+		return protowire.BytesType
+	}
+	return protowire.VarintType
+}
+
+// encodeInt returns the encoding of v as a value of a signed integer or
+// enum field of type typ.
+func encodeInt(typ descriptorpb.FieldDescriptorProto_Type, v int64) []byte {
+	switch typ {
+	case descriptorpb.FieldDescriptorProto_TYPE_SINT32, descriptorpb.FieldDescriptorProto_TYPE_SINT64:
+		return protowire.AppendVarint(nil, protowire.EncodeZigZag(v))
+	case descriptorpb.FieldDescriptorProto_TYPE_SFIXED32:
+		return protowire.AppendFixed32(nil, uint32(v))
+	case descriptorpb.FieldDescriptorProto_TYPE_SFIXED64:
+		return protowire.AppendFixed64(nil, uint64(v))
+	}
+	return protowire.AppendVarint(nil, uint64(v))
+}
+
+// encodeUint returns the encoding of v as a value of an unsigned integer
+// field of type typ.
+func encodeUint(typ descriptorpb.FieldDescriptorProto_Type, v uint64) []byte {
+	switch typ {
+	case descriptorpb.FieldDescriptorProto_TYPE_FIXED32:
+		return protowire.AppendFixed32(nil, uint32(v))
+	case descriptorpb.FieldDescriptorProto_TYPE_FIXED64:
+		return protowire.AppendFixed64(nil, v)
+	}
+	return protowire.AppendVarint(nil, v)
+}
+
+// encodeFloat returns the encoding of v as a value of a float or double
+// field of type typ.
+func encodeFloat(typ descriptorpb.FieldDescriptorProto_Type, v float64) []byte {
+	if typ == descriptorpb.FieldDescriptorProto_TYPE_FLOAT {
+		return protowire.AppendFixed32(nil, math.Float32bits(float32(v)))
+	}
+	return protowire.AppendFixed64(nil, math.Float64bits(v))
+}
+
+// fieldNamed returns the field of m named name, or nil.
+func fieldNamed(m *descriptorpb.DescriptorProto, name string) *descriptorpb.FieldDescriptorProto {
+	for _, f := range m.Field {
+		if f.GetName() == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// enumValueNamed returns the value of the enum typ named name, or nil.
+func enumValueNamed(typ *optionType, name string) *descriptorpb.EnumValueDescriptorProto {
+	if typ == nil || typ.enum == nil {
+		return nil
+	}
+	for _, v := range typ.enum.Value {
+		if v.GetName() == name {
+			return v
+		}
+	}
+	return nil
+}
+
+// shortTypeName returns the last component of the full name of a type.
+func shortTypeName(full string) string {
+	return full[strings.LastIndexByte(full, '.')+1:]
+}
@@ -0,0 +1,180 @@
+package parser
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// unquote interprets lit as a quoted string literal, returning the string
+// value that lit quotes. Escapes follow the C-style rules used by protoc;
+// \x and octal escapes produce raw bytes.
+func unquote(lit string) (string, error) {
+	if len(lit) == 0 {
+		return "", nil
+	}
+	// the closing quote is missing from an unterminated literal, which the
+	// scanner has already reported
+	s := lit[1:]
+	if len(s) > 0 && s[len(s)-1] == lit[0] {
+		s = s[:len(s)-1]
+	}
+	if strings.IndexByte(s, '\\') < 0 {
+		return s, nil
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 == len(s) {
+			sb.WriteByte(c)
+			continue
+		}
+		i++
+		switch c = s[i]; c {
+		case 'a':
+			sb.WriteByte('\a')
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'v':
+			sb.WriteByte('\v')
+		case '\\', '\'', '"', '?':
+			sb.WriteByte(c)
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			v := 0
+			j := i
+			for ; j < len(s) && j < i+3 && '0' <= s[j] && s[j] <= '7'; j++ {
+				v = v*8 + int(s[j]-'0')
+			}
+			if v > math.MaxUint8 {
+				return sb.String(), errors.New("octal escape value > 255: " + s[i-1:j])
+			}
+			sb.WriteByte(byte(v))
+			i = j - 1
+		case 'x', 'X':
+			j := i + 1
+			for ; j < len(s) && j < i+3 && isHexDigit(s[j]); j++ {
+			}
+			v, err := strconv.ParseUint(s[i+1:j], 16, 8)
+			if err != nil {
+				return sb.String(), errors.New("invalid escape sequence: " + s[i-1:j])
+			}
+			sb.WriteByte(byte(v))
+			i = j - 1
+		case 'u', 'U':
+			n := 4
+			if c == 'U' {
+				n = 8
+			}
+			if i+1+n > len(s) {
+				return sb.String(), errors.New("invalid escape sequence: " + s[i-1:])
+			}
+			v, err := strconv.ParseUint(s[i+1:i+1+n], 16, 32)
+			if err != nil || v > utf8.MaxRune {
+				return sb.String(), errors.New("invalid escape sequence: " + s[i-1:i+1+n])
+			}
+			sb.WriteRune(rune(v))
+			i += n
+		default:
+			return sb.String(), errors.New("invalid escape sequence: " + s[i-1:i+1])
+		}
+	}
+	return sb.String(), nil
+}
+
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// cEscape escapes s the way protoc writes the default value of a bytes
+// field: common control characters and quotes use C escapes, and any other
+// byte that is not printable ASCII is written as a three digit octal escape.
+func cEscape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '"':
+			sb.WriteString(`\"`)
+		case '\'':
+			sb.WriteString(`\'`)
+		case '\\':
+			sb.WriteString(`\\`)
+		default:
+			if c < 0x20 || c >= 0x7f {
+				sb.WriteByte('\\')
+				sb.WriteByte('0' + c>>6)
+				sb.WriteByte('0' + c>>3&7)
+				sb.WriteByte('0' + c&7)
+				continue
+			}
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// formatDefault formats v, the default value of a field of type typ, the
+// way protoc writes it: as a float, if the field is one, and otherwise as
+// a double.
+func formatDefault(typ descriptorpb.FieldDescriptorProto_Type, v float64) string {
+	if typ == descriptorpb.FieldDescriptorProto_TYPE_FLOAT {
+		return formatFloat(float32(v))
+	}
+	return formatDouble(v)
+}
+
+// formatFloat formats v the way protoc writes the default value of a
+// float field: with 6 significant digits, or 9 if that is needed to
+// represent v exactly.
+func formatFloat(v float32) string {
+	switch {
+	case math.IsInf(float64(v), 1):
+		return "inf"
+	case math.IsInf(float64(v), -1):
+		return "-inf"
+	case math.IsNaN(float64(v)):
+		return "nan"
+	}
+	s := strconv.FormatFloat(float64(v), 'g', 6, 32)
+	if f, _ := strconv.ParseFloat(s, 32); float32(f) != v {
+		s = strconv.FormatFloat(float64(v), 'g', 9, 32)
+	}
+	return s
+}
+
+// formatDouble formats v the way protoc writes the default value of a
+// double field: with 15 significant digits, or 17 if that is needed to
+// represent v exactly.
+func formatDouble(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "inf"
+	case math.IsInf(v, -1):
+		return "-inf"
+	case math.IsNaN(v):
+		return "nan"
+	}
+	s := strconv.FormatFloat(v, 'g', 15, 64)
+	if f, _ := strconv.ParseFloat(s, 64); f != v {
+		s = strconv.FormatFloat(v, 'g', 17, 64)
+	}
+	return s
+}
@@ -0,0 +1,456 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"rogchap.com/protoparser/internal/descriptor"
+	"rogchap.com/protoparser/internal/token"
+)
+
+// parseOption parses an option statement setting an option in opts, the
+// options of the element whose options are at path.
+func (p *parser) parseOption(opts proto.Message, path []int32) {
+	// option = "option" optionName  "=" constant ";"
+	loc := p.startLoc(path)
+	defer p.endLoc(loc)
+	// the location of the option itself has the same span as the
+	// statement; its path is known once the option has been set
+	inner := p.startDecl(nil)
+	defer p.endLoc(inner)
+	p.next()
+	pos := p.pos
+	name := p.parseOptionName()
+	p.expect(token.ASSIGN)
+	inner.setPath(p.setOption(opts, path, pos, p.parseOptionValue(name)))
+	p.expectSemi()
+	p.endDecl(inner)
+}
+
+// parseCompactOptions parses a bracketed list of options setting options in
+// opts, the options of the element whose options are at path.
+func (p *parser) parseCompactOptions(opts proto.Message, path []int32) {
+	// "[" optionName "=" constant { "," optionName "=" constant } "]"
+	loc := p.startLoc(path)
+	defer p.endLoc(loc)
+	p.expect(token.LBRACK)
+	for {
+		p.parseCompactOption(opts, path)
+		if p.tok != token.COMMA {
+			break
+		}
+		p.next()
+	}
+	p.expect(token.RBRACK)
+}
+
+func (p *parser) parseCompactOption(opts proto.Message, path []int32) {
+	loc := p.startLoc(nil)
+	defer p.endLoc(loc)
+	pos := p.pos
+	name := p.parseOptionName()
+	p.expect(token.ASSIGN)
+	loc.setPath(p.setOption(opts, path, pos, p.parseOptionValue(name)))
+}
+
+// parseFieldOptions parses the options of the field fld at path.
+func (p *parser) parseFieldOptions(fld *descriptorpb.FieldDescriptorProto, path []int32) {
+	// fieldOptions = "[" fieldOption { ","  fieldOption } "]"
+	// fieldOption = optionName "=" constant
+	//
+	// "default" and "json_name" look like options but are stored in the
+	// field itself.
+	var hasJSONName bool
+	loc := p.startLoc(subpath(path, descriptor.FieldOptionsTag))
+	defer p.endLoc(loc)
+	p.expect(token.LBRACK)
+	for {
+		pos := p.pos
+		switch {
+		case p.tok == token.IDENT && p.lit == "default":
+			if fld.DefaultValue != nil {
+				p.error(pos, `option "default" was already set`)
+			}
+			p.next()
+			p.expect(token.ASSIGN)
+			valueLoc := p.startLoc(subpath(path, descriptor.FieldDefaultTag))
+			fld.DefaultValue = proto.String(p.parseDefault(fld))
+			p.endLoc(valueLoc)
+		case p.tok == token.IDENT && p.lit == "json_name":
+			if hasJSONName {
+				p.error(pos, `option "json_name" was already set`)
+			}
+			hasJSONName = true
+			// protoc records both the assignment and the value alone
+			optLoc := p.startLoc(subpath(path, descriptor.FieldJSONNameTag))
+			p.next()
+			p.expect(token.ASSIGN)
+			valueLoc := p.startLoc(subpath(path, descriptor.FieldJSONNameTag))
+			fld.JsonName = proto.String(p.parseStrLit())
+			p.endLoc(valueLoc)
+			p.endLoc(optLoc)
+		default:
+			if fld.Options == nil {
+				fld.Options = &descriptorpb.FieldOptions{}
+			}
+			p.parseCompactOption(fld.Options, subpath(path, descriptor.FieldOptionsTag))
+		}
+		if p.tok != token.COMMA {
+			break
+		}
+		p.next()
+	}
+	p.expect(token.RBRACK)
+}
+
+func (p *parser) parseOptionName() []*descriptorpb.UninterpretedOption_NamePart {
+	// optionName = ( ident | "(" fullIdent ")" ) { "." ( ident | "(" fullIdent ")" ) }
+	var parts []*descriptorpb.UninterpretedOption_NamePart
+	for {
+		var part descriptorpb.UninterpretedOption_NamePart
+		if p.tok == token.LPAREN {
+			p.next()
+			name := ""
+			if p.tok == token.DOT {
+				name = p.lit
+				p.next()
+			}
+			name += p.parseFullIdent("option name")
+			p.expect(token.RPAREN)
+			part.NamePart = proto.String(name)
+			part.IsExtension = proto.Bool(true)
+		} else {
+			part.NamePart = proto.String(p.parseIdent("option name"))
+			part.IsExtension = proto.Bool(false)
+		}
+		parts = append(parts, &part)
+		if p.tok != token.DOT {
+			break
+		}
+		p.next()
+	}
+	return parts
+}
+
+func (p *parser) parseOptionValue(name []*descriptorpb.UninterpretedOption_NamePart) *descriptorpb.UninterpretedOption {
+	// constant = fullIdent | ( [ "-" ] intLit ) | ( [ "-" ] floatLit ) |
+	//            strLit | boolLit | aggregate
+	uo := &descriptorpb.UninterpretedOption{Name: name}
+
+	neg := p.tok == token.MINUS
+	if neg {
+		p.next()
+	}
+	tok := p.tok
+	if p.isIdent() {
+		tok = token.IDENT // keywords are identifiers here too
+	}
+	switch tok {
+	case token.IDENT:
+		switch {
+		case !neg:
+			uo.IdentifierValue = proto.String(p.lit)
+		case p.lit == "inf":
+			uo.DoubleValue = proto.Float64(math.Inf(-1))
+		case p.lit == "nan":
+			uo.DoubleValue = proto.Float64(math.NaN())
+		default:
+			p.error(p.pos, "identifier after '-' must be inf or nan")
+		}
+		p.next()
+	case token.INT:
+		v, err := strconv.ParseUint(p.lit, 0, 64)
+		switch {
+		case err != nil || neg && v > 1<<63:
+			p.error(p.pos, "integer out of range: "+p.lit)
+		case neg:
+			uo.NegativeIntValue = proto.Int64(-int64(v))
+		default:
+			uo.PositiveIntValue = proto.Uint64(v)
+		}
+		p.next()
+	case token.FLOAT:
+		v, _ := strconv.ParseFloat(p.lit, 64) // out of range values become ±Inf, as in protoc
+		if neg {
+			v = -v
+		}
+		uo.DoubleValue = proto.Float64(v)
+		p.next()
+	case token.STRING:
+		if neg {
+			p.error(p.pos, "unexpected '-' before string")
+		}
+		uo.StringValue = []byte(p.parseStrLit())
+	case token.LBRACE:
+		if neg {
+			p.error(p.pos, "unexpected '-' before aggregate value")
+		}
+		uo.AggregateValue = proto.String(p.parseAggregate())
+	default:
+		p.errorExpected(p.pos, "option value")
+	}
+	return uo
+}
+
+// parseAggregate parses a message value written in the text format. The
+// value is kept as text, its tokens separated by single spaces as protoc
+// does, and is only interpreted once the option's type is known.
+func (p *parser) parseAggregate() string {
+	// aggregate = "{" text format message "}"
+	var sb strings.Builder
+	p.expect(token.LBRACE)
+	for depth := 1; p.tok != token.EOF; p.next() {
+		switch p.tok {
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			depth--
+		}
+		if depth == 0 {
+			p.next()
+			return sb.String()
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		if p.lit != "" {
+			sb.WriteString(p.lit)
+		} else {
+			sb.WriteString(p.tok.String())
+		}
+	}
+	p.errorExpected(p.pos, "'}'")
+	return sb.String()
+}
+
+func (p *parser) parseDefault(fld *descriptorpb.FieldDescriptorProto) string {
+	// The default value is stored as text; how it is written depends on the
+	// type of the field.
+	if fld.Type == nil {
+		// An enum value, or a message type (which is an error reported
+		// once the type is resolved).
+		lit := p.lit
+		if lit == "" {
+			lit = p.tok.String()
+		}
+		p.next()
+		return lit
+	}
+	switch fld.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_FLOAT, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE:
+		sign := ""
+		if p.tok == token.MINUS {
+			sign = "-"
+			p.next()
+		}
+		switch {
+		case p.tok == token.INT:
+			v, err := strconv.ParseUint(p.lit, 0, 64)
+			if err != nil {
+				p.error(p.pos, "integer out of range: "+p.lit)
+			}
+			p.next()
+			return sign + formatDefault(fld.GetType(), float64(v))
+		case p.tok == token.FLOAT:
+			v, _ := strconv.ParseFloat(p.lit, 64)
+			p.next()
+			return sign + formatDefault(fld.GetType(), v)
+		case p.tok == token.IDENT && (p.lit == "inf" || p.lit == "nan"):
+			lit := p.lit
+			p.next()
+			return sign + lit
+		}
+		p.errorExpected(p.pos, "number")
+		return ""
+	case descriptorpb.FieldDescriptorProto_TYPE_INT32, descriptorpb.FieldDescriptorProto_TYPE_SINT32, descriptorpb.FieldDescriptorProto_TYPE_SFIXED32:
+		return p.parseIntDefault(math.MaxInt32)
+	case descriptorpb.FieldDescriptorProto_TYPE_INT64, descriptorpb.FieldDescriptorProto_TYPE_SINT64, descriptorpb.FieldDescriptorProto_TYPE_SFIXED64:
+		return p.parseIntDefault(math.MaxInt64)
+	case descriptorpb.FieldDescriptorProto_TYPE_UINT32, descriptorpb.FieldDescriptorProto_TYPE_FIXED32:
+		v, _ := p.parseUint("integer", math.MaxUint32)
+		return strconv.FormatUint(v, 10)
+	case descriptorpb.FieldDescriptorProto_TYPE_UINT64, descriptorpb.FieldDescriptorProto_TYPE_FIXED64:
+		v, _ := p.parseUint("integer", math.MaxUint64)
+		return strconv.FormatUint(v, 10)
+	case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
+		if p.tok == token.IDENT && (p.lit == "true" || p.lit == "false") {
+			lit := p.lit
+			p.next()
+			return lit
+		}
+		p.errorExpected(p.pos, "'true' or 'false'")
+		return ""
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING:
+		return p.parseStrLit()
+	case descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		return cEscape(p.parseStrLit())
+	}
+	p.error(p.pos, "messages can't have default values")
+	p.next()
+	return ""
+}
+
+// parseIntDefault parses an optionally negative integer in the range
+// [-max-1, max] and returns it in decimal form.
+func (p *parser) parseIntDefault(max uint64) string {
+	neg := p.tok == token.MINUS
+	if neg {
+		p.next()
+		max++
+	}
+	v, _ := p.parseUint("integer", max)
+	if neg {
+		return "-" + strconv.FormatUint(v, 10)
+	}
+	return strconv.FormatUint(v, 10)
+}
+
+// setOption records uo, whose name is at pos, in opts, the options at
+// path. An option that names a field of opts, such as java_package, is
+// set directly; custom options are kept uninterpreted until the extensions
+// they refer to are known, as are options naming fields that the protobuf
+// runtime's opts lacks, such as debug_redact, until descriptor.proto is
+// known. It returns the path of the option.
+func (p *parser) setOption(opts proto.Message, path []int32, pos token.Pos, uo *descriptorpb.UninterpretedOption) []int32 {
+	m := opts.ProtoReflect()
+	uninterpreted := m.Descriptor().Fields().ByNumber(descriptor.UninterpretedOptionField)
+	uninterpretedPath := subpath(path, descriptor.UninterpretedOptionField, int32(m.Get(uninterpreted).List().Len()))
+	keep := func() []int32 {
+		m.Mutable(uninterpreted).List().Append(protoreflect.ValueOfMessage(uo.ProtoReflect()))
+		if p.optionNames == nil {
+			p.optionNames = make(map[string]token.Pos)
+		}
+		p.optionNames[pathKey(uninterpretedPath)] = pos
+		return uninterpretedPath
+	}
+	if len(uo.Name) != 1 || uo.Name[0].GetIsExtension() {
+		return keep()
+	}
+
+	name := uo.Name[0].GetNamePart()
+	fd := m.Descriptor().Fields().ByName(protoreflect.Name(name))
+	if fd == nil {
+		return keep()
+	}
+	if fd.Number() == descriptor.UninterpretedOptionField {
+		p.error(pos, fmt.Sprintf("option %q unknown", name))
+		return uninterpretedPath
+	}
+	if !fd.IsList() && m.Has(fd) {
+		p.error(pos, fmt.Sprintf("option %q was already set", name))
+		return uninterpretedPath
+	}
+	v, err := optionValue(name, fd, uo)
+	if err != nil {
+		p.error(pos, err.Error())
+		return uninterpretedPath
+	}
+	if fd.IsList() {
+		l := m.Mutable(fd).List()
+		l.Append(v)
+		return subpath(path, int32(fd.Number()), int32(l.Len()-1))
+	}
+	m.Set(fd, v)
+	return subpath(path, int32(fd.Number()))
+}
+
+// optionValue converts the value of uo to a value for the field fd of an
+// options message.
+func optionValue(name string, fd protoreflect.FieldDescriptor, uo *descriptorpb.UninterpretedOption) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		switch uo.GetIdentifierValue() {
+		case "true":
+			return protoreflect.ValueOfBool(true), nil
+		case "false":
+			return protoreflect.ValueOfBool(false), nil
+		}
+		return protoreflect.Value{}, fmt.Errorf("value must be \"true\" or \"false\" for boolean option %q", name)
+	case protoreflect.EnumKind:
+		if uo.IdentifierValue == nil {
+			return protoreflect.Value{}, fmt.Errorf("value must be identifier for enum-valued option %q", name)
+		}
+		ev := fd.Enum().Values().ByName(protoreflect.Name(uo.GetIdentifierValue()))
+		if ev == nil {
+			return protoreflect.Value{}, fmt.Errorf("enum type %q has no value named %q for option %q", fd.Enum().FullName(), uo.GetIdentifierValue(), name)
+		}
+		return protoreflect.ValueOfEnum(ev.Number()), nil
+	case protoreflect.StringKind:
+		if uo.StringValue == nil {
+			return protoreflect.Value{}, fmt.Errorf("value must be quoted string for string option %q", name)
+		}
+		return protoreflect.ValueOfString(string(uo.StringValue)), nil
+	case protoreflect.BytesKind:
+		if uo.StringValue == nil {
+			return protoreflect.Value{}, fmt.Errorf("value must be quoted string for bytes option %q", name)
+		}
+		return protoreflect.ValueOfBytes(uo.StringValue), nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		v, err := intOptionValue(name, fd.Kind(), uo, math.MinInt32, math.MaxInt32)
+		return protoreflect.ValueOfInt32(int32(v)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err := intOptionValue(name, fd.Kind(), uo, math.MinInt64, math.MaxInt64)
+		return protoreflect.ValueOfInt64(v), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		v, err := uintOptionValue(name, fd.Kind(), uo, math.MaxUint32)
+		return protoreflect.ValueOfUint32(uint32(v)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err := uintOptionValue(name, fd.Kind(), uo, math.MaxUint64)
+		return protoreflect.ValueOfUint64(v), err
+	case protoreflect.FloatKind:
+		v, err := floatOptionValue(name, fd.Kind(), uo)
+		return protoreflect.ValueOfFloat32(float32(v)), err
+	case protoreflect.DoubleKind:
+		v, err := floatOptionValue(name, fd.Kind(), uo)
+		return protoreflect.ValueOfFloat64(v), err
+	}
+	return protoreflect.Value{}, fmt.Errorf("option %q of type %s cannot be set here", name, fd.Kind())
+}
+
+func intOptionValue(name string, kind protoreflect.Kind, uo *descriptorpb.UninterpretedOption, min, max int64) (int64, error) {
+	switch {
+	case uo.PositiveIntValue != nil:
+		if uo.GetPositiveIntValue() > uint64(max) {
+			return 0, fmt.Errorf("value out of range for %s option %q", kind, name)
+		}
+		return int64(uo.GetPositiveIntValue()), nil
+	case uo.NegativeIntValue != nil:
+		if uo.GetNegativeIntValue() < min {
+			return 0, fmt.Errorf("value out of range for %s option %q", kind, name)
+		}
+		return uo.GetNegativeIntValue(), nil
+	}
+	return 0, fmt.Errorf("value must be integer for %s option %q", kind, name)
+}
+
+func uintOptionValue(name string, kind protoreflect.Kind, uo *descriptorpb.UninterpretedOption, max uint64) (uint64, error) {
+	if uo.PositiveIntValue == nil {
+		return 0, fmt.Errorf("value must be non-negative integer for %s option %q", kind, name)
+	}
+	if uo.GetPositiveIntValue() > max {
+		return 0, fmt.Errorf("value out of range for %s option %q", kind, name)
+	}
+	return uo.GetPositiveIntValue(), nil
+}
+
+func floatOptionValue(name string, kind protoreflect.Kind, uo *descriptorpb.UninterpretedOption) (float64, error) {
+	switch {
+	case uo.DoubleValue != nil:
+		return uo.GetDoubleValue(), nil
+	case uo.PositiveIntValue != nil:
+		return float64(uo.GetPositiveIntValue()), nil
+	case uo.NegativeIntValue != nil:
+		return float64(uo.GetNegativeIntValue()), nil
+	case uo.GetIdentifierValue() == "inf":
+		return math.Inf(1), nil
+	case uo.GetIdentifierValue() == "nan":
+		return math.NaN(), nil
+	}
+	return 0, fmt.Errorf("value must be number for %s option %q", kind, name)
+}
@@ -1,127 +1,449 @@
 package parser
 
 import (
+	"math"
 	"strconv"
 	"strings"
 
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/descriptorpb"
 
+	"rogchap.com/protoparser/internal/descriptor"
 	"rogchap.com/protoparser/internal/scanner"
 	"rogchap.com/protoparser/internal/token"
 )
 
+// The end of a reserved or extension range declared with "max", to be
+// replaced once the message options are known.
+const maxRangeSentinel = -1
+
+// maxNestLev bounds the nesting of messages and groups, and of the
+// messages in aggregate option values, so that hostile input cannot
+// exhaust the stack.
+const maxNestLev = 100
+
 type parser struct {
+	file    *token.File
+	src     []byte
+	errors  scanner.ErrorList
 	scanner scanner.Scanner
+	mode    Mode
+
+	errLine int // line of the most recent error
 
+	pos token.Pos   // token position
 	tok token.Token // last read token
 	lit string      // token literal
 
-	// TODO: Deal with errors
+	syntax string // "proto2" or "proto3"
+
+	nestLev int // message nesting level
+
+	prevEnd  token.Pos            // end of the previous token
+	locs     []location           // source locations, in protoc order
+	locIndex map[string]token.Pos // start of the first location at each path
+	doc      docComments          // comments before the current token
+	trailing *string              // trailing comment of the previous token
+
+	docGroups  [][]comment // the comments of doc
+	trailGroup []comment   // the comment of trailing
+	orphans    []Comment   // comments given to no declaration
+
+	optionNames map[string]token.Pos // names of the uninterpreted options, by path
 }
 
-func (p *parser) init(src []byte) {
-	p.scanner.Init(src)
+func (p *parser) init(filename string, src []byte, mode Mode) {
+	p.file = token.NewFile(filename, len(src))
+	p.src = src
+	p.mode = mode
+	eh := func(pos token.Position, msg string) {
+		snippet := scanner.NewSnippet(p.src, pos, 0)
+		if p.mode&ProtocErrors != 0 {
+			pos.Column = protocColumn(p.src, pos)
+			msg = protocMessage(msg)
+		}
+		p.errors = append(p.errors, &scanner.Error{Pos: pos, Msg: msg, Code: scanner.CodeSyntax, Snippet: snippet})
+		p.errLine = pos.Line
+	}
+	var m scanner.Mode
+	if mode&IncludeSourceInfo != 0 {
+		m = scanner.ScanComments
+	}
+	p.scanner.Init(p.file, src, eh, m)
 	p.next()
 }
 
 func (p *parser) next() {
-	p.tok, p.lit = p.scanner.Scan()
+	hasPrev := p.pos.IsValid()
+	if hasPrev {
+		p.prevEnd = p.pos + token.Pos(p.tokenLen())
+	}
+	if p.mode&IncludeSourceInfo == 0 {
+		p.pos, p.tok, p.lit = p.scanner.Scan()
+		return
+	}
+
+	var comments []comment
+	for {
+		p.pos, p.tok, p.lit = p.scanner.Scan()
+		if p.tok != token.COMMENT {
+			break
+		}
+		comments = append(comments, comment{p.pos, p.lit})
+	}
+	p.attributeComments(hasPrev, comments)
+}
+
+// error reports an error at pos, with optional notes. An error on the
+// same line as the previous error is dropped, as it is most likely a
+// follow-on error from the same broken statement.
+func (p *parser) error(pos token.Pos, msg string, notes ...*scanner.Note) {
+	epos := p.position(pos)
+	if epos.Line == p.errLine {
+		return
+	}
+	if p.mode&ProtocErrors != 0 {
+		msg = protocMessage(msg)
+	}
+	n := 0
+	if pos == p.pos {
+		n = p.tokenLen()
+	}
+	p.errors = append(p.errors, &scanner.Error{
+		Pos:     epos,
+		Msg:     msg,
+		Code:    scanner.CodeSyntax,
+		Snippet: scanner.NewSnippet(p.src, p.file.Position(pos), n),
+		Notes:   notes,
+	})
+	p.errLine = epos.Line
+}
+
+// report records a diagnostic that doesn't come from malformed source,
+// such as a style warning, for the n bytes of source at pos.
+func (p *parser) report(pos token.Pos, n int, sev scanner.Severity, code, msg string, notes ...*scanner.Note) {
+	if p.mode&ProtocErrors != 0 {
+		msg = protocMessage(msg)
+	}
+	p.errors = append(p.errors, &scanner.Error{
+		Pos:      p.position(pos),
+		Msg:      msg,
+		Severity: sev,
+		Code:     code,
+		Snippet:  scanner.NewSnippet(p.src, p.file.Position(pos), n),
+		Notes:    notes,
+	})
+}
+
+// note returns a Note about the n bytes of source at pos, to be attached
+// to an error.
+func (p *parser) note(pos token.Pos, n int, msg string) *scanner.Note {
+	return &scanner.Note{
+		Pos:     p.position(pos),
+		Msg:     msg,
+		Snippet: scanner.NewSnippet(p.src, p.file.Position(pos), n),
+	}
+}
+
+// tokenLen returns the length in bytes of the current token.
+func (p *parser) tokenLen() int {
+	switch {
+	case p.tok == token.EOF:
+		return 0
+	case p.lit != "":
+		return len(p.lit)
+	}
+	return len(p.tok.String())
+}
+
+// position returns the Position of pos, with the column counted as
+// protoc counts it if the ProtocErrors mode is set.
+func (p *parser) position(pos token.Pos) token.Position {
+	epos := p.file.Position(pos)
+	if p.mode&ProtocErrors != 0 {
+		epos.Column = protocColumn(p.src, epos)
+	}
+	return epos
+}
+
+func (p *parser) errorExpected(pos token.Pos, msg string) {
+	if p.mode&ProtocErrors != 0 {
+		// protoc doesn't say what it found instead
+		p.error(pos, protocExpectedMessage(msg))
+		return
+	}
+	msg = "expected " + msg
+	if pos == p.pos {
+		// the error happened at the current position;
+		// make the error message more specific
+		if p.tok.IsLiteral() {
+			// print 123 rather than 'INT', etc.
+			msg += ", found " + p.lit
+		} else {
+			msg += ", found '" + p.tok.String() + "'"
+		}
+	}
+	p.error(pos, msg)
 }
 
-func (p *parser) expect(tok token.Token) {
+// expect reports an error if the current token is not tok, otherwise it
+// consumes the token. The current token is left in place on a mismatch
+// so that the caller can resynchronise on it.
+func (p *parser) expect(tok token.Token) token.Pos {
+	pos := p.pos
 	if p.tok != tok {
-		// TODO: deal with errors
+		p.errorExpected(pos, "'"+tok.String()+"'")
+		return pos
 	}
 	p.next()
+	return pos
+}
+
+// expectSemi consumes the ';' that terminates a statement. If it is
+// missing, the rest of the statement is skipped.
+func (p *parser) expectSemi() {
+	if p.tok == token.SEMICOLON {
+		p.next()
+		return
+	}
+	p.errorExpected(p.pos, "';'")
+	p.skipStmt()
+}
+
+// skipStmt advances to the next statement boundary: past the next ';' or
+// the end of a block that was opened along the way, or up to (but not
+// including) a '}' that closes the enclosing block or a keyword that
+// starts a top-level statement.
+func (p *parser) skipStmt() {
+	depth := 0
+	for {
+		switch p.tok {
+		case token.EOF:
+			return
+		case token.SEMICOLON:
+			if depth == 0 {
+				p.next()
+				return
+			}
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			if depth == 0 {
+				return
+			}
+			depth--
+			if depth == 0 {
+				p.next()
+				return
+			}
+		case token.SYNTAX, token.IMPORT, token.PACKAGE, token.OPTION,
+			token.MESSAGE, token.ENUM, token.SERVICE, token.EXTEND:
+			if depth == 0 {
+				return
+			}
+		}
+		p.next()
+	}
+}
+
+// badStmt reports an unexpected token where a statement of kind what
+// should start and skips to the next statement boundary.
+func (p *parser) badStmt(what string) {
+	p.errorExpected(p.pos, what)
+	p.next() // always make progress
+	p.skipStmt()
+}
+
+// isIdent reports whether the current token can be used as a name. As in
+// protoc, keywords are only reserved at the start of the statements they
+// introduce, so any keyword is also an identifier.
+func (p *parser) isIdent() bool {
+	return p.tok == token.IDENT || p.tok.IsKeyword()
+}
+
+func (p *parser) parseIdent(what string) string {
+	if !p.isIdent() {
+		p.errorExpected(p.pos, what)
+		if p.tok.IsLiteral() {
+			p.next() // consume the misplaced name
+		}
+		return ""
+	}
+	ident := p.lit
+	p.next()
+	return ident
 }
 
 func (p *parser) parseStrLit() string {
+	// adjacent string literals are concatenated, as in C
 	if p.tok != token.STRING {
-		//TODO: deal with error
+		p.errorExpected(p.pos, "string literal")
 		return ""
 	}
-	return string(p.lit[1 : len(p.lit)-1]) // strip the quotes
+	var sb strings.Builder
+	for p.tok == token.STRING {
+		s, err := unquote(p.lit)
+		if err != nil {
+			p.error(p.pos, err.Error())
+		}
+		sb.WriteString(s)
+		p.next()
+	}
+	return sb.String()
 }
 
-func (p *parser) parseBoolLit() bool {
-	b, ok := strconv.ParseBool(p.lit)
-	if !ok {
-		//TODO: deal with error
-		return false
+// parseUint parses an unsigned integer literal no larger than max.
+func (p *parser) parseUint(what string, max uint64) (uint64, bool) {
+	if p.tok != token.INT {
+		p.errorExpected(p.pos, what)
+		return 0, false
 	}
-	return b
+	v, err := strconv.ParseUint(p.lit, 0, 64)
+	if err != nil || v > max {
+		p.error(p.pos, "integer out of range: "+p.lit)
+		p.next()
+		return 0, false
+	}
+	p.next()
+	return v, true
+}
+
+// parseInt32 parses an optionally negative integer literal that fits in
+// an int32.
+func (p *parser) parseInt32(what string) (int32, bool) {
+	neg := p.tok == token.MINUS
+	if neg {
+		p.next()
+	}
+	max := uint64(math.MaxInt32)
+	if neg {
+		max++
+	}
+	v, ok := p.parseUint(what, max)
+	if neg {
+		return int32(-int64(v)), ok
+	}
+	return int32(v), ok
 }
 
 func (p *parser) parseSyntax() string {
+	// syntax = "syntax" "=" quote "proto3" quote ";"
+	loc := p.startDecl([]int32{descriptor.FileSyntaxTag})
+	defer p.endLoc(loc)
 	p.next()
 	p.expect(token.ASSIGN)
+	pos := p.pos
 	s := p.parseStrLit()
-	p.expect(token.SEMICOLON)
+	if s != "proto2" && s != "proto3" {
+		p.error(pos, "unrecognized syntax "+strconv.Quote(s)+", expected \"proto2\" or \"proto3\"")
+		s = "proto2"
+	}
+	p.expectSemi()
+	p.endDecl(loc)
 	return s
 }
 
-func (p *parser) parseFullIdent() string {
+func (p *parser) parseFullIdent(what string) string {
+	// fullIdent = ident { "." ident }
 	var sb strings.Builder
-	if p.tok != token.IDENT {
-		//TODO: deal with error
-		return ""
-	}
-	sb.WriteString(p.lit)
-	prevTok := p.tok
-	p.next()
-
-	for p.tok == token.IDENT || p.tok == token.DOT {
-		if p.tok == prevTok {
-			//TODO: deal with error: invalid package name
-			return ""
-		}
+	sb.WriteString(p.parseIdent(what))
+	for p.tok == token.DOT {
 		sb.WriteString(p.lit)
-		prevTok = p.tok
 		p.next()
+		sb.WriteString(p.parseIdent("identifier"))
 	}
-
 	return sb.String()
 }
 
+func (p *parser) parseTypeName() string {
+	// messageType = [ "." ] { ident "." } messageName
+	// enumType = [ "." ] { ident "." } enumName
+	if p.tok == token.DOT {
+		p.next()
+		return "." + p.parseFullIdent("type name")
+	}
+	return p.parseFullIdent("type name")
+}
+
 func (p *parser) parsePackage() string {
+	// package = "package" fullIdent ";"
+	loc := p.startDecl([]int32{descriptor.FilePackageTag})
+	defer p.endLoc(loc)
 	p.next()
-	s := p.parseFullIdent()
-	p.expect(token.SEMICOLON)
+	s := p.parseFullIdent("package name")
+	p.expectSemi()
+	p.endDecl(loc)
 	return s
 }
 
-func (p *parser) parseDependency() (dep string, isPublic, isWeak bool) {
+func (p *parser) parseDependency(index, publicIndex, weakIndex int) (dep string, isPublic, isWeak bool) {
+	// import = "import" [ "weak" | "public" ] strLit ";"
+	loc := p.startDecl([]int32{descriptor.FileDependencyTag, int32(index)})
+	defer p.endLoc(loc)
 	p.next()
 	isPublic = p.tok == token.PUBLIC
 	isWeak = p.tok == token.WEAK
-	if isPublic || isWeak {
+	switch {
+	case isPublic:
+		p.tokenLoc([]int32{descriptor.FilePublicDependencyTag, int32(publicIndex)})
+		p.next()
+	case isWeak:
+		p.tokenLoc([]int32{descriptor.FileWeakDependencyTag, int32(weakIndex)})
 		p.next()
 	}
 	dep = p.parseStrLit()
-	p.expect(token.SEMICOLON)
+	p.expectSemi()
+	p.endDecl(loc)
 	return
 }
 
-//TODO Remove once finished
-func (p *parser) _skipTo(tok token.Token) {
-	for p.tok != tok && p.tok != token.EOF {
-		p.next()
-	}
-	p.next()
+var scalarTypes = map[string]descriptorpb.FieldDescriptorProto_Type{
+	"double":   descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	"float":    descriptorpb.FieldDescriptorProto_TYPE_FLOAT,
+	"int64":    descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	"uint64":   descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+	"int32":    descriptorpb.FieldDescriptorProto_TYPE_INT32,
+	"fixed64":  descriptorpb.FieldDescriptorProto_TYPE_FIXED64,
+	"fixed32":  descriptorpb.FieldDescriptorProto_TYPE_FIXED32,
+	"bool":     descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	"string":   descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	"bytes":    descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+	"uint32":   descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+	"sfixed32": descriptorpb.FieldDescriptorProto_TYPE_SFIXED32,
+	"sfixed64": descriptorpb.FieldDescriptorProto_TYPE_SFIXED64,
+	"sint32":   descriptorpb.FieldDescriptorProto_TYPE_SINT32,
+	"sint64":   descriptorpb.FieldDescriptorProto_TYPE_SINT64,
 }
 
 func (p *parser) parserFieldType() (typ descriptorpb.FieldDescriptorProto_Type, name string) {
-	key := "TYPE_" + strings.ToUpper(p.lit)
-	typ = descriptorpb.FieldDescriptorProto_Type(descriptorpb.FieldDescriptorProto_Type_value[key])
-	if typ == 0 {
-		// TODO: Need to get type name for Message or Enum
-		// will need some sort of lookup
+	// type = "double" | "float" | "int32" | "int64" | "uint32" | "uint64"
+	//       | "sint32" | "sint64" | "fixed32" | "fixed64" | "sfixed32" | "sfixed64"
+	//       | "bool" | "string" | "bytes" | messageType | enumType
+	if p.tok == token.IDENT {
+		if typ, ok := scalarTypes[p.lit]; ok {
+			p.next()
+			return typ, ""
+		}
 	}
-	p.next()
-	return
+	// Message or enum; the type is set once the name has been resolved.
+	return 0, p.parseTypeName()
+}
+
+func (p *parser) parseFieldNumber() int32 {
+	// fieldNumber = intLit;
+	n, _ := p.parseUint("field number", math.MaxInt32)
+	return int32(n)
 }
 
-func (p *parser) parseNormalField() *descriptorpb.FieldDescriptorProto {
+// parseNormalField parses a field, or a group, whose location loc the
+// caller has started; it is ended here. The path of the field is
+// fieldPath, and groupPath is the path that the message declared by a
+// group will have.
+func (p *parser) parseNormalField(loc location, fieldPath, groupPath []int32, inOneof bool) (*descriptorpb.FieldDescriptorProto, *descriptorpb.DescriptorProto) {
+	// field = label type fieldName "=" fieldNumber [ "[" fieldOptions "]" ] ";"
+	// group = label "group" groupName "=" fieldNumber messageBody
+	// oneofField = type fieldName "=" fieldNumber [ "[" fieldOptions "]" ] ";"
+	defer p.endLoc(loc)
 	var (
 		name     string
 		number   int32
@@ -129,130 +451,476 @@ func (p *parser) parseNormalField() *descriptorpb.FieldDescriptorProto {
 		typ      descriptorpb.FieldDescriptorProto_Type
 		typName  string
 		jsonName string
-		opt      *descriptorpb.FieldOptions
+
+		proto3Optional bool
 	)
+	labelPos := p.pos
 	switch p.tok {
 	case token.REPEATED:
 		label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+		p.tokenLoc(subpath(fieldPath, descriptor.FieldLabelTag))
+		p.next()
+	case token.REQUIRED:
+		label = descriptorpb.FieldDescriptorProto_LABEL_REQUIRED
+		if p.syntax == "proto3" {
+			p.error(labelPos, "required fields are not allowed in proto3")
+		}
+		p.tokenLoc(subpath(fieldPath, descriptor.FieldLabelTag))
+		p.next()
+	case token.OPTIONAL:
+		label = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		proto3Optional = p.syntax == "proto3"
+		p.tokenLoc(subpath(fieldPath, descriptor.FieldLabelTag))
 		p.next()
 	default:
 		label = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		if p.syntax == "proto2" && !inOneof {
+			p.errorExpected(labelPos, "'required', 'optional', or 'repeated'")
+		}
 	}
 
-	typ, typName = p.parserFieldType()
-
-	if p.tok != token.IDENT {
-		//TODO: deal with unexpected token
+	var namePos, nameEnd token.Pos
+	if p.tok == token.GROUP {
+		groupPos := p.pos
+		p.tokenLoc(subpath(fieldPath, descriptor.FieldTypeTag))
+		p.next()
+		if p.syntax == "proto3" {
+			p.error(groupPos, "groups are not supported in proto3 syntax")
+		}
+		namePos = p.pos
+		nameLoc := p.startLoc(subpath(fieldPath, descriptor.FieldNameTag))
+		typName = p.parseIdent("group name")
+		p.endLoc(nameLoc)
+		nameEnd = p.prevEnd
+		if typName != "" && !isASCIIUpper(typName[0]) {
+			p.error(namePos, "group names must start with a capital letter")
+		}
+		typ = descriptorpb.FieldDescriptorProto_TYPE_GROUP
+		name = strings.ToLower(typName)
+	} else {
+		typLoc := p.startLoc(subpath(fieldPath, descriptor.FieldTypeTag))
+		typ, typName = p.parserFieldType()
+		if typName != "" {
+			typLoc.setPath(subpath(fieldPath, descriptor.FieldTypeNameTag))
+		}
+		p.endLoc(typLoc)
+		pos := p.pos
+		nameLoc := p.startLoc(subpath(fieldPath, descriptor.FieldNameTag))
+		name = p.parseIdent("field name")
+		p.endLoc(nameLoc)
+		p.checkLowerSnakeCase(pos, "field", name)
 	}
-	name = p.lit
-	jsonName = jsonCamelCase(name)
-	p.next()
+	jsonName = descriptor.JSONCamelCase(name)
 
 	p.expect(token.ASSIGN)
+	numLoc := p.startLoc(subpath(fieldPath, descriptor.FieldNumberTag))
+	number = p.parseFieldNumber()
+	p.endLoc(numLoc)
 
-	if p.tok != token.INT {
-		// TODO: deal with error
-	}
-
-	i, _ := strconv.Atoi(p.lit)
-	number = int32(i)
-	p.next()
-
-	p._skipTo(token.SEMICOLON)
-	return &descriptorpb.FieldDescriptorProto{
+	fld := &descriptorpb.FieldDescriptorProto{
 		Name:     strPtr(name),
 		Number:   &number,
 		Label:    &label,
-		Type:     &typ,
 		TypeName: strPtr(typName),
-		JsonName: strPtr(jsonName),
-		Options:  opt,
+		JsonName: &jsonName,
+	}
+	if typ != 0 {
+		fld.Type = &typ
+	}
+	if proto3Optional {
+		fld.Proto3Optional = &proto3Optional
+	}
+	if p.tok == token.LBRACK {
+		p.parseFieldOptions(fld, fieldPath)
 	}
+
+	if typ == descriptorpb.FieldDescriptorProto_TYPE_GROUP {
+		// A group declares both a field and a message, so their locations
+		// overlap, and both use the group name.
+		groupLoc := p.startLocAt(loc.start, groupPath)
+		defer p.endLoc(groupLoc)
+		groupLoc.moveDoc(loc) // the comments are those of the message
+		p.endLocAt(p.startLocAt(namePos, subpath(groupPath, descriptor.MessageNameTag)), nameEnd)
+		p.endLocAt(p.startLocAt(namePos, subpath(fieldPath, descriptor.FieldTypeNameTag)), nameEnd)
+		return fld, p.parseMessageBody(typName, groupPath, groupLoc)
+	}
+	p.expectSemi()
+	p.endDecl(loc)
+	return fld, nil
 }
 
-func (p *parser) parseMapField() (*descriptorpb.FieldDescriptorProto, *descriptorpb.DescriptorProto) {
+var mapKeyTypes = map[descriptorpb.FieldDescriptorProto_Type]bool{
+	descriptorpb.FieldDescriptorProto_TYPE_INT32:    true,
+	descriptorpb.FieldDescriptorProto_TYPE_INT64:    true,
+	descriptorpb.FieldDescriptorProto_TYPE_UINT32:   true,
+	descriptorpb.FieldDescriptorProto_TYPE_UINT64:   true,
+	descriptorpb.FieldDescriptorProto_TYPE_SINT32:   true,
+	descriptorpb.FieldDescriptorProto_TYPE_SINT64:   true,
+	descriptorpb.FieldDescriptorProto_TYPE_FIXED32:  true,
+	descriptorpb.FieldDescriptorProto_TYPE_FIXED64:  true,
+	descriptorpb.FieldDescriptorProto_TYPE_SFIXED32: true,
+	descriptorpb.FieldDescriptorProto_TYPE_SFIXED64: true,
+	descriptorpb.FieldDescriptorProto_TYPE_BOOL:     true,
+	descriptorpb.FieldDescriptorProto_TYPE_STRING:   true,
+}
+
+func (p *parser) parseMapField(path []int32) (*descriptorpb.FieldDescriptorProto, *descriptorpb.DescriptorProto) {
 	// mapField = "map" "<" keyType "," type ">" mapName "=" fieldNumber [ "[" fieldOptions "]" ] ";"
 	// keyType = "int32" | "int64" | "uint32" | "uint64" | "sint32" | "sint64" |
 	//           "fixed32" | "fixed64" | "sfixed32" | "sfixed64" | "bool" | "string"
+	loc := p.startDecl(path)
+	defer p.endLoc(loc)
+	typLoc := p.startLoc(subpath(path, descriptor.FieldTypeNameTag))
 	p.next()
 
 	var (
-		name, entryName, typName string
-		lbl                      = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
-		typ                      = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
-		entryFields              []*descriptorpb.FieldDescriptorProto
-		entryOpts                = &descriptorpb.MessageOptions{MapEntry: boolPtr(true)}
+		name, entryName string
+		lbl             = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+		typ             = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+		entryFields     []*descriptorpb.FieldDescriptorProto
+		entryOpts       = &descriptorpb.MessageOptions{MapEntry: boolPtr(true)}
 	)
 
 	p.expect(token.LANGLE)
+	keyPos := p.pos
 	ktyp, _ := p.parserFieldType()
-	if ktyp == 0 {
-		// TODO: deal with unexpected key type
+	if !mapKeyTypes[ktyp] {
+		p.error(keyPos, "invalid map key type: must be an integral, bool or string type")
 	}
 	p.expect(token.COMMA)
 	vtyp, vtypName := p.parserFieldType()
 	p.expect(token.RANGLE)
+	p.endLoc(typLoc)
 
 	entryLbl := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
 	var n, n2 int32 = 1, 2
-	entryFields = append(entryFields, &descriptorpb.FieldDescriptorProto{
+	key := &descriptorpb.FieldDescriptorProto{
 		Name:     strPtr("key"),
 		JsonName: strPtr("key"),
 		Label:    &entryLbl,
 		Number:   &n,
-		Type:     &ktyp,
-	}, &descriptorpb.FieldDescriptorProto{
+	}
+	if ktyp != 0 {
+		key.Type = &ktyp
+	}
+	val := &descriptorpb.FieldDescriptorProto{
 		Name:     strPtr("value"),
 		JsonName: strPtr("value"),
 		Label:    &entryLbl,
 		Number:   &n2,
-		Type:     &vtyp,
 		TypeName: strPtr(vtypName),
-	})
+	}
+	if vtyp != 0 {
+		val.Type = &vtyp
+	}
+	entryFields = append(entryFields, key, val)
+
+	pos := p.pos
+	nameLoc := p.startLoc(subpath(path, descriptor.FieldNameTag))
+	name = p.parseIdent("map field name")
+	p.endLoc(nameLoc)
+	p.checkLowerSnakeCase(pos, "field", name)
+	entryName = mapEntryName(name)
+
+	p.expect(token.ASSIGN)
+	numLoc := p.startLoc(subpath(path, descriptor.FieldNumberTag))
+	number := p.parseFieldNumber()
+	p.endLoc(numLoc)
+
+	fld := &descriptorpb.FieldDescriptorProto{
+		Name:     strPtr(name),
+		JsonName: strPtr(descriptor.JSONCamelCase(name)),
+		Label:    &lbl,
+		Number:   &number,
+		Type:     &typ,
+		TypeName: strPtr(entryName),
+	}
+	if p.tok == token.LBRACK {
+		p.parseFieldOptions(fld, path)
+	}
+	p.expectSemi()
+	p.endDecl(loc)
 
-	if p.tok != token.IDENT {
-		//TODO: deal with error
+	return fld, &descriptorpb.DescriptorProto{
+		Name:    strPtr(entryName),
+		Field:   entryFields,
+		Options: entryOpts,
 	}
-	name = p.lit
-	entryName = mapEntryName(p.lit)
+}
+
+// parseOneof parses the oneof at path, which is the index'th oneof of
+// the message at msgPath. Its fields and groups are added to the message
+// after the fieldBase fields and nestedBase nested messages that it
+// already has.
+func (p *parser) parseOneof(index int32, path, msgPath []int32, fieldBase, nestedBase int) (*descriptorpb.OneofDescriptorProto, []*descriptorpb.FieldDescriptorProto, []*descriptorpb.DescriptorProto) {
+	// oneof = "oneof" oneofName "{" { option | oneofField | emptyStatement } "}"
+	loc := p.startDecl(path)
+	defer p.endLoc(loc)
 	p.next()
 
-	//TODO: Get the full type name for the MapEntry message that we are adding
+	var (
+		name   string
+		fields []*descriptorpb.FieldDescriptorProto
+		groups []*descriptorpb.DescriptorProto
+		opt    *descriptorpb.OneofOptions
+	)
 
-	p.expect(token.ASSIGN)
+	pos := p.pos
+	nameLoc := p.startLoc(subpath(path, descriptor.OneofNameTag))
+	name = p.parseIdent("oneof name")
+	p.endLoc(nameLoc)
+	p.checkLowerSnakeCase(pos, "oneof", name)
+	p.expect(token.LBRACE)
+	p.endDecl(loc)
+	for p.tok != token.RBRACE && p.tok != token.EOF {
+		switch p.tok {
+		case token.OPTION:
+			if opt == nil {
+				opt = &descriptorpb.OneofOptions{}
+			}
+			p.parseOption(opt, subpath(path, descriptor.OneofOptionsTag))
+		case token.SEMICOLON:
+			p.next()
+		case token.OPTIONAL, token.REQUIRED, token.REPEATED:
+			// The label is reported and skipped, as the intent is clear.
+			p.error(p.pos, "fields in oneofs must not have labels")
+			p.next()
+		case token.MAP:
+			if p.scanner.Peek() == token.LANGLE {
+				p.badStmt("oneof field")
+				break
+			}
+			fallthrough // a type named map
+		default:
+			if !p.isIdent() && p.tok != token.DOT {
+				p.badStmt("oneof field")
+				break
+			}
+			fieldPath := subpath(msgPath, descriptor.MessageFieldTag, int32(fieldBase+len(fields)))
+			groupPath := subpath(msgPath, descriptor.MessageNestedTag, int32(nestedBase+len(groups)))
+			f, g := p.parseNormalField(p.startDecl(fieldPath), fieldPath, groupPath, true)
+			f.OneofIndex = int32Ptr(index)
+			fields = append(fields, f)
+			if g != nil {
+				groups = append(groups, g)
+			}
+		}
+	}
+	p.expect(token.RBRACE)
 
-	if p.tok != token.INT {
-		//TODO: deal with unexpected token
-	}
-	i, _ := strconv.Atoi(p.lit)
-	number := int32(i)
-
-	// TODO: deal with options
-	p._skipTo(token.SEMICOLON)
-
-	return &descriptorpb.FieldDescriptorProto{
-			Name:     strPtr(name),
-			JsonName: strPtr(jsonCamelCase(name)),
-			Label:    &lbl,
-			Number:   &number,
-			Type:     &typ,
-			TypeName: strPtr(typName),
-		},
-		&descriptorpb.DescriptorProto{
-			Name:    strPtr(entryName),
-			Field:   entryFields,
-			Options: entryOpts,
+	return &descriptorpb.OneofDescriptorProto{
+		Name:    strPtr(name),
+		Options: opt,
+	}, fields, groups
+}
+
+// parseExtend parses an extend block at path. Its fields are added to
+// the extensions of the enclosing file or message after the extBase
+// extensions it already has; the messages declared by its groups are
+// added at nestedPath after the nestedBase messages already there.
+func (p *parser) parseExtend(path []int32, extBase int, nestedPath []int32, nestedBase int) ([]*descriptorpb.FieldDescriptorProto, []*descriptorpb.DescriptorProto) {
+	// extend = "extend" messageType "{" { field | group | emptyStatement } "}"
+	loc := p.startDecl(path)
+	defer p.endLoc(loc)
+	p.next()
+
+	var (
+		fields []*descriptorpb.FieldDescriptorProto
+		groups []*descriptorpb.DescriptorProto
+	)
+
+	extendeePos := p.pos
+	extendee := p.parseTypeName()
+	extendeeEnd := p.prevEnd
+	p.expect(token.LBRACE)
+	p.endDecl(loc)
+	for p.tok != token.RBRACE && p.tok != token.EOF {
+		switch p.tok {
+		case token.SEMICOLON:
+			p.next()
+		case token.MAP:
+			if p.scanner.Peek() == token.LANGLE {
+				p.badStmt("extension field")
+				break
+			}
+			fallthrough // a type named map
+		default:
+			if !p.isIdent() && p.tok != token.DOT {
+				p.badStmt("extension field")
+				break
+			}
+			fieldPath := subpath(path, int32(extBase+len(fields)))
+			groupPath := subpath(nestedPath, int32(nestedBase+len(groups)))
+			fieldLoc := p.startDecl(fieldPath)
+			p.endLocAt(p.startLocAt(extendeePos, subpath(fieldPath, descriptor.FieldExtendeeTag)), extendeeEnd)
+			f, g := p.parseNormalField(fieldLoc, fieldPath, groupPath, false)
+			f.Extendee = strPtr(extendee)
+			fields = append(fields, f)
+			if g != nil {
+				groups = append(groups, g)
+			}
+		}
+	}
+	p.expect(token.RBRACE)
+	return fields, groups
+}
+
+// parseRange parses the range at path.
+func (p *parser) parseRange(enum bool, path []int32) (start, end int32, ok bool) {
+	// range = intLit [ "to" ( intLit | "max" ) ]
+	loc := p.startLoc(path)
+	defer p.endLoc(loc)
+
+	startPos := p.pos
+	startLoc := p.startLoc(subpath(path, descriptor.RangeStartTag))
+	start, ok = p.parseInt32("range start")
+	p.endLoc(startLoc)
+	end = start
+	if p.tok != token.TO {
+		// the end of a single number range is the number itself
+		p.endLocAt(p.startLocAt(startPos, subpath(path, descriptor.RangeEndTag)), p.prevEnd)
+		return
+	}
+	p.next()
+	endLoc := p.startLoc(subpath(path, descriptor.RangeEndTag))
+	defer p.endLoc(endLoc)
+	switch {
+	case p.tok == token.MAX && enum:
+		end = math.MaxInt32
+		p.next()
+	case p.tok == token.MAX:
+		end = maxRangeSentinel
+		p.next()
+	default:
+		var endOk bool
+		end, endOk = p.parseInt32("range end")
+		ok = ok && endOk
+	}
+	return
+}
+
+// parseExtensions parses an extensions statement at path. Its ranges
+// are added after the base ranges that the message already has.
+func (p *parser) parseExtensions(path []int32, base int) []*descriptorpb.DescriptorProto_ExtensionRange {
+	// extensions = "extensions" ranges [ "[" options "]" ] ";"
+	// ranges = range { "," range }
+	loc := p.startDecl(path)
+	defer p.endLoc(loc)
+	p.next()
+
+	var rngs []*descriptorpb.DescriptorProto_ExtensionRange
+	for {
+		start, end, ok := p.parseRange(false, subpath(path, int32(base+len(rngs))))
+		if !ok {
+			break
+		}
+		if end != maxRangeSentinel {
+			end++ // exclusive
+		}
+		rngs = append(rngs, &descriptorpb.DescriptorProto_ExtensionRange{
+			Start: int32Ptr(start),
+			End:   int32Ptr(end),
+		})
+		if p.tok != token.COMMA {
+			break
+		}
+		p.next()
+	}
+	if p.tok == token.LBRACK {
+		// The options apply to every range of the statement, and so do
+		// their locations.
+		opt := &descriptorpb.ExtensionRangeOptions{}
+		n := len(p.locs)
+		p.parseCompactOptions(opt, subpath(path, int32(base), descriptor.RangeOptionsTag))
+		locs := p.locs[n:]
+		for i, r := range rngs {
+			if i == 0 {
+				r.Options = opt
+				continue
+			}
+			r.Options = proto.Clone(opt).(*descriptorpb.ExtensionRangeOptions)
+			for _, l := range locs {
+				loc := proto.Clone(l.loc).(*descriptorpb.SourceCodeInfo_Location)
+				loc.Path[len(path)] = int32(base + i)
+				p.locs = append(p.locs, location{loc: loc, start: l.start})
+			}
 		}
+	}
+	p.expectSemi()
+	p.endDecl(loc)
+	return rngs
 }
 
-func (p *parser) parseMessage() *descriptorpb.DescriptorProto {
+// parseReserved parses a reserved statement of the message or enum at
+// path. Its ranges and names are added after the rangeBase ranges and
+// nameBase names already reserved.
+func (p *parser) parseReserved(enum bool, path []int32, rangeBase, nameBase int) (rngs [][2]int32, names []string) {
+	// reserved = "reserved" ( ranges | fieldNames ) ";"
+	// fieldNames = fieldName { "," fieldName }
+	rangeTag, nameTag := int32(descriptor.MessageReservedRangeTag), int32(descriptor.MessageReservedNameTag)
+	if enum {
+		rangeTag, nameTag = descriptor.EnumReservedRangeTag, descriptor.EnumReservedNameTag
+	}
+	loc := p.startDecl(nil)
+	defer p.endLoc(loc)
+	p.next()
+
+	if p.tok == token.STRING {
+		path := subpath(path, nameTag)
+		loc.setPath(path)
+		for {
+			nameLoc := p.startLoc(subpath(path, int32(nameBase+len(names))))
+			names = append(names, p.parseStrLit())
+			p.endLoc(nameLoc)
+			if p.tok != token.COMMA {
+				break
+			}
+			p.next()
+		}
+		p.expectSemi()
+		p.endDecl(loc)
+		return
+	}
+
+	path = subpath(path, rangeTag)
+	loc.setPath(path)
+	for {
+		start, end, ok := p.parseRange(enum, subpath(path, int32(rangeBase+len(rngs))))
+		if !ok {
+			break
+		}
+		rngs = append(rngs, [2]int32{start, end})
+		if p.tok != token.COMMA {
+			break
+		}
+		p.next()
+	}
+	p.expectSemi()
+	p.endDecl(loc)
+	return
+}
+
+// parseMessage parses the message at path.
+func (p *parser) parseMessage(path []int32) *descriptorpb.DescriptorProto {
 	// message = "message" messageName messageBody
-	// messageBody = "{" { field | enum | message | option | oneof | mapField |
-	// reserved | emptyStatement } "}"
+	loc := p.startDecl(path)
+	defer p.endLoc(loc)
 	p.next()
+	pos := p.pos
+	nameLoc := p.startLoc(subpath(path, descriptor.MessageNameTag))
+	name := p.parseIdent("message name")
+	p.endLoc(nameLoc)
+	p.checkCamelCase(pos, "message", name)
+	return p.parseMessageBody(name, path, loc)
+}
 
+// parseMessageBody parses the body of the message at path. The location
+// of the message itself, loc, is recorded by the caller; it is given the
+// trailing comment of the opening brace.
+func (p *parser) parseMessageBody(name string, path []int32, loc location) *descriptorpb.DescriptorProto {
+	// messageBody = "{" { field | enum | message | extend | extensions | group |
+	// option | oneof | mapField | reserved | emptyStatement } "}"
 	var (
-		name    string
 		fields  []*descriptorpb.FieldDescriptorProto
 		exts    []*descriptorpb.FieldDescriptorProto
 		nested  []*descriptorpb.DescriptorProto
@@ -264,31 +932,97 @@ func (p *parser) parseMessage() *descriptorpb.DescriptorProto {
 		resName []string
 	)
 
-	if p.tok != token.IDENT {
-		// TODO deal with error
+	if p.nestLev >= maxNestLev {
+		p.error(p.pos, "exceeded maximum message nesting depth")
+		p.skipStmt()
+		return &descriptorpb.DescriptorProto{Name: strPtr(name)}
 	}
-	name = p.lit
+	p.nestLev++
+	defer func() { p.nestLev-- }()
+
 	p.expect(token.LBRACE)
+	p.endDecl(loc)
 	for p.tok != token.RBRACE && p.tok != token.EOF {
 		switch p.tok {
 		case token.OPTION:
-			//TODO parse options
-			p._skipTo(token.SEMICOLON)
+			if opt == nil {
+				opt = &descriptorpb.MessageOptions{}
+			}
+			p.parseOption(opt, subpath(path, descriptor.MessageOptionsTag))
 		case token.MESSAGE:
-			nested = append(nested, p.parseMessage())
-		case token.REPEATED, token.IDENT:
-			fields = append(fields, p.parseNormalField())
+			nested = append(nested, p.parseMessage(subpath(path, descriptor.MessageNestedTag, int32(len(nested)))))
+		case token.ENUM:
+			enums = append(enums, p.parseEnum(subpath(path, descriptor.MessageEnumTag, int32(len(enums)))))
+		case token.EXTEND:
+			fs, gs := p.parseExtend(subpath(path, descriptor.MessageExtensionTag), len(exts), subpath(path, descriptor.MessageNestedTag), len(nested))
+			exts = append(exts, fs...)
+			nested = append(nested, gs...)
+		case token.EXTENSIONS:
+			extRng = append(extRng, p.parseExtensions(subpath(path, descriptor.MessageExtensionRangeTag), len(extRng))...)
+		case token.RESERVED:
+			rs, ns := p.parseReserved(false, path, len(resRng), len(resName))
+			for _, r := range rs {
+				end := r[1]
+				if end != maxRangeSentinel {
+					end++ // exclusive
+				}
+				resRng = append(resRng, &descriptorpb.DescriptorProto_ReservedRange{
+					Start: int32Ptr(r[0]),
+					End:   int32Ptr(end),
+				})
+			}
+			resName = append(resName, ns...)
+		case token.ONEOF:
+			index := int32(len(oneofs))
+			o, fs, gs := p.parseOneof(index, subpath(path, descriptor.MessageOneofTag, index), path, len(fields), len(nested))
+			oneofs = append(oneofs, o)
+			fields = append(fields, fs...)
+			nested = append(nested, gs...)
+		case token.SEMICOLON:
+			p.next()
 		case token.MAP:
-			mf, mn := p.parseMapField()
-			fields = append(fields, mf)
-			nested = append(nested, mn)
+			if p.scanner.Peek() == token.LANGLE {
+				mf, mn := p.parseMapField(subpath(path, descriptor.MessageFieldTag, int32(len(fields))))
+				fields = append(fields, mf)
+				nested = append(nested, mn)
+				break
+			}
+			fallthrough // a type named map
 		default:
-			// TODO: deal with unexpected token
-			p.next()
+			// Any other keyword is the type of a field, as in "service s = 1;".
+			if !p.isIdent() && p.tok != token.DOT {
+				p.badStmt("field or declaration")
+				break
+			}
+			fieldPath := subpath(path, descriptor.MessageFieldTag, int32(len(fields)))
+			groupPath := subpath(path, descriptor.MessageNestedTag, int32(len(nested)))
+			f, g := p.parseNormalField(p.startDecl(fieldPath), fieldPath, groupPath, false)
+			fields = append(fields, f)
+			if g != nil {
+				nested = append(nested, g)
+			}
 		}
 	}
 	p.expect(token.RBRACE)
 
+	// The value of "max" depends on the message_set_wire_format option,
+	// which may be set after the ranges that use it.
+	max := int32(536870912) // one past the largest field number
+	if opt.GetMessageSetWireFormat() {
+		max = math.MaxInt32
+	}
+	for _, r := range extRng {
+		if r.GetEnd() == maxRangeSentinel {
+			r.End = int32Ptr(max)
+		}
+	}
+	for _, r := range resRng {
+		if r.GetEnd() == maxRangeSentinel {
+			r.End = int32Ptr(max)
+		}
+	}
+	oneofs = addSyntheticOneofs(fields, oneofs)
+
 	return &descriptorpb.DescriptorProto{
 		Name:           strPtr(name),
 		Field:          fields,
@@ -303,135 +1037,252 @@ func (p *parser) parseMessage() *descriptorpb.DescriptorProto {
 	}
 }
 
-func (p *parser) parseEnumValue() *descriptorpb.EnumValueDescriptorProto {
-	name := p.lit
-	p.next()
-	p.expect(token.ASSIGN)
-	if p.tok != token.INT {
-		// TODO: deal with error
+// addSyntheticOneofs adds a oneof for each proto3 optional field of
+// fields, after the oneofs declared, as protoc does. Each is named after
+// its field, prefixed with an underscore, and with as many Xs as are
+// needed to make its name unique.
+func addSyntheticOneofs(fields []*descriptorpb.FieldDescriptorProto, oneofs []*descriptorpb.OneofDescriptorProto) []*descriptorpb.OneofDescriptorProto {
+	names := make(map[string]bool)
+	for _, f := range fields {
+		names[f.GetName()] = true
 	}
-	i, _ := strconv.Atoi(p.lit)
-	number := int32(i)
+	for _, o := range oneofs {
+		names[o.GetName()] = true
+	}
+	for _, f := range fields {
+		if !f.GetProto3Optional() {
+			continue
+		}
+		name := f.GetName()
+		if !strings.HasPrefix(name, "_") {
+			name = "_" + name
+		}
+		for names[name] {
+			name = "X" + name
+		}
+		names[name] = true
+		f.OneofIndex = int32Ptr(int32(len(oneofs)))
+		oneofs = append(oneofs, &descriptorpb.OneofDescriptorProto{Name: strPtr(name)})
+	}
+	return oneofs
+}
 
-	//TODO: parse options
-	p._skipTo(token.SEMICOLON)
+func (p *parser) parseEnumValue(path []int32) *descriptorpb.EnumValueDescriptorProto {
+	// enumField = ident "=" [ "-" ] intLit [ "[" enumValueOption { ","  enumValueOption } "]" ]";"
+	loc := p.startDecl(path)
+	defer p.endLoc(loc)
+	pos := p.pos
+	nameLoc := p.startLoc(subpath(path, descriptor.EnumValueNameTag))
+	name := p.parseIdent("enum value name")
+	p.endLoc(nameLoc)
+	p.checkUpperSnakeCase(pos, "enum value", name)
+	p.expect(token.ASSIGN)
+	numLoc := p.startLoc(subpath(path, descriptor.EnumValueNumberTag))
+	number, _ := p.parseInt32("enum value number")
+	p.endLoc(numLoc)
+
+	var opt *descriptorpb.EnumValueOptions
+	if p.tok == token.LBRACK {
+		opt = &descriptorpb.EnumValueOptions{}
+		p.parseCompactOptions(opt, subpath(path, descriptor.EnumValueOptionsTag))
+	}
+	p.expectSemi()
+	p.endDecl(loc)
 
 	return &descriptorpb.EnumValueDescriptorProto{
-		Name:   strPtr(name),
-		Number: &number,
+		Name:    strPtr(name),
+		Number:  &number,
+		Options: opt,
 	}
 }
 
-func (p *parser) parseEnum() *descriptorpb.EnumDescriptorProto {
+func (p *parser) parseEnum(path []int32) *descriptorpb.EnumDescriptorProto {
 	// enum = "enum" enumName enumBody
-	// enumBody = "{" { option | enumField | emptyStatement } "}"
-	// enumField = ident "=" intLit [ "[" enumValueOption { ","  enumValueOption } "]" ]";"
+	// enumBody = "{" { option | enumField | reserved | emptyStatement } "}"
 	// enumValueOption = optionName "=" constant
+	loc := p.startDecl(path)
+	defer p.endLoc(loc)
 	p.next()
 
 	var (
-		name string
-		vals []*descriptorpb.EnumValueDescriptorProto
-		opts *descriptorpb.EnumOptions
+		name    string
+		vals    []*descriptorpb.EnumValueDescriptorProto
+		opts    *descriptorpb.EnumOptions
+		resRng  []*descriptorpb.EnumDescriptorProto_EnumReservedRange
+		resName []string
 	)
 
-	if p.tok != token.IDENT {
-		//TODO deal with unexpected token
-	}
-	name = p.lit
-	p.next()
+	pos := p.pos
+	nameLoc := p.startLoc(subpath(path, descriptor.EnumNameTag))
+	name = p.parseIdent("enum name")
+	p.endLoc(nameLoc)
+	p.checkCamelCase(pos, "enum", name)
 	p.expect(token.LBRACE)
+	p.endDecl(loc)
 
 	for p.tok != token.RBRACE && p.tok != token.EOF {
 		switch p.tok {
 		case token.OPTION:
-			// TODO: parse options
-			p._skipTo(token.SEMICOLON)
+			if opts == nil {
+				opts = &descriptorpb.EnumOptions{}
+			}
+			p.parseOption(opts, subpath(path, descriptor.EnumOptionsTag))
+		case token.RESERVED:
+			rs, ns := p.parseReserved(true, path, len(resRng), len(resName))
+			for _, r := range rs {
+				resRng = append(resRng, &descriptorpb.EnumDescriptorProto_EnumReservedRange{
+					Start: int32Ptr(r[0]),
+					End:   int32Ptr(r[1]),
+				})
+			}
+			resName = append(resName, ns...)
 		case token.SEMICOLON:
 			p.next()
-		case token.IDENT:
-			//TODO: parse vals
-			vals = append(vals, p.parseEnumValue())
 		default:
-			// TODO: deal with unexpected token
-			p.next()
+			if !p.isIdent() {
+				p.badStmt("enum value")
+				break
+			}
+			vals = append(vals, p.parseEnumValue(subpath(path, descriptor.EnumValueTag, int32(len(vals)))))
 		}
 	}
-
-	p._skipTo(token.RBRACE)
+	p.expect(token.RBRACE)
 
 	return &descriptorpb.EnumDescriptorProto{
-		Name:    strPtr(name),
-		Value:   vals,
-		Options: opts,
+		Name:          strPtr(name),
+		Value:         vals,
+		Options:       opts,
+		ReservedRange: resRng,
+		ReservedName:  resName,
 	}
 }
 
-func (p *parser) parseFileOption(opt *descriptorpb.FileOptions) {
+// parseMethodType parses the input or output type of the method at path;
+// typeTag and streamTag identify which.
+func (p *parser) parseMethodType(path []int32, typeTag, streamTag int32) (typ string, stream bool) {
+	// "(" [ "stream" ] messageType ")"
+	p.expect(token.LPAREN)
+	if p.tok == token.STREAM {
+		stream = true
+		p.tokenLoc(subpath(path, streamTag))
+		p.next()
+	}
+	loc := p.startLoc(subpath(path, typeTag))
+	typ = p.parseTypeName()
+	p.endLoc(loc)
+	p.expect(token.RPAREN)
+	return
+}
+
+func (p *parser) parseMethod(path []int32) *descriptorpb.MethodDescriptorProto {
+	// rpc = "rpc" rpcName "(" [ "stream" ] messageType ")" "returns" "(" [ "stream" ]
+	// messageType ")" (( "{" { option | emptyStatement } "}" ) | ";")
+	loc := p.startDecl(path)
+	defer p.endLoc(loc)
 	p.next()
-	if p.tok != token.IDENT {
-		//TODO: deal with errors
+
+	var opt *descriptorpb.MethodOptions
+
+	pos := p.pos
+	nameLoc := p.startLoc(subpath(path, descriptor.MethodNameTag))
+	name := p.parseIdent("method name")
+	p.endLoc(nameLoc)
+	p.checkCamelCase(pos, "method", name)
+	in, inStream := p.parseMethodType(path, descriptor.MethodInputTag, descriptor.MethodClientStreamingTag)
+	p.expect(token.RETURNS)
+	out, outStream := p.parseMethodType(path, descriptor.MethodOutputTag, descriptor.MethodServerStreamingTag)
+
+	if p.tok == token.LBRACE {
+		p.next()
+		p.endDecl(loc)
+		for p.tok != token.RBRACE && p.tok != token.EOF {
+			switch p.tok {
+			case token.OPTION:
+				if opt == nil {
+					opt = &descriptorpb.MethodOptions{}
+				}
+				p.parseOption(opt, subpath(path, descriptor.MethodOptionsTag))
+			case token.SEMICOLON:
+				p.next()
+			default:
+				p.badStmt("method option")
+			}
+		}
+		p.expect(token.RBRACE)
+	} else {
+		p.expectSemi()
+		p.endDecl(loc)
 	}
-	ident := p.lit
-	// TODO: deal with fullIdent
+
+	m := &descriptorpb.MethodDescriptorProto{
+		Name:       strPtr(name),
+		InputType:  strPtr(in),
+		OutputType: strPtr(out),
+		Options:    opt,
+	}
+	if inStream {
+		m.ClientStreaming = boolPtr(true)
+	}
+	if outStream {
+		m.ServerStreaming = boolPtr(true)
+	}
+	return m
+}
+
+func (p *parser) parseService(path []int32) *descriptorpb.ServiceDescriptorProto {
+	// service = "service" serviceName "{" { option | rpc | emptyStatement } "}"
+	loc := p.startDecl(path)
+	defer p.endLoc(loc)
 	p.next()
 
-	p.expect(token.ASSIGN)
+	var (
+		name    string
+		methods []*descriptorpb.MethodDescriptorProto
+		opt     *descriptorpb.ServiceOptions
+	)
 
-	switch token.LookupFileOption(ident) {
-	case token.JAVA_PACKAGE:
-		opt.JavaPackage = strPtr(p.parseStrLit())
-	case token.JAVA_OUTER_CLASSNAME:
-		opt.JavaOuterClassname = strPtr(p.parseStrLit())
-	case token.JAVA_MULTIPLE_FILES:
-		opt.JavaMultipleFiles = boolPtr(p.parseBoolLit())
-	case token.JAVA_STRING_CHECK_UTF8:
-		opt.JavaStringCheckUtf8 = boolPtr(p.parseBoolLit())
-	case token.OPTIMIZE_FOR:
-		// TODO: parse enum values
-	case token.GO_PACKAGE:
-		opt.GoPackage = strPtr(p.parseStrLit())
-	case token.CC_GENERIC_SERVICES:
-		opt.CcGenericServices = boolPtr(p.parseBoolLit())
-	case token.JAVA_GENERIC_SERVICES:
-		opt.JavaGenericServices = boolPtr(p.parseBoolLit())
-	case token.PY_GENERIC_SERVICES:
-		opt.PyGenericServices = boolPtr(p.parseBoolLit())
-	case token.PHP_GENERIC_SERVICES:
-		opt.PhpGenericServices = boolPtr(p.parseBoolLit())
-	case token.DEPRECATED:
-		opt.Deprecated = boolPtr(p.parseBoolLit())
-	case token.CC_ENABLE_ARENAS:
-		opt.CcEnableArenas = boolPtr(p.parseBoolLit())
-	case token.OBJC_CLASS_PREFIX:
-		opt.ObjcClassPrefix = strPtr(p.parseStrLit())
-	case token.CSHARP_NAMESPACE:
-		opt.CsharpNamespace = strPtr(p.parseStrLit())
-	case token.SWIFT_PREFIX:
-		opt.SwiftPrefix = strPtr(p.parseStrLit())
-	case token.PHP_CLASS_PREFIX:
-		opt.PhpClassPrefix = strPtr(p.parseStrLit())
-	case token.PHP_NAMESPACE:
-		opt.PhpClassPrefix = strPtr(p.parseStrLit())
-	case token.PHP_METADATA_NAMESPACE:
-		opt.PhpMetadataNamespace = strPtr(p.parseStrLit())
-	case token.RUBY_PACKAGE:
-		opt.RubyPackage = strPtr(p.parseStrLit())
-	default:
-		//TODO: deal with custom option
+	pos := p.pos
+	nameLoc := p.startLoc(subpath(path, descriptor.ServiceNameTag))
+	name = p.parseIdent("service name")
+	p.endLoc(nameLoc)
+	p.checkCamelCase(pos, "service", name)
+	p.expect(token.LBRACE)
+	p.endDecl(loc)
+	for p.tok != token.RBRACE && p.tok != token.EOF {
+		switch p.tok {
+		case token.OPTION:
+			if opt == nil {
+				opt = &descriptorpb.ServiceOptions{}
+			}
+			p.parseOption(opt, subpath(path, descriptor.ServiceOptionsTag))
+		case token.RPC:
+			methods = append(methods, p.parseMethod(subpath(path, descriptor.ServiceMethodTag, int32(len(methods)))))
+		case token.SEMICOLON:
+			p.next()
+		default:
+			p.badStmt("rpc or option")
+		}
 	}
+	p.expect(token.RBRACE)
 
-	p._skipTo(token.SEMICOLON)
+	return &descriptorpb.ServiceDescriptorProto{
+		Name:    strPtr(name),
+		Method:  methods,
+		Options: opt,
+	}
 }
 
 func (p *parser) parseFile() *descriptorpb.FileDescriptorProto {
+	loc := p.startLoc([]int32{})
 
 	// syntax must be the first non-empty, non-comment line of the file.
 	// defaults to proto2 if not defined.
-	syntax := "proto2"
+	p.syntax = "proto2"
 	if p.tok == token.SYNTAX {
-		syntax = p.parseSyntax()
-		// TODO: validatate proto2 or proto3
+		p.syntax = p.parseSyntax()
+	} else {
+		p.report(p.pos, 0, scanner.SeverityWarning, scanner.CodeMissingSyntax,
+			"no syntax specified; defaulting to proto2 syntax")
 	}
 
 	var (
@@ -444,16 +1295,27 @@ func (p *parser) parseFile() *descriptorpb.FileDescriptorProto {
 		srcs      []*descriptorpb.ServiceDescriptorProto
 		exts      []*descriptorpb.FieldDescriptorProto
 		opt       *descriptorpb.FileOptions
+		pkgPos    token.Pos
 	)
 
+	if p.file.Name() != "" {
+		// the name by which the file is imported from the current directory
+		name = (&Config{}).ImportName(p.file.Name())
+	}
+
 	for p.tok != token.EOF {
 		switch p.tok {
 		case token.PACKAGE:
-			// [RC] if pacakge is declared twice is this a syntax err
-			// or do we expect the first or last pacakge declaration?
-			pkg = p.parsePackage()
+			pos := p.pos
+			s := p.parsePackage()
+			if pkg != "" {
+				p.error(pos, "multiple package declarations",
+					p.note(pkgPos, len("package"), "previously defined here"))
+				break
+			}
+			pkg, pkgPos = s, pos
 		case token.IMPORT:
-			d, p, w := p.parseDependency()
+			d, p, w := p.parseDependency(len(deps), len(pDeps), len(wDeps))
 			deps = append(deps, d)
 			if p {
 				pDeps = append(pDeps, int32(len(deps)-1))
@@ -465,19 +1327,26 @@ func (p *parser) parseFile() *descriptorpb.FileDescriptorProto {
 			if opt == nil {
 				opt = &descriptorpb.FileOptions{}
 			}
-			p.parseFileOption(opt)
+			p.parseOption(opt, []int32{descriptor.FileOptionsTag})
 		case token.MESSAGE:
-			msgs = append(msgs, p.parseMessage())
+			msgs = append(msgs, p.parseMessage([]int32{descriptor.FileMessageTag, int32(len(msgs))}))
 		case token.ENUM:
-			enums = append(enums, p.parseEnum())
-		default:
-			// TODO: deal with unexpected token error
+			enums = append(enums, p.parseEnum([]int32{descriptor.FileEnumTag, int32(len(enums))}))
+		case token.SERVICE:
+			srcs = append(srcs, p.parseService([]int32{descriptor.FileServiceTag, int32(len(srcs))}))
+		case token.EXTEND:
+			fs, gs := p.parseExtend([]int32{descriptor.FileExtensionTag}, len(exts), []int32{descriptor.FileMessageTag}, len(msgs))
+			exts = append(exts, fs...)
+			msgs = append(msgs, gs...)
+		case token.SEMICOLON:
 			p.next()
+		default:
+			p.badStmt("top-level declaration")
 		}
-
 	}
+	p.endLoc(loc)
 
-	return &descriptorpb.FileDescriptorProto{
+	fd := &descriptorpb.FileDescriptorProto{
 		Name:             strPtr(name),
 		Package:          strPtr(pkg),
 		Dependency:       deps,
@@ -488,8 +1357,12 @@ func (p *parser) parseFile() *descriptorpb.FileDescriptorProto {
 		Service:          srcs,
 		Extension:        exts,
 		Options:          opt,
-		Syntax:           strPtr(syntax),
+		Syntax:           strPtr(p.syntax),
+	}
+	if p.mode&IncludeSourceInfo != 0 {
+		fd.SourceCodeInfo = p.sourceCodeInfo()
 	}
+	return fd
 }
 
 func strPtr(s string) *string {
@@ -502,3 +1375,7 @@ func strPtr(s string) *string {
 func boolPtr(b bool) *bool {
 	return &b
 }
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
@@ -0,0 +1,146 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+
+	"rogchap.com/protoparser/internal/token"
+)
+
+// When the ProtocErrors mode is set, diagnostics are worded as protoc
+// words them, and columns are counted as protoc counts them, so that
+// tools written against protoc's output keep working.
+
+// protocTabWidth is the tab stop protoc uses when counting columns.
+const protocTabWidth = 8
+
+// protocExpected maps the description of an expected token or
+// production, as passed to errorExpected, to protoc's message for it.
+var protocExpected = map[string]string{
+	"top-level declaration":                 `Expected top-level statement (e.g. "message").`,
+	"field or declaration":                  "Expected type name.",
+	"'required', 'optional', or 'repeated'": `Expected "required", "optional", or "repeated".`,
+	"type name":                             "Expected type name.",
+	"field number":                          "Expected field number.",
+	"field name":                            "Expected field name.",
+	"map field name":                        "Expected field name.",
+	"group name":                            "Expected group name.",
+	"message name":                          "Expected message name.",
+	"enum name":                             "Expected enum name.",
+	"enum value":                            "Expected enum constant name.",
+	"enum value name":                       "Expected enum constant name.",
+	"enum value number":                     "Expected integer.",
+	"oneof name":                            "Expected oneof name.",
+	"oneof field":                           "Expected type name.",
+	"extension field":                       "Expected type name.",
+	"service name":                          "Expected service name.",
+	"method name":                           "Expected method name.",
+	"rpc or option":                         `Expected "rpc" or "option".`,
+	"method option":                         `Expected "option".`,
+	"package name":                          "Expected identifier.",
+	"identifier":                            "Expected identifier.",
+	"option name":                           "Expected identifier.",
+	"option value":                          "Expected option value.",
+	"string literal":                        "Expected string.",
+	"integer":                               "Expected integer.",
+	"range start":                           "Expected integer.",
+	"range end":                             "Expected integer.",
+	"number":                                "Expected number.",
+	"'true' or 'false'":                     `Expected "true" or "false".`,
+}
+
+// protocMessages maps the prefix of a diagnostic message to protoc's
+// wording of the same problem.
+var protocMessages = []struct {
+	prefix, msg string
+}{
+	{"string literal not terminated", "String literals cannot cross line boundaries."},
+	{"unknown escape sequence", "Invalid escape sequence in string literal."},
+	{"illegal character in escape sequence", "Invalid escape sequence in string literal."},
+	{"invalid escape sequence", "Invalid escape sequence in string literal."},
+	{"comment not terminated", "End-of-file inside block comment."},
+	{"illegal hexadecimal number", `"0x" must be followed by hex digits.`},
+	{"illegal octal number", "Numbers starting with leading zero must be in octal."},
+	{"exponent has no digits", `"e" must be followed by exponent.`},
+	{"need space between number and identifier", "Need space between number and identifier."},
+	{"illegal character", "Invalid control characters encountered in text."},
+	{"integer out of range", "Integer out of range."},
+	{"multiple package declarations", "Multiple package definitions."},
+	{"fields in oneofs must not have labels", "Fields in oneofs must not have labels (required / optional / repeated)."},
+	{"invalid map key type", "Key in map fields cannot be float/double, bytes or message types."},
+	{"exceeded maximum message nesting depth", "Reached maximum recursion limit for nested messages."},
+	{`option "default" was already set`, `Already set option "default".`},
+	{`option "json_name" was already set`, `Already set option "json_name".`},
+	{"no syntax specified", `No syntax specified for the proto file. Please use 'syntax = "proto2";' or 'syntax = "proto3";' to specify a syntax version. (Defaulted to proto2 syntax.)`},
+}
+
+// protocMessage returns protoc's wording of msg. Messages that protoc
+// words the same way, other than its capitalisation and final period,
+// are only adjusted for those.
+func protocMessage(msg string) string {
+	if strings.HasPrefix(msg, "unrecognized syntax ") {
+		// unrecognized syntax "x", expected "proto2" or "proto3"
+		s := strings.TrimPrefix(msg, "unrecognized syntax ")
+		if i := strings.Index(s, ", expected"); i >= 0 {
+			s = s[:i]
+		}
+		return "Unrecognized syntax identifier " + s + `.  This parser only recognizes "proto2" and "proto3".`
+	}
+	if strings.HasPrefix(msg, "import cycle: ") {
+		return "File recursively imports itself: " + strings.TrimPrefix(msg, "import cycle: ") + "."
+	}
+	if strings.HasPrefix(msg, "import ") {
+		// import "x.proto" was not found, or had errors
+		for _, suffix := range []string{" was not found", " had errors"} {
+			if strings.HasSuffix(msg, suffix) {
+				return "Import " + strings.TrimSuffix(strings.TrimPrefix(msg, "import "), suffix) + " was not found or had errors."
+			}
+		}
+		// import "x.proto" is not used
+		if name := strings.TrimSuffix(strings.TrimPrefix(msg, "import "), " is not used"); name != msg[len("import "):] {
+			if s, err := strconv.Unquote(name); err == nil {
+				name = s
+			}
+			return "Import " + name + " is unused."
+		}
+	}
+	if strings.Contains(msg, ", which is not imported by ") {
+		return msg + ".  To use it here, please add the necessary import."
+	}
+	for _, m := range protocMessages {
+		if strings.HasPrefix(msg, m.prefix) {
+			return m.msg
+		}
+	}
+	if msg == "" || strings.HasSuffix(msg, ".") {
+		return msg
+	}
+	return strings.ToUpper(msg[:1]) + msg[1:] + "."
+}
+
+// protocExpectedMessage returns protoc's message for a missing token or
+// production described by what.
+func protocExpectedMessage(what string) string {
+	if msg, ok := protocExpected[what]; ok {
+		return msg
+	}
+	if strings.HasPrefix(what, "'") && strings.HasSuffix(what, "'") && len(what) > 1 {
+		// a single token, such as ';'
+		return `Expected "` + what[1:len(what)-1] + `".`
+	}
+	return "Expected " + what + "."
+}
+
+// protocColumn returns the 1-based column of pos as protoc counts it:
+// in bytes, with tabs advancing to the next multiple of eight.
+func protocColumn(src []byte, pos token.Position) int {
+	col := 0
+	for i := pos.Offset - (pos.Column - 1); i < pos.Offset && i < len(src); i++ {
+		if src[i] == '\t' {
+			col += protocTabWidth - col%protocTabWidth
+		} else {
+			col++
+		}
+	}
+	return col + 1
+}
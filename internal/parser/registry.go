@@ -0,0 +1,152 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"rogchap.com/protoparser/internal/descriptor"
+	"rogchap.com/protoparser/internal/scanner"
+	"rogchap.com/protoparser/internal/token"
+)
+
+// ParseToRegistry parses the files filenames and the files they import,
+// as ParseFiles does, and builds them into file descriptors. It returns a
+// registry of every file, and the descriptors of the files filenames.
+//
+// If errors were found while parsing, no descriptors are built, and the
+// results are nil. Problems found while building the descriptors are
+// reported at the declarations they concern.
+func (c *Config) ParseToRegistry(filenames ...string) (*protoregistry.Files, []protoreflect.FileDescriptor, scanner.ErrorList, error) {
+	l, err := c.loadFiles(filenames)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := l.errors.Errors().Err(); err != nil {
+		return nil, nil, l.errors, err
+	}
+
+	files := new(protoregistry.Files)
+	for _, f := range l.order {
+		fd, err := protodesc.NewFile(f.fd, files)
+		if err == nil {
+			err = files.RegisterFile(fd)
+		}
+		if err != nil {
+			l.buildError(f, err)
+		}
+	}
+	l.errors.RemoveDuplicates()
+	if err := l.errors.Errors().Err(); err != nil {
+		return nil, nil, l.errors, err
+	}
+
+	fds := make([]protoreflect.FileDescriptor, len(filenames))
+	for i, filename := range filenames {
+		fds[i], _ = files.FindFileByPath(c.ImportName(filename))
+	}
+	return files, fds, l.errors, nil
+}
+
+// buildError reports err, the failure to build f into a file descriptor.
+// It is reported at the first declaration it names, or at the start of
+// the file if it names none.
+func (l *loader) buildError(f *loadedFile, err error) {
+	msg := strings.TrimPrefix(err.Error(), "proto: ")
+	if f.p == nil {
+		l.errors = append(l.errors, &scanner.Error{
+			Pos:  token.Position{Filename: f.fd.GetName()},
+			Msg:  msg,
+			Code: scanner.CodeInvalid,
+		})
+		return
+	}
+
+	p := f.p
+	pos := p.file.Pos(0)
+	paths := declPaths(f.fd)
+	for _, name := range quoted(msg) {
+		if path, ok := paths[strings.TrimPrefix(name, ".")]; ok {
+			pos = p.pathPos(path...)
+			break
+		}
+	}
+	n := len(p.errors)
+	p.report(pos, 0, scanner.SeverityError, scanner.CodeInvalid, msg)
+	l.errors = append(l.errors, p.errors[n:]...)
+}
+
+// quoted returns the quoted strings in s.
+func quoted(s string) []string {
+	var strs []string
+	for {
+		i := strings.IndexByte(s, '"')
+		if i < 0 {
+			return strs
+		}
+		q, err := strconv.QuotedPrefix(s[i:])
+		if err != nil {
+			return strs
+		}
+		if u, err := strconv.Unquote(q); err == nil {
+			strs = append(strs, u)
+		}
+		s = s[i+len(q):]
+	}
+}
+
+// declPaths returns the paths of the declarations in fd, by their
+// fully-qualified names.
+func declPaths(fd *descriptorpb.FileDescriptorProto) map[string][]int32 {
+	paths := make(map[string][]int32)
+	scope := fd.GetPackage()
+	for i, m := range fd.MessageType {
+		addMessagePaths(paths, scope, m, []int32{descriptor.FileMessageTag, int32(i)})
+	}
+	for i, e := range fd.EnumType {
+		addEnumPaths(paths, scope, e, []int32{descriptor.FileEnumTag, int32(i)})
+	}
+	for i, f := range fd.Extension {
+		paths[join(scope, f.GetName())] = []int32{descriptor.FileExtensionTag, int32(i)}
+	}
+	for i, sd := range fd.Service {
+		path := []int32{descriptor.FileServiceTag, int32(i)}
+		name := join(scope, sd.GetName())
+		paths[name] = path
+		for j, md := range sd.Method {
+			paths[join(name, md.GetName())] = subpath(path, descriptor.ServiceMethodTag, int32(j))
+		}
+	}
+	return paths
+}
+
+func addMessagePaths(paths map[string][]int32, scope string, m *descriptorpb.DescriptorProto, path []int32) {
+	name := join(scope, m.GetName())
+	paths[name] = path
+	for i, f := range m.Field {
+		paths[join(name, f.GetName())] = subpath(path, descriptor.MessageFieldTag, int32(i))
+	}
+	for i, f := range m.Extension {
+		paths[join(name, f.GetName())] = subpath(path, descriptor.MessageExtensionTag, int32(i))
+	}
+	for i, o := range m.OneofDecl {
+		paths[join(name, o.GetName())] = subpath(path, descriptor.MessageOneofTag, int32(i))
+	}
+	for i, n := range m.NestedType {
+		addMessagePaths(paths, name, n, subpath(path, descriptor.MessageNestedTag, int32(i)))
+	}
+	for i, e := range m.EnumType {
+		addEnumPaths(paths, name, e, subpath(path, descriptor.MessageEnumTag, int32(i)))
+	}
+}
+
+func addEnumPaths(paths map[string][]int32, scope string, e *descriptorpb.EnumDescriptorProto, path []int32) {
+	paths[join(scope, e.GetName())] = path
+	for i, v := range e.Value {
+		paths[join(scope, v.GetName())] = subpath(path, descriptor.EnumValueTag, int32(i))
+	}
+}
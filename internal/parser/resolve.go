@@ -0,0 +1,413 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"rogchap.com/protoparser/internal/descriptor"
+	"rogchap.com/protoparser/internal/scanner"
+)
+
+type symbolKind int
+
+const (
+	badSymbol symbolKind = iota
+	packageSymbol
+	messageSymbol
+	enumSymbol
+	enumValueSymbol
+	fieldSymbol
+	oneofSymbol
+	serviceSymbol
+	methodSymbol
+)
+
+// isAggregate reports whether symbols of this kind may contain other symbols.
+func (k symbolKind) isAggregate() bool {
+	return k == packageSymbol || k == messageSymbol || k == enumSymbol || k == serviceSymbol
+}
+
+// isType reports whether symbols of this kind can be used as a field type.
+func (k symbolKind) isType() bool {
+	return k == messageSymbol || k == enumSymbol
+}
+
+// isField reports whether symbols of this kind can name an option.
+func (k symbolKind) isField() bool {
+	return k == fieldSymbol
+}
+
+// A symbol is the kind of a named element and the name of the file that
+// declares it. Packages are declared by no one file.
+type symbol struct {
+	kind symbolKind
+	file string
+}
+
+// symbols maps fully-qualified names, without the leading dot, to the
+// elements they name.
+type symbols map[string]symbol
+
+func join(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+func (s symbols) addFile(fd *descriptorpb.FileDescriptorProto) {
+	pkg := fd.GetPackage()
+	for pkg != "" {
+		s[pkg] = symbol{kind: packageSymbol}
+		i := strings.LastIndexByte(pkg, '.')
+		if i < 0 {
+			break
+		}
+		pkg = pkg[:i]
+	}
+
+	file := fd.GetName()
+	scope := fd.GetPackage()
+	for _, m := range fd.MessageType {
+		s.addMessage(file, scope, m)
+	}
+	for _, e := range fd.EnumType {
+		s.addEnum(file, scope, e)
+	}
+	for _, f := range fd.Extension {
+		s[join(scope, f.GetName())] = symbol{fieldSymbol, file}
+	}
+	for _, sd := range fd.Service {
+		name := join(scope, sd.GetName())
+		s[name] = symbol{serviceSymbol, file}
+		for _, md := range sd.Method {
+			s[join(name, md.GetName())] = symbol{methodSymbol, file}
+		}
+	}
+}
+
+func (s symbols) addMessage(file, scope string, m *descriptorpb.DescriptorProto) {
+	name := join(scope, m.GetName())
+	s[name] = symbol{messageSymbol, file}
+	for _, f := range m.Field {
+		s[join(name, f.GetName())] = symbol{fieldSymbol, file}
+	}
+	for _, f := range m.Extension {
+		s[join(name, f.GetName())] = symbol{fieldSymbol, file}
+	}
+	for _, o := range m.OneofDecl {
+		s[join(name, o.GetName())] = symbol{oneofSymbol, file}
+	}
+	for _, n := range m.NestedType {
+		s.addMessage(file, name, n)
+	}
+	for _, e := range m.EnumType {
+		s.addEnum(file, name, e)
+	}
+}
+
+func (s symbols) addEnum(file, scope string, e *descriptorpb.EnumDescriptorProto) {
+	s[join(scope, e.GetName())] = symbol{enumSymbol, file}
+	// enum values are siblings of their enum, not children of it
+	for _, v := range e.Value {
+		s[join(scope, v.GetName())] = symbol{enumValueSymbol, file}
+	}
+}
+
+// lookup finds the symbol that name refers to when used from within the
+// element relativeTo, following the protobuf scoping rules: the innermost
+// scope that defines the first component of name is the one that is used.
+// A name of a single component only matches symbols whose kind is wanted.
+// It returns the fully-qualified name of the symbol and the symbol, whose
+// kind is badSymbol if it could not be found.
+func (s symbols) lookup(name, relativeTo string, want func(symbolKind) bool) (string, symbol) {
+	if strings.HasPrefix(name, ".") {
+		return name[1:], s[name[1:]]
+	}
+
+	first := name
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		first = name[:i]
+	}
+
+	scope := relativeTo
+	for {
+		i := strings.LastIndexByte(scope, '.')
+		if i < 0 {
+			return name, s[name]
+		}
+		scope = scope[:i]
+
+		sym, ok := s[join(scope, first)]
+		if !ok {
+			continue
+		}
+		if first != name {
+			if sym.kind.isAggregate() {
+				full := join(scope, name)
+				return full, s[full]
+			}
+			// not an aggregate, so it cannot contain the rest of name
+			continue
+		}
+		if want(sym.kind) {
+			return join(scope, first), sym
+		}
+	}
+}
+
+// resolveTypes qualifies the type names used in fd, and sets the type of
+// fields that refer to messages and enums. Names are looked up in fd and
+// in files, the other files that have been loaded. If link is set, every
+// name must resolve to a declaration in fd or in a file that it imports,
+// and those that don't are reported, as are the imports that fd does not
+// use, other than those in failed, whose errors have been reported
+// already; otherwise names that cannot be found are left as written, as
+// they may refer to types from files that were not loaded. Problems are
+// reported to report.
+func resolveTypes(fd *descriptorpb.FileDescriptorProto, files []*descriptorpb.FileDescriptorProto, link bool, failed map[string]bool, report reportFunc) {
+	r := newResolver(fd, files, link, report)
+	r.failed = failed
+	scope := fd.GetPackage()
+	r.useOptions(fd.Options, fileScope(scope))
+	for i, m := range fd.MessageType {
+		r.resolveMessage(scope, m, []int32{descriptor.FileMessageTag, int32(i)})
+	}
+	for _, e := range fd.EnumType {
+		r.useEnumOptions(scope, e)
+	}
+	for i, f := range fd.Extension {
+		r.resolveField(scope, f, []int32{descriptor.FileExtensionTag, int32(i)})
+	}
+	for i, sd := range fd.Service {
+		name := join(scope, sd.GetName())
+		r.useOptions(sd.Options, name)
+		for j, md := range sd.Method {
+			path := []int32{descriptor.FileServiceTag, int32(i), descriptor.ServiceMethodTag, int32(j)}
+			relativeTo := join(name, md.GetName())
+			if full, ok := r.resolveMessageType(md.GetInputType(), relativeTo, subpath(path, descriptor.MethodInputTag)); ok {
+				md.InputType = strPtr("." + full)
+			}
+			if full, ok := r.resolveMessageType(md.GetOutputType(), relativeTo, subpath(path, descriptor.MethodOutputTag)); ok {
+				md.OutputType = strPtr("." + full)
+			}
+			r.useOptions(md.Options, relativeTo)
+		}
+	}
+
+	if link {
+		r.checkImports(fd)
+	}
+}
+
+// importedFiles returns the names of the files whose declarations fd can
+// use, among files, mapped to the index of the import of fd that makes
+// them visible: the files fd imports, and those they import publicly,
+// transitively.
+func importedFiles(fd *descriptorpb.FileDescriptorProto, files []*descriptorpb.FileDescriptorProto) map[string]int {
+	byName := make(map[string]*descriptorpb.FileDescriptorProto, len(files))
+	for _, f := range files {
+		byName[f.GetName()] = f
+	}
+	imports := make(map[string]int)
+	var add func(name string, i int)
+	add = func(name string, i int) {
+		f := byName[name]
+		if _, seen := imports[name]; f == nil || seen {
+			return
+		}
+		imports[name] = i
+		for _, j := range f.PublicDependency {
+			if int(j) < len(f.Dependency) {
+				add(f.Dependency[j], i)
+			}
+		}
+	}
+	for i, dep := range fd.Dependency {
+		add(dep, i)
+	}
+	return imports
+}
+
+// A resolver resolves the type names used in a file.
+type resolver struct {
+	report  reportFunc
+	syms    symbols
+	link    bool            // report names that cannot be resolved
+	file    string          // name of the file
+	imports map[string]int  // visible files, to the import that provides them
+	used    []bool          // whether each import provides a name used
+	failed  map[string]bool // imports that failed to load, or are in a cycle
+}
+
+// newResolver returns a resolver of the names used in fd, which are
+// looked up in fd and in files, the other files that have been loaded.
+func newResolver(fd *descriptorpb.FileDescriptorProto, files []*descriptorpb.FileDescriptorProto, link bool, report reportFunc) *resolver {
+	r := &resolver{
+		report:  report,
+		syms:    make(symbols),
+		link:    link,
+		file:    fd.GetName(),
+		imports: importedFiles(fd, files),
+		used:    make([]bool, len(fd.Dependency)),
+	}
+	for _, f := range files {
+		r.syms.addFile(f)
+	}
+	r.syms.addFile(fd)
+	return r
+}
+
+// fileScope returns the element that the options of a file in package
+// pkg are set on, for the lookup of the names they use. Names are looked
+// up starting in the scope that contains the element, so this is a made-up
+// element of the package, pkg.dummy, making the lookup start in the
+// package itself. protoc scopes file options with the same made-up name.
+func fileScope(pkg string) string {
+	return join(pkg, "dummy")
+}
+
+// error reports a problem with the name used by the element at path.
+func (r *resolver) error(path []int32, msg string) {
+	if r.link {
+		r.report(path, scanner.SeverityError, scanner.CodeInvalid, msg)
+	}
+}
+
+// use records the use of sym, which name refers to, by the element at
+// path, and reports whether sym is visible from the file.
+func (r *resolver) use(name string, sym symbol, path []int32) bool {
+	if sym.file == "" || sym.file == r.file {
+		return true
+	}
+	if i, ok := r.imports[sym.file]; ok {
+		r.used[i] = true
+		return true
+	}
+	if path != nil {
+		r.error(path, fmt.Sprintf("%q seems to be defined in %q, which is not imported by %q", name, sym.file, r.file))
+	}
+	return false
+}
+
+// checkImports reports the imports of fd that provide no name it uses.
+// Public imports are exempt, as they provide names to the files that
+// import fd.
+func (r *resolver) checkImports(fd *descriptorpb.FileDescriptorProto) {
+	public := make(map[int]bool)
+	for _, i := range fd.PublicDependency {
+		public[int(i)] = true
+	}
+	for i, dep := range fd.Dependency {
+		if r.used[i] || public[i] || r.failed[dep] {
+			continue
+		}
+		if j, ok := r.imports[dep]; !ok || j != i {
+			// not found, or imported twice
+			continue
+		}
+		path := []int32{descriptor.FileDependencyTag, int32(i)}
+		r.report(path, scanner.SeverityWarning, scanner.CodeUnusedImport, fmt.Sprintf("import %q is not used", dep))
+	}
+}
+
+// useOptions records the uses of the extensions named by the custom
+// options opts, set on the element relativeTo.
+func (r *resolver) useOptions(opts interface {
+	GetUninterpretedOption() []*descriptorpb.UninterpretedOption
+}, relativeTo string) {
+	for _, uo := range opts.GetUninterpretedOption() {
+		for _, part := range uo.Name {
+			if !part.GetIsExtension() {
+				continue
+			}
+			name := part.GetNamePart()
+			if _, sym := r.syms.lookup(name, relativeTo, symbolKind.isField); sym.kind.isField() {
+				r.use(name, sym, nil)
+			}
+		}
+	}
+}
+
+func (r *resolver) useEnumOptions(scope string, e *descriptorpb.EnumDescriptorProto) {
+	name := join(scope, e.GetName())
+	r.useOptions(e.Options, name)
+	for _, v := range e.Value {
+		r.useOptions(v.Options, join(scope, v.GetName()))
+	}
+}
+
+func (r *resolver) resolveMessage(scope string, m *descriptorpb.DescriptorProto, path []int32) {
+	name := join(scope, m.GetName())
+	r.useOptions(m.Options, name)
+	for i, f := range m.Field {
+		r.resolveField(name, f, subpath(path, descriptor.MessageFieldTag, int32(i)))
+	}
+	for i, f := range m.Extension {
+		r.resolveField(name, f, subpath(path, descriptor.MessageExtensionTag, int32(i)))
+	}
+	for i, n := range m.NestedType {
+		r.resolveMessage(name, n, subpath(path, descriptor.MessageNestedTag, int32(i)))
+	}
+	for _, e := range m.EnumType {
+		r.useEnumOptions(name, e)
+	}
+	for _, o := range m.OneofDecl {
+		r.useOptions(o.Options, join(name, o.GetName()))
+	}
+	for _, er := range m.ExtensionRange {
+		r.useOptions(er.Options, name)
+	}
+}
+
+func (r *resolver) resolveField(scope string, f *descriptorpb.FieldDescriptorProto, path []int32) {
+	relativeTo := join(scope, f.GetName())
+	r.useOptions(f.Options, relativeTo)
+	if f.Extendee != nil {
+		if full, ok := r.resolveMessageType(f.GetExtendee(), relativeTo, subpath(path, descriptor.FieldExtendeeTag)); ok {
+			f.Extendee = strPtr("." + full)
+		}
+	}
+	if f.TypeName == nil {
+		return
+	}
+	full, sym := r.syms.lookup(f.GetTypeName(), relativeTo, symbolKind.isType)
+	switch sym.kind {
+	case messageSymbol:
+		if !r.use(f.GetTypeName(), sym, subpath(path, descriptor.FieldTypeNameTag)) {
+			return
+		}
+		f.TypeName = strPtr("." + full)
+		if f.GetType() != descriptorpb.FieldDescriptorProto_TYPE_GROUP {
+			f.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+		}
+	case enumSymbol:
+		if !r.use(f.GetTypeName(), sym, subpath(path, descriptor.FieldTypeNameTag)) {
+			return
+		}
+		f.TypeName = strPtr("." + full)
+		f.Type = descriptorpb.FieldDescriptorProto_TYPE_ENUM.Enum()
+	case badSymbol:
+		r.error(subpath(path, descriptor.FieldTypeNameTag), strconv.Quote(f.GetTypeName())+" is not defined")
+	default:
+		r.error(subpath(path, descriptor.FieldTypeNameTag), strconv.Quote(f.GetTypeName())+" is not a type")
+	}
+}
+
+// resolveMessageType resolves name, used by the element at path, which
+// must refer to a message.
+func (r *resolver) resolveMessageType(name, relativeTo string, path []int32) (string, bool) {
+	full, sym := r.syms.lookup(name, relativeTo, symbolKind.isType)
+	switch sym.kind {
+	case messageSymbol:
+		return full, r.use(name, sym, path)
+	case badSymbol:
+		r.error(path, strconv.Quote(name)+" is not defined")
+	default:
+		r.error(path, strconv.Quote(name)+" is not a message type")
+	}
+	return "", false
+}
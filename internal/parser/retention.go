@@ -0,0 +1,256 @@
+package parser
+
+import (
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// The field number of retention in FieldOptions, and its value for source
+// retention, which the protobuf runtime's descriptor.proto is too old to
+// have.
+const (
+	retentionField  = 17
+	retentionSource = 2
+)
+
+// A retentionStripper removes the options that have source retention from
+// the files of a loader, as protoc removes them from the files it writes.
+type retentionStripper struct {
+	types map[string]*descriptorpb.DescriptorProto                // messages, by full name
+	exts  map[string]map[int32]*descriptorpb.FieldDescriptorProto // extensions, by extendee and number
+}
+
+// newRetentionStripper returns a retentionStripper of the files of l, whose
+// options messages are those of the descriptor.proto parsed from source,
+// or else those of the built-in descriptor.proto.
+func newRetentionStripper(l *loader) *retentionStripper {
+	s := &retentionStripper{
+		types: make(map[string]*descriptorpb.DescriptorProto),
+		exts:  make(map[string]map[int32]*descriptorpb.FieldDescriptorProto),
+	}
+	for _, f := range l.order {
+		s.addFile(f.fd)
+	}
+	if d := l.files[descriptorFile]; d == nil || d.p == nil {
+		s.addFile(builtinDescriptor())
+	}
+	return s
+}
+
+func (s *retentionStripper) addFile(fd *descriptorpb.FileDescriptorProto) {
+	s.addFields(fd.Extension)
+	for _, m := range fd.MessageType {
+		s.addMessage(fd.GetPackage(), m)
+	}
+}
+
+func (s *retentionStripper) addMessage(scope string, m *descriptorpb.DescriptorProto) {
+	name := join(scope, m.GetName())
+	s.types[name] = m
+	s.addFields(m.Extension)
+	for _, n := range m.NestedType {
+		s.addMessage(name, n)
+	}
+}
+
+func (s *retentionStripper) addFields(exts []*descriptorpb.FieldDescriptorProto) {
+	for _, f := range exts {
+		extendee := strings.TrimPrefix(f.GetExtendee(), ".")
+		if s.exts[extendee] == nil {
+			s.exts[extendee] = make(map[int32]*descriptorpb.FieldDescriptorProto)
+		}
+		s.exts[extendee][f.GetNumber()] = f
+	}
+}
+
+// strip returns fd without the options that have source retention, and
+// without the source locations of those options. Options messages left
+// empty are removed. fd itself is not modified.
+func (s *retentionStripper) strip(fd *descriptorpb.FileDescriptorProto) *descriptorpb.FileDescriptorProto {
+	fd = proto.Clone(fd).(*descriptorpb.FileDescriptorProto)
+	var stripped []string // the paths of the options removed
+	s.stripElement(fd.ProtoReflect(), nil, &stripped)
+	if len(stripped) == 0 || fd.SourceCodeInfo == nil {
+		return fd
+	}
+	locs := fd.SourceCodeInfo.Location[:0]
+	for _, loc := range fd.SourceCodeInfo.Location {
+		key := pathKey(loc.Path)
+		keep := true
+		for _, p := range stripped {
+			if strings.HasPrefix(key, p) {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			locs = append(locs, loc)
+		}
+	}
+	fd.SourceCodeInfo.Location = locs
+	return fd
+}
+
+// stripElement strips the options of m, an element of a file found at
+// path, and of the elements it declares.
+func (s *retentionStripper) stripElement(m protoreflect.Message, path []int32, stripped *[]string) {
+	var empty []protoreflect.FieldDescriptor // options messages left empty
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if fd.Message() == nil {
+			return true
+		}
+		fieldPath := subpath(path, int32(fd.Number()))
+		if isOptionsMessage(fd.Message()) {
+			if s.stripOptions(v.Message(), fieldPath, stripped) {
+				empty = append(empty, fd)
+			}
+			return true
+		}
+		if fd.IsList() {
+			l := v.List()
+			for i := 0; i < l.Len(); i++ {
+				s.stripElement(l.Get(i).Message(), subpath(fieldPath, int32(i)), stripped)
+			}
+		} else {
+			s.stripElement(v.Message(), fieldPath, stripped)
+		}
+		return true
+	})
+	for _, fd := range empty {
+		m.Clear(fd)
+	}
+}
+
+// isOptionsMessage reports whether md is one of the options messages of
+// descriptor.proto.
+func isOptionsMessage(md protoreflect.MessageDescriptor) bool {
+	return md.ParentFile().Path() == descriptorFile && strings.HasSuffix(string(md.Name()), "Options")
+}
+
+// stripOptions strips the options message opts, found at path, and
+// reports whether it is left empty.
+func (s *retentionStripper) stripOptions(opts protoreflect.Message, path []int32, stripped *[]string) bool {
+	b, err := proto.Marshal(opts.Interface())
+	if err != nil {
+		return false
+	}
+	b, ok := s.stripFields(b, string(opts.Descriptor().FullName()), path, stripped)
+	if !ok {
+		return false
+	}
+	if len(b) == 0 {
+		return true
+	}
+	proto.Reset(opts.Interface())
+	if err := proto.Unmarshal(b, opts.Interface()); err != nil {
+		return false
+	}
+	return false
+}
+
+// stripFields returns b, the encoding of a message of the type typ found
+// at path, without the fields that have source retention, and reports
+// whether any were removed.
+func (s *retentionStripper) stripFields(b []byte, typ string, path []int32, stripped *[]string) ([]byte, bool) {
+	var (
+		out     []byte
+		changed bool
+		counts  = make(map[int32]int32) // elements of repeated fields seen
+	)
+	for len(b) > 0 {
+		num, wtyp, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, false
+		}
+		m := protowire.ConsumeFieldValue(num, wtyp, b[n:])
+		if m < 0 {
+			return nil, false
+		}
+		field, value := b[:n+m], b[n:n+m]
+		b = b[n+m:]
+
+		f := s.field(typ, int32(num))
+		if f == nil {
+			out = append(out, field...)
+			continue
+		}
+		fieldPath := subpath(path, int32(num))
+		if f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+			fieldPath = subpath(fieldPath, counts[int32(num)])
+			counts[int32(num)]++
+		}
+		if sourceRetention(f) {
+			*stripped = append(*stripped, pathKey(fieldPath))
+			changed = true
+			continue
+		}
+
+		msgType := strings.TrimPrefix(f.GetTypeName(), ".")
+		switch {
+		case f.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE && wtyp == protowire.BytesType:
+			v, _ := protowire.ConsumeBytes(value)
+			if v, ok := s.stripFields(v, msgType, fieldPath, stripped); ok {
+				out = protowire.AppendTag(out, num, wtyp)
+				out = protowire.AppendBytes(out, v)
+				changed = true
+				continue
+			}
+		case f.GetType() == descriptorpb.FieldDescriptorProto_TYPE_GROUP && wtyp == protowire.StartGroupType:
+			v, _ := protowire.ConsumeGroup(num, value)
+			if v, ok := s.stripFields(v, msgType, fieldPath, stripped); ok {
+				out = protowire.AppendTag(out, num, wtyp)
+				out = append(out, v...)
+				out = protowire.AppendTag(out, num, protowire.EndGroupType)
+				changed = true
+				continue
+			}
+		}
+		out = append(out, field...)
+	}
+	return out, changed
+}
+
+// field returns the field or extension numbered num of the message type
+// typ, or nil if it is not known.
+func (s *retentionStripper) field(typ string, num int32) *descriptorpb.FieldDescriptorProto {
+	if m := s.types[typ]; m != nil {
+		for _, f := range m.Field {
+			if f.GetNumber() == num {
+				return f
+			}
+		}
+	}
+	return s.exts[typ][num]
+}
+
+// sourceRetention reports whether the field f has source retention. The
+// retention of a field is an unknown field of its options, as the
+// protobuf runtime's FieldOptions does not have it.
+func sourceRetention(f *descriptorpb.FieldDescriptorProto) bool {
+	source := false
+	b := f.GetOptions().ProtoReflect().GetUnknown()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return false
+		}
+		b = b[n:]
+		if num == retentionField && typ == protowire.VarintType {
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return false
+			}
+			source = v == retentionSource
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return false
+		}
+		b = b[n:]
+	}
+	return source
+}
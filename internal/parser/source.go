@@ -0,0 +1,105 @@
+package parser
+
+import (
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"rogchap.com/protoparser/internal/token"
+)
+
+// A location is the source location of an element of the file being
+// parsed, recorded as protoc records it: the location is added to the
+// SourceCodeInfo when the element starts, so that elements appear in the
+// order they start, and its span is filled in when the element ends.
+//
+// Locations are always recorded, as they give the positions of elements
+// for the diagnostics found once the file has been parsed; they are only
+// kept in the descriptor if the IncludeSourceInfo mode is set.
+type location struct {
+	loc   *descriptorpb.SourceCodeInfo_Location
+	start token.Pos
+}
+
+// subpath returns a new path made of path followed by elems.
+func subpath(path []int32, elems ...int32) []int32 {
+	return append(path[:len(path):len(path)], elems...)
+}
+
+// startLoc starts the location of the element identified by path at the
+// current token.
+func (p *parser) startLoc(path []int32) location {
+	return p.startLocAt(p.pos, path)
+}
+
+// startLocAt starts the location of the element identified by path at
+// pos.
+func (p *parser) startLocAt(pos token.Pos, path []int32) location {
+	l := location{loc: &descriptorpb.SourceCodeInfo_Location{Path: path}, start: pos}
+	p.locs = append(p.locs, l)
+	return l
+}
+
+// endLoc ends l at the end of the last token consumed.
+func (p *parser) endLoc(l location) {
+	p.endLocAt(l, p.prevEnd)
+}
+
+// endLocAt ends l at end.
+func (p *parser) endLocAt(l location, end token.Pos) {
+	if end < l.start {
+		end = l.start
+	}
+	start := p.file.Position(l.start)
+	startCol := protocColumn(p.src, start) - 1
+	stop := p.file.Position(end)
+	stopCol := protocColumn(p.src, stop) - 1
+	if start.Line == stop.Line {
+		l.loc.Span = []int32{int32(start.Line - 1), int32(startCol), int32(stopCol)}
+		return
+	}
+	l.loc.Span = []int32{int32(start.Line - 1), int32(startCol), int32(stop.Line - 1), int32(stopCol)}
+}
+
+// tokenLoc records the location of the element identified by path as
+// the span of the current token.
+func (p *parser) tokenLoc(path []int32) {
+	l := p.startLoc(path)
+	p.endLocAt(l, p.pos+token.Pos(p.tokenLen()))
+}
+
+// setPath sets the path of l, for elements whose path is only known once
+// they have been parsed.
+func (l location) setPath(path []int32) {
+	l.loc.Path = path
+}
+
+// sourceCodeInfo returns the recorded locations as a SourceCodeInfo.
+func (p *parser) sourceCodeInfo() *descriptorpb.SourceCodeInfo {
+	info := &descriptorpb.SourceCodeInfo{Location: make([]*descriptorpb.SourceCodeInfo_Location, len(p.locs))}
+	for i, l := range p.locs {
+		info.Location[i] = l.loc
+	}
+	return info
+}
+
+// pathPos returns the start of the first element recorded at path, or
+// NoPos if there is none.
+func (p *parser) pathPos(path ...int32) token.Pos {
+	if p.locIndex == nil {
+		p.locIndex = make(map[string]token.Pos, len(p.locs))
+		for _, l := range p.locs {
+			key := pathKey(l.loc.Path)
+			if _, ok := p.locIndex[key]; !ok {
+				p.locIndex[key] = l.start
+			}
+		}
+	}
+	return p.locIndex[pathKey(path)]
+}
+
+func pathKey(path []int32) string {
+	b := make([]byte, 0, 4*len(path))
+	for _, e := range path {
+		b = append(b, byte(e>>24), byte(e>>16), byte(e>>8), byte(e))
+	}
+	return string(b)
+}
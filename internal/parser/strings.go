@@ -8,22 +8,6 @@ import "unicode"
 	Copyright 2019 The Go Authors. All rights reserved.
 */
 
-func jsonCamelCase(s string) string {
-	var b []byte
-	var wasUnderscore bool
-	for i := 0; i < len(s); i++ { // proto identifiers are always ASCII
-		c := s[i]
-		if c != '_' {
-			if wasUnderscore && isASCIILower(c) {
-				c -= 'a' - 'A' // convert to uppercase
-			}
-			b = append(b, c)
-		}
-		wasUnderscore = c == '_'
-	}
-	return string(b)
-}
-
 // See protoc v3.8.0: src/google/protobuf/descriptor.cc:254-276,6057
 func mapEntryName(s string) string {
 	var b []byte
@@ -46,3 +30,7 @@ func mapEntryName(s string) string {
 func isASCIILower(c byte) bool {
 	return 'a' <= c && c <= 'z'
 }
+
+func isASCIIUpper(c byte) bool {
+	return 'A' <= c && c <= 'Z'
+}
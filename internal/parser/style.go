@@ -0,0 +1,54 @@
+package parser
+
+import (
+	"rogchap.com/protoparser/internal/scanner"
+	"rogchap.com/protoparser/internal/token"
+)
+
+// The naming conventions of the protobuf style guide:
+// https://developers.google.com/protocol-buffers/docs/style
+// Names that don't follow them are reported as informational
+// diagnostics; they are valid, just unconventional.
+
+func (p *parser) checkCamelCase(pos token.Pos, what, name string) {
+	if name == "" || isCamelCase(name) {
+		return
+	}
+	p.report(pos, len(name), scanner.SeverityInfo, scanner.CodeNaming, what+" name "+name+" should be CamelCase")
+}
+
+func (p *parser) checkLowerSnakeCase(pos token.Pos, what, name string) {
+	if name == "" || isSnakeCase(name, isASCIILower) {
+		return
+	}
+	p.report(pos, len(name), scanner.SeverityInfo, scanner.CodeNaming, what+" name "+name+" should be lower_snake_case")
+}
+
+func (p *parser) checkUpperSnakeCase(pos token.Pos, what, name string) {
+	if name == "" || isSnakeCase(name, isASCIIUpper) {
+		return
+	}
+	p.report(pos, len(name), scanner.SeverityInfo, scanner.CodeNaming, what+" name "+name+" should be UPPER_SNAKE_CASE")
+}
+
+func isCamelCase(s string) bool {
+	if !isASCIIUpper(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if s[i] == '_' {
+			return false
+		}
+	}
+	return true
+}
+
+func isSnakeCase(s string, isLetter func(byte) bool) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !isLetter(c) && !('0' <= c && c <= '9') && c != '_' {
+			return false
+		}
+	}
+	return true
+}
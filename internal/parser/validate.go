@@ -0,0 +1,599 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"rogchap.com/protoparser/internal/descriptor"
+	"rogchap.com/protoparser/internal/scanner"
+)
+
+// enumAllowAliasTag is the field number of allow_alias in EnumOptions.
+const enumAllowAliasTag = 2
+
+// Field numbers.
+const (
+	maxFieldNumber     = 536870911 // the largest field number
+	firstReservedField = 19000     // the numbers reserved for the implementation
+	lastReservedField  = 19999
+)
+
+// A validator checks the rules that the declarations of a file must
+// follow, beyond those of the grammar, as protoc does once it has parsed
+// the file. Problems are reported about the elements at paths within the
+// file, so that they can be located in its source.
+type validator struct {
+	fd     *descriptorpb.FileDescriptorProto
+	report reportFunc
+}
+
+// A reportFunc reports a diagnostic about the element at path within a
+// file, with notes about other elements of the file.
+type reportFunc func(path []int32, sev scanner.Severity, code, msg string, notes ...pathNote)
+
+// A pathNote adds information to a diagnostic about the element at path.
+type pathNote struct {
+	path []int32
+	msg  string
+}
+
+// validate checks the declarations of fd, which p has just parsed, and
+// that they don't conflict with those of files, the files loaded before it.
+func (p *parser) validate(fd *descriptorpb.FileDescriptorProto, files []*descriptorpb.FileDescriptorProto) {
+	v := validator{fd: fd, report: p.reportPath}
+	v.validateFile()
+	v.validateConflicts(files)
+}
+
+// reportPath reports a diagnostic at the source of the element at path in
+// the file being parsed. It is the reportFunc of the parser.
+func (p *parser) reportPath(path []int32, sev scanner.Severity, code, msg string, notes ...pathNote) {
+	var ns []*scanner.Note
+	for _, n := range notes {
+		ns = append(ns, p.note(p.pathPos(n.path...), 0, n.msg))
+	}
+	p.report(p.pathPos(path...), 0, sev, code, msg, ns...)
+}
+
+func (v *validator) error(path []int32, msg string, notes ...pathNote) {
+	v.report(path, scanner.SeverityError, scanner.CodeInvalid, msg, notes...)
+}
+
+func (v *validator) validateFile() {
+	scope := v.fd.GetPackage()
+	for i, m := range v.fd.MessageType {
+		v.validateMessage(scope, m, []int32{descriptor.FileMessageTag, int32(i)})
+	}
+	for i, e := range v.fd.EnumType {
+		v.validateEnum(scope, e, []int32{descriptor.FileEnumTag, int32(i)})
+	}
+	v.validateExtensions(v.fd.Extension, []int32{descriptor.FileExtensionTag})
+
+	decls := make(map[string][]int32)
+	for i, m := range v.fd.MessageType {
+		v.declare(decls, scope, nil, m.GetName(), descriptor.FileMessageTag, i)
+	}
+	for i, e := range v.fd.EnumType {
+		v.declareEnum(decls, scope, nil, e, descriptor.FileEnumTag, i)
+	}
+	for i, sd := range v.fd.Service {
+		v.declare(decls, scope, nil, sd.GetName(), descriptor.FileServiceTag, i)
+	}
+	for i, f := range v.fd.Extension {
+		v.declare(decls, scope, nil, f.GetName(), descriptor.FileExtensionTag, i)
+	}
+
+	for i, sd := range v.fd.Service {
+		path := []int32{descriptor.FileServiceTag, int32(i)}
+		methods := make(map[string][]int32)
+		for j, md := range sd.Method {
+			v.declare(methods, join(scope, sd.GetName()), path, md.GetName(), descriptor.ServiceMethodTag, j)
+		}
+	}
+}
+
+func (v *validator) validateMessage(scope string, m *descriptorpb.DescriptorProto, path []int32) {
+	name := join(scope, m.GetName())
+	v.validateRanges(m, path)
+
+	numbers := make(map[int32]int)
+	for i, f := range m.Field {
+		fieldPath := subpath(path, descriptor.MessageFieldTag, int32(i))
+		numPath := subpath(fieldPath, descriptor.FieldNumberTag)
+		n := f.GetNumber()
+		if !v.validateNumber(n, numPath, false) {
+			continue
+		}
+		if j, ok := numbers[n]; ok {
+			v.error(numPath, fmt.Sprintf("field number %d has already been used in %q by field %q", n, name, m.Field[j].GetName()),
+				pathNote{subpath(path, descriptor.MessageFieldTag, int32(j), descriptor.FieldNumberTag), "previously used here"})
+		} else {
+			numbers[n] = i
+		}
+		for j, r := range m.ReservedRange {
+			if r.GetStart() <= n && n < r.GetEnd() {
+				v.error(numPath, fmt.Sprintf("field %q uses reserved number %d", f.GetName(), n),
+					pathNote{subpath(path, descriptor.MessageReservedRangeTag, int32(j)), "reserved here"})
+			}
+		}
+		for j, r := range m.ExtensionRange {
+			if r.GetStart() <= n && n < r.GetEnd() {
+				v.error(subpath(path, descriptor.MessageExtensionRangeTag, int32(j)),
+					fmt.Sprintf("extension range %d to %d includes field %q (%d)", r.GetStart(), r.GetEnd()-1, f.GetName(), n),
+					pathNote{numPath, "field defined here"})
+			}
+		}
+		for j, rn := range m.ReservedName {
+			if rn == f.GetName() {
+				v.error(subpath(fieldPath, descriptor.FieldNameTag), fmt.Sprintf("field name %q is reserved", rn),
+					pathNote{subpath(path, descriptor.MessageReservedNameTag, int32(j)), "reserved here"})
+			}
+		}
+	}
+
+	v.validateJSONNames(m, path)
+
+	for i, n := range m.NestedType {
+		v.validateMessage(name, n, subpath(path, descriptor.MessageNestedTag, int32(i)))
+	}
+	for i, e := range m.EnumType {
+		v.validateEnum(name, e, subpath(path, descriptor.MessageEnumTag, int32(i)))
+	}
+	v.validateExtensions(m.Extension, subpath(path, descriptor.MessageExtensionTag))
+
+	// The elements are declared in the order in which protoc builds them,
+	// so that the same one of two elements with the same name is reported.
+	decls := make(map[string][]int32)
+	for i, o := range m.OneofDecl {
+		v.declare(decls, name, path, o.GetName(), descriptor.MessageOneofTag, i)
+	}
+	for i, f := range m.Field {
+		v.declare(decls, name, path, f.GetName(), descriptor.MessageFieldTag, i)
+	}
+	for i, n := range m.NestedType {
+		v.declare(decls, name, path, n.GetName(), descriptor.MessageNestedTag, i)
+	}
+	for i, e := range m.EnumType {
+		v.declareEnum(decls, name, path, e, descriptor.MessageEnumTag, i)
+	}
+	for i, f := range m.Extension {
+		v.declare(decls, name, path, f.GetName(), descriptor.MessageExtensionTag, i)
+	}
+}
+
+// declare records in decls the path of the name of the element declared
+// in scope as the i'th of those with tag in the element at path. If an
+// element of the same name was declared before it, that is reported
+// instead, as protoc reports it. It reports whether the name was new.
+func (v *validator) declare(decls map[string][]int32, scope string, path []int32, name string, tag int32, i int) bool {
+	if name == "" {
+		// reported as a missing name
+		return true
+	}
+	namePath := subpath(path, tag, int32(i), 1) // the name of every element has tag 1
+	prev, ok := decls[name]
+	if !ok {
+		decls[name] = namePath
+		return true
+	}
+	v.error(namePath, alreadyDefined(name, scope), pathNote{prev, "previously defined here"})
+	return false
+}
+
+// alreadyDefined returns the error for a second declaration of name in
+// scope.
+func alreadyDefined(name, scope string) string {
+	if scope == "" {
+		return fmt.Sprintf("%q is already defined", name)
+	}
+	return fmt.Sprintf("%q is already defined in %q", name, scope)
+}
+
+// validateJSONNames checks that no two fields of the message m at path
+// have the same JSON name, which would make its JSON form ambiguous. This
+// is an error in proto3, and only a warning in proto2, where protoc has
+// long allowed it.
+func (v *validator) validateJSONNames(m *descriptorpb.DescriptorProto, path []int32) {
+	sev := scanner.SeverityWarning
+	if v.fd.GetSyntax() == "proto3" {
+		sev = scanner.SeverityError
+	}
+
+	kind := func(custom bool) string {
+		if custom {
+			return "custom"
+		}
+		return "default"
+	}
+	names := make(map[string]int)
+	for i, f := range m.Field {
+		name, custom := jsonName(f)
+		j, ok := names[name]
+		if !ok {
+			names[name] = i
+			continue
+		}
+		if m.Field[j].GetName() == f.GetName() {
+			// reported as a duplicate declaration
+			continue
+		}
+		_, prevCustom := jsonName(m.Field[j])
+		fieldPath := subpath(path, descriptor.MessageFieldTag, int32(i))
+		namePath := subpath(fieldPath, descriptor.FieldNameTag)
+		if custom {
+			namePath = subpath(fieldPath, descriptor.FieldJSONNameTag)
+		}
+		v.report(namePath, sev, scanner.CodeJSONName,
+			fmt.Sprintf("the %s JSON name of field %q (%q) conflicts with the %s JSON name of field %q",
+				kind(custom), f.GetName(), name, kind(prevCustom), m.Field[j].GetName()),
+			pathNote{subpath(path, descriptor.MessageFieldTag, int32(j), descriptor.FieldNameTag), "previously defined here"})
+	}
+}
+
+// jsonName returns the JSON name of the field f, and whether it was set
+// with the json_name option rather than derived from the field name.
+func jsonName(f *descriptorpb.FieldDescriptorProto) (string, bool) {
+	def := descriptor.JSONCamelCase(f.GetName())
+	if f.JsonName == nil {
+		return def, false
+	}
+	return f.GetJsonName(), f.GetJsonName() != def
+}
+
+// validateEnum checks the values of the enum e at path, declared in scope.
+func (v *validator) validateEnum(scope string, e *descriptorpb.EnumDescriptorProto, path []int32) {
+	if len(e.Value) == 0 {
+		v.error(subpath(path, descriptor.EnumNameTag), "enums must contain at least one value")
+		return
+	}
+	proto3 := v.fd.GetSyntax() == "proto3"
+	valuePath := func(i int, tag int32) []int32 {
+		return subpath(path, descriptor.EnumValueTag, int32(i), tag)
+	}
+	if proto3 && e.Value[0].GetNumber() != 0 {
+		v.error(valuePath(0, descriptor.EnumValueNumberTag), "the first enum value must be zero in proto3")
+	}
+
+	allowAlias := e.GetOptions().GetAllowAlias()
+	aliased := false
+	numbers := make(map[int32]int)
+	for i, ev := range e.Value {
+		n := ev.GetNumber()
+		if j, ok := numbers[n]; ok {
+			aliased = true
+			if !allowAlias {
+				v.error(valuePath(i, descriptor.EnumValueNumberTag),
+					fmt.Sprintf("%q uses the same enum value as %q. If this is intended, set 'option allow_alias = true;' to the enum definition",
+						join(scope, ev.GetName()), join(scope, e.Value[j].GetName())),
+					pathNote{valuePath(j, descriptor.EnumValueNumberTag), "previously used here"})
+			}
+		} else {
+			numbers[n] = i
+		}
+		for j, r := range e.ReservedRange {
+			if r.GetStart() <= n && n <= r.GetEnd() {
+				v.error(valuePath(i, descriptor.EnumValueNumberTag), fmt.Sprintf("enum value %q uses reserved number %d", ev.GetName(), n),
+					pathNote{subpath(path, descriptor.EnumReservedRangeTag, int32(j)), "reserved here"})
+			}
+		}
+		for j, rn := range e.ReservedName {
+			if rn == ev.GetName() {
+				v.error(valuePath(i, descriptor.EnumValueNameTag), fmt.Sprintf("enum value %q is reserved", rn),
+					pathNote{subpath(path, descriptor.EnumReservedNameTag, int32(j)), "reserved here"})
+			}
+		}
+	}
+	if allowAlias && !aliased {
+		v.error(subpath(path, descriptor.EnumOptionsTag, enumAllowAliasTag),
+			fmt.Sprintf("%q declares support for enum aliases but no enum values share field numbers. Please remove the unnecessary 'option allow_alias = true;' declaration",
+				join(scope, e.GetName())))
+	}
+
+	for i, r := range e.ReservedRange {
+		if r.GetEnd() < r.GetStart() {
+			v.error(subpath(path, descriptor.EnumReservedRangeTag, int32(i)), "reserved range end number must be greater than start number")
+			continue
+		}
+		for j, prev := range e.ReservedRange[:i] {
+			if r.GetStart() <= prev.GetEnd() && prev.GetStart() <= r.GetEnd() {
+				v.error(subpath(path, descriptor.EnumReservedRangeTag, int32(i)),
+					fmt.Sprintf("reserved range %d to %d overlaps with already-defined range %d to %d",
+						r.GetStart(), r.GetEnd(), prev.GetStart(), prev.GetEnd()),
+					pathNote{subpath(path, descriptor.EnumReservedRangeTag, int32(j)), "previously defined here"})
+			}
+		}
+	}
+
+	// Generated code often strips the name of the enum from the names of
+	// its values, so values whose names are then the same must be aliases.
+	sev := scanner.SeverityWarning
+	if proto3 {
+		sev = scanner.SeverityError
+	}
+	stripped := make(map[string]int)
+	for i, ev := range e.Value {
+		name := enumValuePascalCase(stripEnumPrefix(e.GetName(), ev.GetName()))
+		j, ok := stripped[name]
+		if !ok {
+			stripped[name] = i
+			continue
+		}
+		if e.Value[j].GetNumber() != ev.GetNumber() && e.Value[j].GetName() != ev.GetName() {
+			v.report(valuePath(i, descriptor.EnumValueNameTag), sev, scanner.CodeInvalid,
+				fmt.Sprintf("enum name %s has the same name as %s if you ignore case and strip out the enum name prefix (if any). (If you are using allow_alias, please assign the same number to each enum value name.)",
+					ev.GetName(), e.Value[j].GetName()),
+				pathNote{valuePath(j, descriptor.EnumValueNameTag), "previously defined here"})
+		}
+	}
+}
+
+// validateConflicts checks that none of the elements declared in the file
+// has the name of an element declared in files, the other files loaded
+// before it.
+func (v *validator) validateConflicts(files []*descriptorpb.FileDescriptorProto) {
+	others := make(symbols)
+	for _, f := range files {
+		others.addFile(f)
+	}
+	walkDecls(v.fd, func(name string, path []int32) {
+		if sym, ok := others[name]; ok && sym.kind != packageSymbol {
+			v.error(path, fmt.Sprintf("%q is already defined in file %q", name, sym.file))
+		}
+	})
+}
+
+// walkDecls calls fn with the full name of each element declared in fd,
+// in the order in which protoc declares them, and the path of its name.
+func walkDecls(fd *descriptorpb.FileDescriptorProto, fn func(name string, path []int32)) {
+	decl := func(scope, name string, path []int32) {
+		if name != "" {
+			fn(join(scope, name), subpath(path, 1)) // the name of every element has tag 1
+		}
+	}
+	enum := func(scope string, e *descriptorpb.EnumDescriptorProto, path []int32) {
+		decl(scope, e.GetName(), path)
+		for i, ev := range e.Value {
+			// enum values are siblings of their enum
+			decl(scope, ev.GetName(), subpath(path, descriptor.EnumValueTag, int32(i)))
+		}
+	}
+	var message func(scope string, m *descriptorpb.DescriptorProto, path []int32)
+	message = func(scope string, m *descriptorpb.DescriptorProto, path []int32) {
+		decl(scope, m.GetName(), path)
+		name := join(scope, m.GetName())
+		for i, o := range m.OneofDecl {
+			decl(name, o.GetName(), subpath(path, descriptor.MessageOneofTag, int32(i)))
+		}
+		for i, f := range m.Field {
+			decl(name, f.GetName(), subpath(path, descriptor.MessageFieldTag, int32(i)))
+		}
+		for i, n := range m.NestedType {
+			message(name, n, subpath(path, descriptor.MessageNestedTag, int32(i)))
+		}
+		for i, e := range m.EnumType {
+			enum(name, e, subpath(path, descriptor.MessageEnumTag, int32(i)))
+		}
+		for i, f := range m.Extension {
+			decl(name, f.GetName(), subpath(path, descriptor.MessageExtensionTag, int32(i)))
+		}
+	}
+
+	scope := fd.GetPackage()
+	for i, m := range fd.MessageType {
+		message(scope, m, []int32{descriptor.FileMessageTag, int32(i)})
+	}
+	for i, e := range fd.EnumType {
+		enum(scope, e, []int32{descriptor.FileEnumTag, int32(i)})
+	}
+	for i, sd := range fd.Service {
+		path := []int32{descriptor.FileServiceTag, int32(i)}
+		decl(scope, sd.GetName(), path)
+		for j, md := range sd.Method {
+			decl(join(scope, sd.GetName()), md.GetName(), subpath(path, descriptor.ServiceMethodTag, int32(j)))
+		}
+	}
+	for i, f := range fd.Extension {
+		decl(scope, f.GetName(), []int32{descriptor.FileExtensionTag, int32(i)})
+	}
+}
+
+// declareEnum declares the enum e, as declare does, and its values. The
+// names of the values must be unique within the enum, and within scope:
+// enum values follow the scoping rules of C++, so they are siblings of
+// their enum, sharing scope with the other elements of decls, including
+// the values of the other enums of the scope.
+func (v *validator) declareEnum(decls map[string][]int32, scope string, path []int32, e *descriptorpb.EnumDescriptorProto, tag int32, i int) {
+	v.declare(decls, scope, path, e.GetName(), tag, i)
+	within := "the global scope"
+	if scope != "" {
+		within = strconv.Quote(scope)
+	}
+	enumPath := subpath(path, tag, int32(i))
+	values := make(map[string][]int32)
+	for j, ev := range e.Value {
+		name := ev.GetName()
+		if !v.declare(values, join(scope, e.GetName()), enumPath, name, descriptor.EnumValueTag, j) {
+			continue
+		}
+		valuePath := subpath(enumPath, descriptor.EnumValueTag, int32(j), descriptor.EnumValueNameTag)
+		prev, ok := decls[name]
+		if !ok {
+			decls[name] = valuePath
+			continue
+		}
+		msg := alreadyDefined(name, scope) + fmt.Sprintf(". Note that enum values use C++ scoping rules, meaning that enum values are siblings of their type, not children of it.  Therefore, %q must be unique within %s, not just within %q",
+			name, within, e.GetName())
+		v.error(valuePath, msg, pathNote{prev, "previously defined here"})
+	}
+}
+
+// stripEnumPrefix returns the name of an enum value without the name of
+// its enum as a prefix, compared without regard to case or underscores,
+// and the underscores that follow it. A name that is only the prefix is
+// returned as it is.
+func stripEnumPrefix(enum, value string) string {
+	var prefix []byte
+	for i := 0; i < len(enum); i++ {
+		if enum[i] != '_' {
+			prefix = append(prefix, lower(enum[i]))
+		}
+	}
+	i, j := 0, 0
+	for i < len(value) && j < len(prefix) {
+		if value[i] == '_' {
+			i++
+			continue
+		}
+		if lower(value[i]) != prefix[j] {
+			return value
+		}
+		i++
+		j++
+	}
+	if j < len(prefix) {
+		return value
+	}
+	for i < len(value) && value[i] == '_' {
+		i++
+	}
+	if i == len(value) {
+		return value
+	}
+	return value[i:]
+}
+
+// enumValuePascalCase returns the name of an enum value in PascalCase, as
+// generated code may name it.
+func enumValuePascalCase(s string) string {
+	var b strings.Builder
+	upper := true
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '_':
+			upper = true
+			continue
+		case upper && 'a' <= c && c <= 'z':
+			c -= 'a' - 'A'
+		case !upper:
+			c = lower(c)
+		}
+		b.WriteByte(c)
+		upper = false
+	}
+	return b.String()
+}
+
+func lower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// validateNumber checks the field number n at path, and reports whether
+// it is valid. The largest number of an extension depends on whether the
+// extended message uses the message set wire format, so it isn't checked.
+func (v *validator) validateNumber(n int32, path []int32, ext bool) bool {
+	switch {
+	case n <= 0:
+		v.error(path, "field numbers must be positive integers")
+	case n > maxFieldNumber && !ext:
+		v.error(path, fmt.Sprintf("field numbers cannot be greater than %d", maxFieldNumber))
+	case firstReservedField <= n && n <= lastReservedField:
+		v.error(path, fmt.Sprintf("field numbers %d through %d are reserved for the protocol buffer library implementation", firstReservedField, lastReservedField))
+	default:
+		return true
+	}
+	return false
+}
+
+// validateExtensions checks the extension fields exts, whose paths are
+// path followed by their index.
+func (v *validator) validateExtensions(exts []*descriptorpb.FieldDescriptorProto, path []int32) {
+	type use struct {
+		extendee string
+		number   int32
+	}
+	uses := make(map[use]int)
+	for i, f := range exts {
+		numPath := subpath(path, int32(i), descriptor.FieldNumberTag)
+		n := f.GetNumber()
+		if !v.validateNumber(n, numPath, true) {
+			continue
+		}
+		u := use{f.GetExtendee(), n}
+		if j, ok := uses[u]; ok {
+			v.error(numPath, fmt.Sprintf("extension number %d has already been used in %q by extension %q", n, strings.TrimPrefix(u.extendee, "."), exts[j].GetName()),
+				pathNote{subpath(path, int32(j), descriptor.FieldNumberTag), "previously used here"})
+			continue
+		}
+		uses[u] = i
+	}
+}
+
+// validateRanges checks the reserved and extension ranges of the message
+// m at path.
+func (v *validator) validateRanges(m *descriptorpb.DescriptorProto, path []int32) {
+	max := int32(maxFieldNumber + 1)
+	if m.GetOptions().GetMessageSetWireFormat() {
+		max = 1<<31 - 1
+	}
+
+	for i, r := range m.ReservedRange {
+		rangePath := subpath(path, descriptor.MessageReservedRangeTag, int32(i))
+		switch {
+		case r.GetStart() <= 0:
+			v.error(rangePath, "reserved numbers must be positive integers")
+			continue
+		case r.GetEnd() <= r.GetStart():
+			v.error(rangePath, "reserved range end number must be greater than start number")
+			continue
+		}
+		for j, prev := range m.ReservedRange[:i] {
+			if overlaps(r.GetStart(), r.GetEnd(), prev.GetStart(), prev.GetEnd()) {
+				v.error(rangePath, fmt.Sprintf("reserved range %d to %d overlaps with already-defined range %d to %d",
+					r.GetStart(), r.GetEnd()-1, prev.GetStart(), prev.GetEnd()-1),
+					pathNote{subpath(path, descriptor.MessageReservedRangeTag, int32(j)), "previously defined here"})
+			}
+		}
+	}
+
+	for i, r := range m.ExtensionRange {
+		rangePath := subpath(path, descriptor.MessageExtensionRangeTag, int32(i))
+		switch {
+		case r.GetStart() <= 0:
+			v.error(rangePath, "extension numbers must be positive integers")
+			continue
+		case r.GetEnd() > max:
+			v.error(rangePath, fmt.Sprintf("extension numbers cannot be greater than %d", max-1))
+			continue
+		case r.GetEnd() <= r.GetStart():
+			v.error(rangePath, "extension range end number must be greater than start number")
+			continue
+		}
+		for j, prev := range m.ExtensionRange[:i] {
+			if overlaps(r.GetStart(), r.GetEnd(), prev.GetStart(), prev.GetEnd()) {
+				v.error(rangePath, fmt.Sprintf("extension range %d to %d overlaps with already-defined range %d to %d",
+					r.GetStart(), r.GetEnd()-1, prev.GetStart(), prev.GetEnd()-1),
+					pathNote{subpath(path, descriptor.MessageExtensionRangeTag, int32(j)), "previously defined here"})
+			}
+		}
+		for j, res := range m.ReservedRange {
+			if overlaps(r.GetStart(), r.GetEnd(), res.GetStart(), res.GetEnd()) {
+				v.error(rangePath, fmt.Sprintf("extension range %d to %d overlaps with reserved range %d to %d",
+					r.GetStart(), r.GetEnd()-1, res.GetStart(), res.GetEnd()-1),
+					pathNote{subpath(path, descriptor.MessageReservedRangeTag, int32(j)), "reserved here"})
+			}
+		}
+	}
+}
+
+// overlaps reports whether the half-open ranges [start1, end1) and
+// [start2, end2) overlap.
+func overlaps(start1, end1, start2, end2 int32) bool {
+	return start1 < end2 && start2 < end1
+}
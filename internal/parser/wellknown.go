@@ -0,0 +1,88 @@
+package parser
+
+import (
+	_ "embed"
+	"sync"
+
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/apipb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/sourcecontextpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/typepb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// wellKnownFiles are the files that protoc ships with its include
+// directory, by import name. Imports of them that are not found in the
+// import paths resolve to these, so that no include directory is needed.
+// descriptor.proto is not among them: it is built in as source.
+var wellKnownFiles = map[string]protoreflect.FileDescriptor{}
+
+// descriptorSource is the source of the descriptor.proto of protoc 27.0,
+// as protocompile ships it. It declares the options messages as protoc
+// knows them, with fields, such as debug_redact and retention, that the
+// protobuf runtime's descriptor.proto is too old to have.
+//
+//go:embed include/google/protobuf/descriptor.proto
+var descriptorSource []byte
+
+var (
+	builtinOnce sync.Once
+	builtinFile *descriptorpb.FileDescriptorProto
+)
+
+// builtinDescriptor returns the descriptor.proto built into the parser,
+// parsed. It must not be modified.
+func builtinDescriptor() *descriptorpb.FileDescriptorProto {
+	builtinOnce.Do(func() {
+		builtinFile = newLoader(&Config{}).parse(descriptorFile, descriptorFile, descriptorSource).fd
+	})
+	return builtinFile
+}
+
+var (
+	compiledOnce sync.Once
+	compiledFile protoreflect.FileDescriptor
+)
+
+// BuiltinDescriptor returns the descriptor.proto built into the parser,
+// compiled. Its options messages have the fields that those of the
+// protobuf runtime lack, such as debug_redact, so they decode the options
+// that set them.
+func BuiltinDescriptor() protoreflect.FileDescriptor {
+	compiledOnce.Do(func() {
+		f, err := protodesc.NewFile(builtinDescriptor(), new(protoregistry.Files))
+		if err != nil {
+			panic("parser: built-in descriptor.proto: " + err.Error())
+		}
+		compiledFile = f
+	})
+	return compiledFile
+}
+
+func init() {
+	for _, f := range []protoreflect.FileDescriptor{
+		anypb.File_google_protobuf_any_proto,
+		apipb.File_google_protobuf_api_proto,
+		pluginpb.File_google_protobuf_compiler_plugin_proto,
+		durationpb.File_google_protobuf_duration_proto,
+		emptypb.File_google_protobuf_empty_proto,
+		fieldmaskpb.File_google_protobuf_field_mask_proto,
+		sourcecontextpb.File_google_protobuf_source_context_proto,
+		structpb.File_google_protobuf_struct_proto,
+		timestamppb.File_google_protobuf_timestamp_proto,
+		typepb.File_google_protobuf_type_proto,
+		wrapperspb.File_google_protobuf_wrappers_proto,
+	} {
+		wellKnownFiles[f.Path()] = f
+	}
+}
@@ -0,0 +1,271 @@
+package scanner
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"rogchap.com/protoparser/internal/token"
+)
+
+// Severity describes how serious a problem is. Only problems with
+// severity SeverityError make a file invalid.
+type Severity int
+
+// The severities, from most to least serious.
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityInfo
+)
+
+var severities = [...]string{
+	SeverityError:   "error",
+	SeverityWarning: "warning",
+	SeverityInfo:    "info",
+}
+
+func (s Severity) String() string {
+	if 0 <= s && int(s) < len(severities) {
+		return severities[s]
+	}
+	return "severity(" + strconv.Itoa(int(s)) + ")"
+}
+
+// Diagnostic codes identify the kind of problem reported. Codes are
+// stable across releases, so they may be used to filter diagnostics.
+const (
+	CodeSyntax        = "syntax"         // the source is malformed
+	CodeMissingSyntax = "missing-syntax" // no syntax statement; proto2 is assumed
+	CodeNaming        = "naming"         // a name doesn't follow the style guide
+	CodeUnusedImport  = "unused-import"  // an import provides no name that the file uses
+	CodeInvalid       = "invalid"        // the declarations are well formed, but not valid
+	CodeJSONName      = "json-name"      // two fields of a message have the same JSON name
+	CodeImport        = "import"         // an import is missing, has errors, or forms a cycle
+)
+
+// Error describes a problem found in a proto file, together with its
+// source position, severity, and diagnostic code.
+type Error struct {
+	Pos      token.Position
+	Msg      string
+	Severity Severity
+	Code     string
+	Snippet  *Snippet // the offending source, for display; or nil
+	Notes    []*Note  // related information; or nil
+}
+
+// A Note adds information to an Error about a related position, such as
+// the location of a previous definition.
+type Note struct {
+	Pos     token.Position
+	Msg     string
+	Snippet *Snippet // or nil
+}
+
+// A Snippet is the line of source that a diagnostic refers to, with the
+// byte range [Start, End) of the offending span within it. An empty span
+// marks a single position.
+type Snippet struct {
+	Line       string // the source line, without its line terminator
+	Start, End int
+}
+
+// NewSnippet returns the Snippet for the line of src that contains pos,
+// marking the span of n bytes starting at pos. A span that runs past the
+// end of the line is cut short at the end of the line. NewSnippet returns
+// nil if pos is not a valid position in src.
+func NewSnippet(src []byte, pos token.Position, n int) *Snippet {
+	if !pos.IsValid() || pos.Offset > len(src) || pos.Column > pos.Offset+1 {
+		return nil
+	}
+	start := pos.Offset - (pos.Column - 1)
+	end := start
+	for end < len(src) && src[end] != '\n' {
+		end++
+	}
+	line := strings.TrimSuffix(string(src[start:end]), "\r")
+	s := &Snippet{Line: line, Start: pos.Column - 1, End: pos.Column - 1 + n}
+	if s.Start > len(line) {
+		s.Start = len(line)
+	}
+	if s.End > len(line) {
+		s.End = len(line)
+	}
+	if s.End < s.Start {
+		s.End = s.Start
+	}
+	return s
+}
+
+// String returns the source line followed by a line with a caret under
+// the start of the span and tildes under the rest of it, as in
+//
+//	int32 a = ;
+//	          ^
+//
+// Tabs in the source line are kept in the marker line so that the
+// marker lines up however tabs are displayed.
+func (s *Snippet) String() string {
+	var sb strings.Builder
+	sb.WriteString(s.Line)
+	sb.WriteByte('\n')
+	for _, r := range s.Line[:s.Start] {
+		if r == '\t' {
+			sb.WriteByte('\t')
+		} else {
+			sb.WriteByte(' ')
+		}
+	}
+	sb.WriteByte('^')
+	if n := utf8.RuneCountInString(s.Line[s.Start:s.End]); n > 1 {
+		sb.WriteString(strings.Repeat("~", n-1))
+	}
+	return sb.String()
+}
+
+// Error implements the error interface.
+func (e Error) Error() string {
+	msg := e.Msg
+	if e.Severity != SeverityError {
+		msg = e.Severity.String() + ": " + msg
+	}
+	if e.Pos.Filename != "" || e.Pos.IsValid() {
+		return e.Pos.String() + ": " + msg
+	}
+	return msg
+}
+
+// String returns the note in the same form as Error.Error, with the
+// message prefixed by "note: ".
+func (n Note) String() string {
+	if n.Pos.Filename != "" || n.Pos.IsValid() {
+		return n.Pos.String() + ": note: " + n.Msg
+	}
+	return "note: " + n.Msg
+}
+
+// ErrorList is a list of *Errors.
+// The zero value for an ErrorList is an empty ErrorList ready to use.
+type ErrorList []*Error
+
+// Add adds an Error with given position and error message to an ErrorList.
+// The error has severity SeverityError and code CodeSyntax.
+func (p *ErrorList) Add(pos token.Position, msg string) {
+	*p = append(*p, &Error{Pos: pos, Msg: msg, Code: CodeSyntax})
+}
+
+// Reset resets an ErrorList to no errors.
+func (p *ErrorList) Reset() { *p = (*p)[0:0] }
+
+// ErrorList implements the sort Interface.
+func (p ErrorList) Len() int      { return len(p) }
+func (p ErrorList) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+func (p ErrorList) Less(i, j int) bool {
+	e := &p[i].Pos
+	f := &p[j].Pos
+	if e.Filename != f.Filename {
+		return e.Filename < f.Filename
+	}
+	if e.Line != f.Line {
+		return e.Line < f.Line
+	}
+	if e.Column != f.Column {
+		return e.Column < f.Column
+	}
+	return p[i].Msg < p[j].Msg
+}
+
+// Sort sorts an ErrorList by position; errors at the same position
+// are sorted by message.
+func (p ErrorList) Sort() {
+	sort.Sort(p)
+}
+
+// RemoveDuplicates sorts an ErrorList and removes all but the first of
+// the entries with the same position and message.
+func (p *ErrorList) RemoveDuplicates() {
+	sort.Sort(*p)
+	var last *Error // the last entry kept
+	i := 0
+	for _, e := range *p {
+		if last == nil || e.Pos != last.Pos || e.Msg != last.Msg {
+			last = e
+			(*p)[i] = e
+			i++
+		}
+	}
+	*p = (*p)[0:i]
+}
+
+// An ErrorList implements the error interface.
+func (p ErrorList) Error() string {
+	switch len(p) {
+	case 0:
+		return "no errors"
+	case 1:
+		return p[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", p[0], len(p)-1)
+}
+
+// Errors returns the entries of the list with severity SeverityError.
+func (p ErrorList) Errors() ErrorList {
+	var errs ErrorList
+	for _, e := range p {
+		if e.Severity == SeverityError {
+			errs = append(errs, e)
+		}
+	}
+	return errs
+}
+
+// PromoteWarnings raises the severity of every warning in the list to
+// SeverityError.
+func (p ErrorList) PromoteWarnings() {
+	for _, e := range p {
+		if e.Severity == SeverityWarning {
+			e.Severity = SeverityError
+		}
+	}
+}
+
+// Err returns an error equivalent to this error list.
+// If the list is empty, Err returns nil.
+func (p ErrorList) Err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return p
+}
+
+// PrintError is a utility function that prints a list of errors to w,
+// one error per line, each followed by the source snippet it refers to
+// and by its notes, if the err parameter is an ErrorList. Otherwise it
+// prints the err string. If err is nil, PrintError does nothing.
+func PrintError(w io.Writer, err error) {
+	if err == nil {
+		return
+	}
+	list, ok := err.(ErrorList)
+	if !ok {
+		fmt.Fprintf(w, "%s\n", err)
+		return
+	}
+	for _, e := range list {
+		fmt.Fprintf(w, "%s\n", e)
+		if e.Snippet != nil {
+			fmt.Fprintf(w, "%s\n", e.Snippet)
+		}
+		for _, n := range e.Notes {
+			fmt.Fprintf(w, "%s\n", n)
+			if n.Snippet != nil {
+				fmt.Fprintf(w, "%s\n", n.Snippet)
+			}
+		}
+	}
+}
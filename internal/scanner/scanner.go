@@ -1,29 +1,63 @@
 // Package scanner implements a lexical scanner for a proto file
-//
 package scanner
 
 import (
+	"fmt"
+	"unicode/utf8"
+
 	"rogchap.com/protoparser/internal/token"
 )
 
+// An ErrorHandler may be provided to Scanner.Init. If a syntax error is
+// encountered and a handler was installed, the handler is called with a
+// position and an error message. The position points to the beginning of
+// the offending token.
+type ErrorHandler func(pos token.Position, msg string)
+
+// Mode controls scanner behavior
+type Mode uint
+
+const (
+	// ScanComments returns comments as COMMENT tokens rather than skipping them
+	ScanComments Mode = 1 << iota
+)
+
 // Scanner is the data structure for a lexer
 type Scanner struct {
-	src []byte
+	file *token.File  // source file handle
+	src  []byte       // source
+	err  ErrorHandler // error reporting; or nil
+	mode Mode         // scanning mode
 
 	// scanning state
-	ch       rune // current char
-	offset   int  // char offset
-	rdOffset int  // reading offset (position after the current char)
+	ch         rune // current char
+	offset     int  // char offset
+	rdOffset   int  // reading offset (position after the current char)
+	lineOffset int  // current line offset
+
+	// public state - ok to modify
+	ErrorCount int // number of errors encountered
 }
 
 const bom = 0xFEFF // byte order mark, only permitted as very first character
 
-// Init initiates a Scanner
-func (s *Scanner) Init(src []byte) {
+// Init initiates a Scanner to tokenize src. Line information is recorded
+// in file, which must have been created for a source of len(src) bytes.
+// Errors are reported to err, which may be nil.
+func (s *Scanner) Init(file *token.File, src []byte, err ErrorHandler, mode Mode) {
+	if file.Size() != len(src) {
+		panic(fmt.Sprintf("file size (%d) does not match src len (%d)", file.Size(), len(src)))
+	}
+	s.file = file
 	s.src = src
+	s.err = err
+	s.mode = mode
+
 	s.ch = ' '
 	s.offset = 0
 	s.rdOffset = 0
+	s.lineOffset = 0
+	s.ErrorCount = 0
 
 	s.next()
 	if s.ch == bom {
@@ -36,35 +70,81 @@ func (s *Scanner) Init(src []byte) {
 func (s *Scanner) next() {
 	if s.rdOffset < len(s.src) {
 		s.offset = s.rdOffset
-		s.ch = rune(s.src[s.rdOffset])
-		s.rdOffset += 1
+		if s.ch == '\n' {
+			s.lineOffset = s.offset
+			s.file.AddLine(s.offset)
+		}
+		r, w := rune(s.src[s.rdOffset]), 1
+		if r >= utf8.RuneSelf {
+			r, w = utf8.DecodeRune(s.src[s.rdOffset:])
+		}
+		s.rdOffset += w
+		s.ch = r
 		return
 	}
 	s.offset = len(s.src)
+	if s.ch == '\n' {
+		s.lineOffset = s.offset
+		s.file.AddLine(s.offset)
+	}
 	s.ch = -1 // eof
 }
 
+// peek returns the byte following the most recently read character without
+// advancing the scanner. If the scanner is at EOF, peek returns 0.
+func (s *Scanner) peek() byte {
+	if s.rdOffset < len(s.src) {
+		return s.src[s.rdOffset]
+	}
+	return 0
+}
+
+func (s *Scanner) error(offs int, msg string) {
+	if s.err != nil {
+		s.err(s.file.Position(s.file.Pos(offs)), msg)
+	}
+	s.ErrorCount++
+}
+
 func (s *Scanner) skipWhitespace() {
-	for s.ch == ' ' || s.ch == '\t' || s.ch == '\n' || s.ch == '\r' {
+	for s.ch == ' ' || s.ch == '\t' || s.ch == '\n' || s.ch == '\r' || s.ch == '\v' || s.ch == '\f' {
 		s.next()
 	}
 }
 
-// Scan will scan the next rune and consume any literals
-func (s *Scanner) Scan() (tok token.Token, lit string) {
+// Scan scans the next token and returns the token position, the token,
+// and its literal string if applicable. The source end is indicated by
+// token.EOF.
+//
+// If the returned token is a literal (token.IDENT, token.INT, token.FLOAT,
+// token.STRING) or token.COMMENT, the literal string has the corresponding
+// value; strings keep their quotes and escapes as written.
+//
+// Comments are skipped unless the ScanComments mode is set.
+func (s *Scanner) Scan() (pos token.Pos, tok token.Token, lit string) {
+scanAgain:
 	s.skipWhitespace()
 
+	// current token start
+	pos = s.file.Pos(s.offset)
+
 	switch ch := s.ch; {
-	case isLetter(ch):
+	case isLetter(ch) || ch == '_': // protoc allows a leading underscore
 		lit = s.scanIdentifier()
 		tok = token.Lookup(lit)
-	case isDigit(ch):
+	case isDigit(ch) || ch == '.' && isDigit(rune(s.peek())):
 		tok, lit = s.scanNumber()
 	default:
 		s.next() // always make progress
 		switch ch {
 		case '=':
 			tok = token.ASSIGN
+		case ':':
+			tok = token.COLON
+		case '-':
+			tok = token.MINUS
+		case '+':
+			tok = token.PLUS
 		case '"', '\'':
 			tok = token.STRING
 			lit = s.scanString()
@@ -73,19 +153,39 @@ func (s *Scanner) Scan() (tok token.Token, lit string) {
 		case '.':
 			tok = token.DOT
 			lit = string(ch)
+		case '(':
+			tok = token.LPAREN
+		case ')':
+			tok = token.RPAREN
 		case '{':
 			tok = token.LBRACE
 		case '}':
 			tok = token.RBRACE
+		case '[':
+			tok = token.LBRACK
+		case ']':
+			tok = token.RBRACK
 		case '<':
 			tok = token.LANGLE
 		case '>':
 			tok = token.RANGLE
 		case ',':
 			tok = token.COMMA
+		case '/':
+			if s.ch == '/' || s.ch == '*' {
+				comment := s.scanComment()
+				if s.mode&ScanComments == 0 {
+					goto scanAgain
+				}
+				tok = token.COMMENT
+				lit = comment
+				break
+			}
+			tok = token.SLASH
 		case -1:
 			tok = token.EOF
 		default:
+			s.error(s.file.Offset(pos), fmt.Sprintf("illegal character %#U", ch))
 			tok = token.ILLEGAL
 			lit = string(ch)
 		}
@@ -93,6 +193,17 @@ func (s *Scanner) Scan() (tok token.Token, lit string) {
 	return
 }
 
+// Peek returns the next token without consuming it. Comments are skipped,
+// and errors in the token are not reported until it is scanned.
+func (s *Scanner) Peek() token.Token {
+	saved := *s
+	s.err = nil
+	s.mode &^= ScanComments
+	_, tok, _ := s.Scan()
+	*s = saved
+	return tok
+}
+
 func isLetter(ch rune) bool {
 	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z'
 }
@@ -101,6 +212,14 @@ func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
+func isOctal(ch rune) bool {
+	return '0' <= ch && ch <= '7'
+}
+
+func isHex(ch rune) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
 func (s *Scanner) scanIdentifier() string {
 	offs := s.offset
 	for isLetter(s.ch) || isDigit(s.ch) || s.ch == '_' {
@@ -109,33 +228,149 @@ func (s *Scanner) scanIdentifier() string {
 	return string(s.src[offs:s.offset])
 }
 
+func (s *Scanner) scanComment() string {
+	// initial '/' already consumed; s.ch == '/' || s.ch == '*'
+	offs := s.offset - 1 // position of initial '/'
+
+	if s.ch == '/' {
+		//-style comment
+		for s.ch != '\n' && s.ch >= 0 {
+			s.next()
+		}
+		return string(s.src[offs:s.offset])
+	}
+
+	/*-style comment */
+	s.next()
+	for s.ch >= 0 {
+		ch := s.ch
+		s.next()
+		if ch == '*' && s.ch == '/' {
+			s.next()
+			return string(s.src[offs:s.offset])
+		}
+	}
+	s.error(offs, "comment not terminated")
+	return string(s.src[offs:s.offset])
+}
+
 func (s *Scanner) scanString() string {
 	offs := s.offset - 1 // opening quote already consumed
 	quote := rune(s.src[offs])
 	for {
 		ch := s.ch
 		if ch == '\n' || ch < 0 {
-			// TODO: deal with errors: string literal not terminated
+			s.error(offs, "string literal not terminated")
 			break
 		}
 		s.next()
 		if ch == quote {
 			break
 		}
+		if ch == '\\' {
+			s.scanEscape()
+		}
 	}
 	return string(s.src[offs:s.offset])
 }
 
+// scanEscape validates an escape sequence; the leading backslash has
+// already been consumed.
+func (s *Scanner) scanEscape() {
+	offs := s.offset - 1
+
+	var n int
+	var valid func(rune) bool
+	switch s.ch {
+	case 'a', 'b', 'f', 'n', 'r', 't', 'v', '\\', '\'', '"', '?':
+		s.next()
+		return
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		n, valid = 3, isOctal
+	case 'x', 'X':
+		s.next()
+		n, valid = 2, isHex
+		if !isHex(s.ch) {
+			s.error(offs, "illegal character in escape sequence")
+			return
+		}
+	case 'u':
+		s.next()
+		n, valid = 4, isHex
+	case 'U':
+		s.next()
+		n, valid = 8, isHex
+	default:
+		if s.ch < 0 || s.ch == '\n' {
+			return // string literal not terminated; reported by the caller
+		}
+		s.error(offs, "unknown escape sequence")
+		return
+	}
+
+	if n == 3 || n == 2 {
+		// octal and hex escapes are variable length
+		for ; n > 0 && valid(s.ch); n-- {
+			s.next()
+		}
+		return
+	}
+	for ; n > 0; n-- {
+		if !valid(s.ch) {
+			s.error(offs, "illegal character in escape sequence")
+			return
+		}
+		s.next()
+	}
+}
+
 func (s *Scanner) scanNumber() (token.Token, string) {
 	offs := s.offset
 	tok := token.INT
-	s.scanMantissa()
-	if s.ch == '.' {
-		tok = token.FLOAT
+
+	if s.ch == '0' && (s.peek() == 'x' || s.peek() == 'X') {
+		// hexadecimal int
 		s.next()
+		s.next()
+		hoffs := s.offset
+		for isHex(s.ch) {
+			s.next()
+		}
+		if s.offset == hoffs {
+			s.error(offs, "illegal hexadecimal number")
+		}
+	} else {
+		octal := s.ch == '0'
 		s.scanMantissa()
+		if s.ch == '.' {
+			tok = token.FLOAT
+			s.next()
+			s.scanMantissa()
+		}
+		if s.ch == 'e' || s.ch == 'E' {
+			tok = token.FLOAT
+			s.next()
+			if s.ch == '-' || s.ch == '+' {
+				s.next()
+			}
+			if !isDigit(s.ch) {
+				s.error(offs, "exponent has no digits")
+			}
+			s.scanMantissa()
+		}
+		if tok == token.INT && octal {
+			for _, c := range s.src[offs:s.offset] {
+				if !isOctal(rune(c)) {
+					s.error(offs, "illegal octal number")
+					break
+				}
+			}
+		}
+	}
+
+	if isLetter(s.ch) || s.ch == '_' {
+		s.error(s.offset, "need space between number and identifier")
 	}
-	// TODO: Deal with octal and hex numbers
 	return tok, string(s.src[offs:s.offset])
 }
 
@@ -1,6 +1,8 @@
 package scanner_test
 
 import (
+	"bytes"
+	"errors"
 	"testing"
 
 	"rogchap.com/protoparser/internal/scanner"
@@ -21,7 +23,7 @@ var tokens = [...]el{
 	{token.SEMICOLON, ";"},
 	{token.STRING, "'foo bar'"},
 	{token.STRING, `"foo bar"`},
-	{token.ILLEGAL, "_"},
+	{token.ILLEGAL, "@"},
 	{token.EOF, ""},
 }
 
@@ -38,11 +40,12 @@ var source = func() []byte {
 
 func TestScan(t *testing.T) {
 	t.Parallel()
+	src := source()
 	var s scanner.Scanner
-	s.Init(source())
+	s.Init(token.NewFile("", len(src)), src, nil, 0)
 
 	for _, e := range tokens {
-		tok, lit := s.Scan()
+		_, tok, lit := s.Scan()
 
 		// check token
 		if tok != e.tok {
@@ -50,3 +53,65 @@ func TestScan(t *testing.T) {
 		}
 	}
 }
+
+// Identifiers may start with an underscore, as they may in protoc, whose
+// tokenizer accepts [A-Za-z_][A-Za-z0-9_]*.
+func TestScanLeadingUnderscore(t *testing.T) {
+	t.Parallel()
+	for _, src := range []string{"_", "_foo_bar", "__x", "_1"} {
+		var s scanner.Scanner
+		s.Init(token.NewFile("", len(src)), []byte(src), nil, 0)
+		if _, tok, lit := s.Scan(); tok != token.IDENT || lit != src {
+			t.Errorf("got %s %q, want IDENT %q", tok, lit, src)
+		}
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	t.Parallel()
+	src := []byte("message Foo {\n\tstring é_name = 1;\r\n}\n")
+	file := token.NewFile("", len(src))
+	file.AddLine(14)
+	pos := file.Position(file.Pos(22)) // é_name
+
+	s := scanner.NewSnippet(src, pos, len("é_name"))
+	want := "\tstring é_name = 1;\n\t       ^~~~~~"
+	if got := s.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// spans are cut short at the end of the line
+	s = scanner.NewSnippet(src, pos, 100)
+	want = "\tstring é_name = 1;\n\t       ^~~~~~~~~~~"
+	if got := s.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPrintError(t *testing.T) {
+	t.Parallel()
+	src := []byte("message Foo {\n\tint32 a = ;\n}\n")
+	file := token.NewFile("test.proto", len(src))
+	file.AddLine(14)
+	pos := file.Position(file.Pos(25))
+
+	var list scanner.ErrorList
+	list.Add(pos, "expected field number, found ';'")
+	list[0].Snippet = scanner.NewSnippet(src, pos, 1)
+
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{errors.New("some error"), "some error\n"},
+		{list, "test.proto:2:12: expected field number, found ';'\n\tint32 a = ;\n\t          ^\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		scanner.PrintError(&buf, tt.err)
+		if got := buf.String(); got != tt.want {
+			t.Errorf("PrintError(%v): got %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package token
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Pos is a compact encoding of a source position within a File.
+// It is the byte offset of the position plus one, so that the
+// zero value NoPos can be used to signal "no position".
+type Pos int
+
+// NoPos is the zero value for Pos; there is no file and line information
+// associated with it.
+const NoPos Pos = 0
+
+// IsValid reports whether the position is valid.
+func (p Pos) IsValid() bool {
+	return p != NoPos
+}
+
+// Position describes an arbitrary source position
+// including the file, line, and column location.
+// A Position is valid if the line number is > 0.
+type Position struct {
+	Filename string // filename, if any
+	Offset   int    // offset, starting at 0
+	Line     int    // line number, starting at 1
+	Column   int    // column number, starting at 1 (byte count)
+}
+
+// IsValid reports whether the position is valid.
+func (pos *Position) IsValid() bool { return pos.Line > 0 }
+
+// String returns a string in one of several forms:
+//
+//	file:line:column    valid position with file name
+//	line:column         valid position without file name
+//	file                invalid position with file name
+//	-                   invalid position without file name
+func (pos Position) String() string {
+	s := pos.Filename
+	if pos.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
+}
+
+// File holds the line table of a single source file and maps
+// Pos values to Positions.
+type File struct {
+	name  string
+	size  int
+	lines []int // lines contains the offset of the first character for each line (the first entry is always 0)
+}
+
+// NewFile returns a File for a source of the given name and size.
+func NewFile(filename string, size int) *File {
+	return &File{name: filename, size: size, lines: []int{0}}
+}
+
+// Name returns the file name of file f.
+func (f *File) Name() string {
+	return f.name
+}
+
+// Size returns the size of file f.
+func (f *File) Size() int {
+	return f.size
+}
+
+// LineCount returns the number of lines in file f.
+func (f *File) LineCount() int {
+	return len(f.lines)
+}
+
+// AddLine adds the line offset for a new line.
+// The line offset must be larger than the offset for the previous line
+// and smaller than the file size; otherwise the line offset is ignored.
+func (f *File) AddLine(offset int) {
+	if i := len(f.lines); (i == 0 || f.lines[i-1] < offset) && offset < f.size {
+		f.lines = append(f.lines, offset)
+	}
+}
+
+// Pos returns the Pos value for the given file offset.
+func (f *File) Pos(offset int) Pos {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > f.size {
+		offset = f.size
+	}
+	return Pos(offset + 1)
+}
+
+// Offset returns the offset for the given file position p.
+func (f *File) Offset(p Pos) int {
+	if !p.IsValid() {
+		return 0
+	}
+	return int(p) - 1
+}
+
+// Line returns the line number for the given file position p.
+func (f *File) Line(p Pos) int {
+	return f.Position(p).Line
+}
+
+// Position returns the Position value for the given file position p.
+// Calling f.Position(NoPos) returns the zero Position.
+func (f *File) Position(p Pos) (pos Position) {
+	if !p.IsValid() {
+		return
+	}
+	offset := f.Offset(p)
+	pos.Filename = f.name
+	pos.Offset = offset
+	i := sort.Search(len(f.lines), func(i int) bool { return f.lines[i] > offset }) - 1
+	pos.Line = i + 1
+	pos.Column = offset - f.lines[i] + 1
+	return
+}
@@ -0,0 +1,168 @@
+package plugin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// Files are the files that plugins generate into an output directory, as
+// protoc keeps them until every plugin has run. The zero value holds no
+// files.
+//
+// A plugin may insert content into a file generated earlier, by itself or
+// by another plugin writing to the same directory, at an insertion point:
+// a line of the file that contains @@protoc_insertion_point(NAME).
+type Files struct {
+	names   []string // in the order they were generated
+	content map[string][]byte
+}
+
+// Add adds the files of resp, inserting the content of those with an
+// insertion point into the files generated earlier, as protoc does. The
+// content of a file without a name is added to the file before it.
+//
+// If an error is returned, some of the files of resp may have been added.
+func (fs *Files) Add(resp *pluginpb.CodeGeneratorResponse) error {
+	if fs.content == nil {
+		fs.content = make(map[string][]byte)
+	}
+
+	// A file without a name continues the one before it, so the files are
+	// only added once their content is complete.
+	var (
+		name, point string
+		content     []byte
+		started     bool
+	)
+	for _, f := range resp.File {
+		if f.GetName() == "" {
+			if !started {
+				return errors.New("first file chunk returned by plugin did not have a file name")
+			}
+			content = append(content, f.GetContent()...)
+			continue
+		}
+		if started {
+			if err := fs.add(name, point, content); err != nil {
+				return err
+			}
+		}
+		name, point, content, started = f.GetName(), f.GetInsertionPoint(), []byte(f.GetContent()), true
+	}
+	if started {
+		return fs.add(name, point, content)
+	}
+	return nil
+}
+
+// add adds the file name with content, or inserts content into it at the
+// insertion point point, if that is not empty.
+func (fs *Files) add(name, point string, content []byte) error {
+	if !validName(name) {
+		return fmt.Errorf("%s: invalid file name: it must be a relative, slash-separated path within the output directory", name)
+	}
+	target, ok := fs.content[name]
+	if point == "" {
+		if ok {
+			return fmt.Errorf("%s: tried to write the same file twice", name)
+		}
+		fs.names = append(fs.names, name)
+		fs.content[name] = content
+		return nil
+	}
+	if !ok {
+		return fmt.Errorf("%s: tried to insert into file that doesn't exist", name)
+	}
+	res, ok := insert(target, point, content)
+	if !ok {
+		return fmt.Errorf("%s: insertion point %q not found", name, point)
+	}
+	fs.content[name] = res
+	return nil
+}
+
+// validName reports whether name is a clean, relative, slash-separated
+// path that does not leave the directory it is relative to.
+func validName(name string) bool {
+	return name != "" && path.Clean(name) == name && !path.IsAbs(name) &&
+		name != ".." && !strings.HasPrefix(name, "../") && !strings.Contains(name, "\\")
+}
+
+// insert inserts content into target at the insertion point named point,
+// returning the result, or false if target has no such insertion point.
+//
+// As protoc inserts it, content is inserted before the line holding the
+// insertion point, indented as that line is, so that content inserted at
+// the same point keeps its order. If the insertion point is within a
+// comment, as in /* @@protoc_insertion_point(NAME) */, content is
+// inserted just before the comment instead.
+func insert(target []byte, point string, content []byte) ([]byte, bool) {
+	magic := "@@protoc_insertion_point(" + point + ")"
+	pos := bytes.Index(target, []byte(magic))
+	if pos < 0 {
+		return nil, false
+	}
+	if pos > 3 && bytes.Equal(target[pos-3:pos-1], []byte("/*")) {
+		pos -= 3
+	} else {
+		pos = bytes.LastIndexByte(target[:pos], '\n') + 1
+	}
+	end := pos
+	for end < len(target) && (target[end] == ' ' || target[end] == '\t') {
+		end++
+	}
+	indent := target[pos:end]
+
+	var ins []byte
+	if len(indent) == 0 {
+		ins = content
+	} else {
+		if len(content) > 0 && content[len(content)-1] != '\n' {
+			content = append(content[:len(content):len(content)], '\n')
+		}
+		for len(content) > 0 {
+			i := bytes.IndexByte(content, '\n') + 1
+			ins = append(ins, indent...)
+			ins = append(ins, content[:i]...)
+			content = content[i:]
+		}
+	}
+
+	res := make([]byte, 0, len(target)+len(ins))
+	res = append(res, target[:pos]...)
+	res = append(res, ins...)
+	return append(res, target[pos:]...), true
+}
+
+// Names returns the names of the files, in the order they were generated.
+func (fs *Files) Names() []string {
+	return append([]string(nil), fs.names...)
+}
+
+// Content returns the content of the file name, or nil if there is no
+// such file.
+func (fs *Files) Content(name string) []byte {
+	return fs.content[name]
+}
+
+// WriteDir writes the files to the directory dir, creating the
+// directories that they are in.
+func (fs *Files) WriteDir(dir string) error {
+	for _, name := range fs.names {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filename, fs.content[name], 0666); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+// Package plugin runs protoc plugins, such as protoc-gen-go, on parsed
+// files, as protoc runs them.
+//
+// A plugin is sent a CodeGeneratorRequest holding the files to generate
+// code for, and every file they import, and replies with a
+// CodeGeneratorResponse holding the files it generated:
+//
+//	p := protoparser.Parser{Mode: protoparser.IncludeSourceInfo | protoparser.InterpretOptions}
+//	set, err := p.ParseFiles("foo.proto")
+//	...
+//	req, err := plugin.NewRequest(set, "paths=source_relative", "foo.proto")
+//	...
+//	resp, err := (&plugin.Plugin{Name: "go"}).Run(ctx, req)
+//	...
+//	var out plugin.Files
+//	if err := out.Add(resp); err != nil {
+//		...
+//	}
+//	err = out.WriteDir("gen")
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// NewRequest returns the request that protoc sends a plugin to generate
+// code for the files filenames of set, with the parameter parameter. The
+// files are named by their import names, and set must hold every file
+// they import, as the sets that protoparser returns do.
+//
+// The request holds the files filenames and those they import, each after
+// the files it imports, as protoc sends them. They should have been parsed
+// in the IncludeSourceInfo mode, for the plugins that copy comments, and
+// the InterpretOptions mode, for those that read custom options.
+func NewRequest(set *descriptorpb.FileDescriptorSet, parameter string, filenames ...string) (*pluginpb.CodeGeneratorRequest, error) {
+	files := make(map[string]*descriptorpb.FileDescriptorProto, len(set.File))
+	for _, fd := range set.File {
+		files[fd.GetName()] = fd
+	}
+
+	req := &pluginpb.CodeGeneratorRequest{FileToGenerate: filenames}
+	if parameter != "" {
+		req.Parameter = &parameter
+	}
+	added := make(map[string]bool)
+	var add func(name string) error
+	add = func(name string) error {
+		if added[name] {
+			return nil
+		}
+		added[name] = true
+		fd := files[name]
+		if fd == nil {
+			return fmt.Errorf("%s: file not found in the descriptor set", name)
+		}
+		for _, dep := range fd.Dependency {
+			if err := add(dep); err != nil {
+				return err
+			}
+		}
+		if fd.GetSyntax() == "proto2" {
+			// protoc only records the syntax of proto3 files
+			fd = proto.Clone(fd).(*descriptorpb.FileDescriptorProto)
+			fd.Syntax = nil
+		}
+		req.ProtoFile = append(req.ProtoFile, fd)
+		return nil
+	}
+	for _, name := range filenames {
+		if err := add(name); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// A Plugin is a protoc plugin: an executable that reads a
+// CodeGeneratorRequest from its standard input and writes a
+// CodeGeneratorResponse to its standard output.
+type Plugin struct {
+	// Name is the name of the plugin, as in protoc's --NAME_out flag: the
+	// plugin protoc-gen-go is named "go".
+	Name string
+
+	// Path is the path of the plugin's executable. If empty, the
+	// executable protoc-gen-NAME is looked for in the directories named
+	// by the PATH environment variable, as protoc looks for it.
+	Path string
+
+	// Stderr is the standard error of the plugin. If nil, it is that of
+	// the current process.
+	Stderr io.Writer
+}
+
+// program returns the name of the plugin's program, for errors.
+func (p *Plugin) program() string {
+	if p.Name == "" {
+		return filepath.Base(p.Path)
+	}
+	return "protoc-gen-" + p.Name
+}
+
+// Run runs the plugin, sending it req, and returns its response.
+//
+// An error is returned if the plugin fails, or reports an error in its
+// response, or if the files it is to generate use features that it does
+// not declare support for, as protoc checks.
+func (p *Plugin) Run(ctx context.Context, req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+	path := p.Path
+	if path == "" {
+		var err error
+		if path, err = exec.LookPath(p.program()); err != nil {
+			return nil, fmt.Errorf("%s: program not found or is not executable", p.program())
+		}
+	}
+	in, err := proto.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &out
+	cmd.Stderr = p.Stderr
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Run(); err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) {
+			return nil, fmt.Errorf("%s: plugin failed with status code %d", p.program(), exit.ExitCode())
+		}
+		return nil, fmt.Errorf("%s: %v", p.program(), err)
+	}
+
+	resp := new(pluginpb.CodeGeneratorResponse)
+	if err := proto.Unmarshal(out.Bytes(), resp); err != nil {
+		return nil, fmt.Errorf("%s: plugin output is unparseable: %v", p.program(), err)
+	}
+	if resp.Error != nil {
+		return nil, fmt.Errorf("%s: %s", p.program(), resp.GetError())
+	}
+	if err := p.checkFeatures(req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// checkFeatures checks that the plugin supports the features that the
+// files of req it generated code for use.
+func (p *Plugin) checkFeatures(req *pluginpb.CodeGeneratorRequest, resp *pluginpb.CodeGeneratorResponse) error {
+	supported := resp.GetSupportedFeatures()
+	if supported&uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL) != 0 {
+		return nil
+	}
+	generate := make(map[string]bool, len(req.FileToGenerate))
+	for _, name := range req.FileToGenerate {
+		generate[name] = true
+	}
+	for _, fd := range req.ProtoFile {
+		if generate[fd.GetName()] && hasProto3Optional(fd.MessageType, fd.Extension) {
+			return fmt.Errorf("%s is a proto3 file that contains optional fields, but code generator %s hasn't been updated to support optional fields in proto3",
+				fd.GetName(), p.program())
+		}
+	}
+	return nil
+}
+
+// hasProto3Optional reports whether any of the messages msgs, or the
+// extensions exts, declares a proto3 optional field.
+func hasProto3Optional(msgs []*descriptorpb.DescriptorProto, exts []*descriptorpb.FieldDescriptorProto) bool {
+	for _, f := range exts {
+		if f.GetProto3Optional() {
+			return true
+		}
+	}
+	for _, m := range msgs {
+		for _, f := range m.Field {
+			if f.GetProto3Optional() {
+				return true
+			}
+		}
+		if hasProto3Optional(m.NestedType, m.Extension) {
+			return true
+		}
+	}
+	return false
+}
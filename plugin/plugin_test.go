@@ -0,0 +1,253 @@
+package plugin_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+
+	"rogchap.com/protoparser"
+	"rogchap.com/protoparser/plugin"
+)
+
+// TestMain runs the test binary as a plugin when the tests run it so.
+func TestMain(m *testing.M) {
+	if os.Getenv("PROTOPARSER_TEST_PLUGIN") != "" {
+		testPlugin()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// testPlugin generates a file for each file to generate, holding its
+// name and the parameter, or fails as the parameter directs.
+func testPlugin() {
+	in, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		os.Exit(2)
+	}
+	req := new(pluginpb.CodeGeneratorRequest)
+	if err := proto.Unmarshal(in, req); err != nil {
+		os.Exit(2)
+	}
+
+	resp := new(pluginpb.CodeGeneratorResponse)
+	switch req.GetParameter() {
+	case "exit":
+		os.Exit(3)
+	case "error":
+		resp.Error = proto.String("bad parameter")
+	case "old":
+		// supports no features
+	default:
+		resp.SupportedFeatures = proto.Uint64(uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL))
+	}
+	for _, name := range req.FileToGenerate {
+		resp.File = append(resp.File, &pluginpb.CodeGeneratorResponse_File{
+			Name:    proto.String(strings.TrimSuffix(name, ".proto") + ".txt"),
+			Content: proto.String(name + " " + req.GetParameter() + "\n"),
+		})
+	}
+	out, err := proto.Marshal(resp)
+	if err != nil {
+		os.Exit(2)
+	}
+	os.Stdout.Write(out)
+}
+
+func parseSet(t *testing.T) *descriptorpb.FileDescriptorSet {
+	t.Helper()
+	fsys := fstest.MapFS{
+		"a.proto": {Data: []byte("syntax = \"proto3\";\nimport \"b.proto\";\nmessage A { B b = 1; optional int32 n = 2; }\n")},
+		"b.proto": {Data: []byte("syntax = \"proto2\";\nimport \"c.proto\";\nmessage B { optional C c = 1; }\n")},
+		"c.proto": {Data: []byte("syntax = \"proto3\";\nmessage C {}\n")},
+		"d.proto": {Data: []byte("syntax = \"proto3\";\nimport \"c.proto\";\nmessage D { C c = 1; }\n")},
+	}
+	set, err := protoparser.ParseFS(fsys, "d.proto", "a.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return set
+}
+
+func TestNewRequest(t *testing.T) {
+	set := parseSet(t)
+	req, err := plugin.NewRequest(set, "paths=source_relative", "a.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fd := range req.ProtoFile {
+		names = append(names, fd.GetName())
+	}
+	if diff := cmp.Diff([]string{"c.proto", "b.proto", "a.proto"}, names); diff != "" {
+		t.Errorf("files mismatch (-want +got):\n%s", diff)
+	}
+	if got, want := req.GetParameter(), "paths=source_relative"; got != want {
+		t.Errorf("got parameter %q, want %q", got, want)
+	}
+	if req.ProtoFile[1].Syntax != nil {
+		t.Errorf("got syntax %q for a proto2 file, want none", req.ProtoFile[1].GetSyntax())
+	}
+	for _, fd := range set.File {
+		if fd.GetName() == "b.proto" && fd.GetSyntax() != "proto2" {
+			t.Errorf("the set was changed")
+		}
+	}
+
+	if _, err := plugin.NewRequest(set, "", "missing.proto"); err == nil {
+		t.Error("got no error for a file not in the set")
+	}
+}
+
+func TestRun(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Setenv("PROTOPARSER_TEST_PLUGIN", "1")
+	set := parseSet(t)
+
+	tests := []struct {
+		param string
+		files []string
+		want  string // the content of the first file, or the error
+	}{
+		{param: "x=1", files: []string{"a.proto", "d.proto"}, want: "a.proto x=1\n"},
+		{param: "exit", files: []string{"d.proto"}, want: "protoc-gen-test: plugin failed with status code 3"},
+		{param: "error", files: []string{"d.proto"}, want: "protoc-gen-test: bad parameter"},
+		{param: "old", files: []string{"d.proto"}, want: "d.proto old\n"},
+		{
+			param: "old",
+			files: []string{"d.proto", "a.proto"},
+			want:  "a.proto is a proto3 file that contains optional fields, but code generator protoc-gen-test hasn't been updated to support optional fields in proto3",
+		},
+	}
+	for _, tt := range tests {
+		req, err := plugin.NewRequest(set, tt.param, tt.files...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := plugin.Plugin{Name: "test", Path: exe}
+		resp, err := p.Run(context.Background(), req)
+		var got string
+		if err != nil {
+			got = err.Error()
+		} else {
+			if len(resp.File) != len(tt.files) {
+				t.Errorf("%s: got %d files, want %d", tt.param, len(resp.File), len(tt.files))
+				continue
+			}
+			got = resp.File[0].GetContent()
+		}
+		if got != tt.want {
+			t.Errorf("%s %v: got %q, want %q", tt.param, tt.files, got, tt.want)
+		}
+	}
+
+	p := plugin.Plugin{Name: "missing-plugin-for-test"}
+	if _, err := p.Run(context.Background(), new(pluginpb.CodeGeneratorRequest)); err == nil {
+		t.Error("got no error for a missing plugin")
+	}
+}
+
+func file(name, point, content string) *pluginpb.CodeGeneratorResponse_File {
+	f := &pluginpb.CodeGeneratorResponse_File{Content: proto.String(content)}
+	if name != "" {
+		f.Name = proto.String(name)
+	}
+	if point != "" {
+		f.InsertionPoint = proto.String(point)
+	}
+	return f
+}
+
+func TestFilesAdd(t *testing.T) {
+	var fs plugin.Files
+	err := fs.Add(&pluginpb.CodeGeneratorResponse{File: []*pluginpb.CodeGeneratorResponse_File{
+		file("a/foo.go", "", "package foo\n\n"),
+		file("", "", "type T struct {\n\t// @@protoc_insertion_point(fields)\n}\n"),
+		file("", "", "var x = []int{ /* @@protoc_insertion_point(values) */ }\n"),
+		file("b.txt", "", "b\n"),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// as a second plugin would
+	err = fs.Add(&pluginpb.CodeGeneratorResponse{File: []*pluginpb.CodeGeneratorResponse_File{
+		file("a/foo.go", "fields", "A int\n"),
+		file("", "", "B int"),
+		file("a/foo.go", "fields", "C int\n"),
+		file("a/foo.go", "values", "1, "),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{"a/foo.go", "b.txt"}, fs.Names()); diff != "" {
+		t.Errorf("names mismatch (-want +got):\n%s", diff)
+	}
+	want := `package foo
+
+type T struct {
+	A int
+	B int
+	C int
+	// @@protoc_insertion_point(fields)
+}
+var x = []int{ 1, /* @@protoc_insertion_point(values) */ }
+`
+	if diff := cmp.Diff(want, string(fs.Content("a/foo.go"))); diff != "" {
+		t.Errorf("content mismatch (-want +got):\n%s", diff)
+	}
+
+	dir := t.TempDir()
+	if err := fs.WriteDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(dir + "/a/foo.go"); err != nil || string(b) != want {
+		t.Errorf("got written file %q, %v, want %q", b, err, want)
+	}
+}
+
+func TestFilesAddErrors(t *testing.T) {
+	tests := []struct {
+		files []*pluginpb.CodeGeneratorResponse_File
+		want  string
+	}{
+		{
+			[]*pluginpb.CodeGeneratorResponse_File{file("", "", "x")},
+			"first file chunk returned by plugin did not have a file name",
+		},
+		{
+			[]*pluginpb.CodeGeneratorResponse_File{file("a.go", "", "x"), file("a.go", "", "y")},
+			"a.go: tried to write the same file twice",
+		},
+		{
+			[]*pluginpb.CodeGeneratorResponse_File{file("a.go", "p", "x")},
+			"a.go: tried to insert into file that doesn't exist",
+		},
+		{
+			[]*pluginpb.CodeGeneratorResponse_File{file("a.go", "", "x"), file("a.go", "p", "y")},
+			`a.go: insertion point "p" not found`,
+		},
+		{
+			[]*pluginpb.CodeGeneratorResponse_File{file("../a.go", "", "x")},
+			"../a.go: invalid file name: it must be a relative, slash-separated path within the output directory",
+		},
+	}
+	for _, tt := range tests {
+		var fs plugin.Files
+		err := fs.Add(&pluginpb.CodeGeneratorResponse{File: tt.files})
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("got error %v, want %q", err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,529 @@
+package printer
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"rogchap.com/protoparser/internal/descriptor"
+)
+
+// maxFieldNumber is the largest field number, written as "max" in ranges.
+const maxFieldNumber = 536870911
+
+func (p *printer) file() {
+	fd := p.fd
+	if fd.GetSyntax() == "editions" {
+		// the parser does not support editions, so the file could not be
+		// parsed back
+		p.setError(fmt.Errorf("%s cannot be printed: editions are not supported", fd.GetName()))
+		return
+	}
+	p.decl(p.loc([]int32{descriptor.FileSyntaxTag}), "syntax = "+strconv.Quote(p.syntax())+";", false)
+	p.sep = true
+
+	if fd.Package != nil {
+		p.decl(p.loc([]int32{descriptor.FilePackageTag}), "package "+fd.GetPackage()+";", false)
+		p.sep = true
+	}
+
+	var imports []item
+	for i, dep := range fd.Dependency {
+		kind := ""
+		switch {
+		case contains(fd.PublicDependency, i):
+			kind = "public "
+		case contains(fd.WeakDependency, i):
+			kind = "weak "
+		}
+		text := "import " + kind + quote(dep) + ";"
+		loc := p.loc([]int32{descriptor.FileDependencyTag, int32(i)})
+		imports = append(imports, item{loc, func() { p.decl(loc, text, false) }})
+	}
+	p.items(imports)
+	p.sep = true
+
+	scope := fd.GetPackage()
+	p.items(p.optionItems(fd.Options, scope, []int32{descriptor.FileOptionsTag}))
+	p.sep = true
+
+	var items []item
+	implicit := implicitTypes(scope, fd.MessageType, fd.Extension)
+	for i, m := range fd.MessageType {
+		if implicit[i] {
+			continue
+		}
+		m, path := m, []int32{descriptor.FileMessageTag, int32(i)}
+		items = append(items, item{p.loc(path), func() { p.message(scope, m, path) }})
+	}
+	for i, e := range fd.EnumType {
+		e, path := e, []int32{descriptor.FileEnumTag, int32(i)}
+		items = append(items, item{p.loc(path), func() { p.enum(scope, e, path) }})
+	}
+	items = append(items, p.extendItems(scope, fd.Extension, []int32{descriptor.FileExtensionTag}, fd.MessageType, []int32{descriptor.FileMessageTag})...)
+	for i, s := range fd.Service {
+		s, path := s, []int32{descriptor.FileServiceTag, int32(i)}
+		items = append(items, item{p.loc(path), func() { p.service(scope, s, path) }})
+	}
+	p.items(items)
+}
+
+// syntax returns the syntax of the file, "proto2" if it is not set.
+func (p *printer) syntax() string {
+	if s := p.fd.GetSyntax(); s != "" {
+		return s
+	}
+	return "proto2"
+}
+
+// message writes the message m, declared in scope at path.
+func (p *printer) message(scope string, m *descriptorpb.DescriptorProto, path []int32) {
+	p.decl(p.loc(path), "message "+m.GetName()+" {", true)
+	p.messageBody(scope, m, path)
+	p.close()
+}
+
+// messageBody writes the declarations of the message m, declared in scope
+// at path.
+func (p *printer) messageBody(scope string, m *descriptorpb.DescriptorProto, path []int32) {
+	name := join(scope, m.GetName())
+	nestedPath := subpath(path, descriptor.MessageNestedTag)
+	items := p.optionItems(m.Options, scope, subpath(path, descriptor.MessageOptionsTag))
+
+	implicit := implicitTypes(name, m.NestedType, m.Field, m.Extension)
+	var nested []item
+	var nestedIndex []int
+	for i, n := range m.NestedType {
+		if implicit[i] {
+			continue
+		}
+		n, nPath := n, subpath(nestedPath, int32(i))
+		nested = append(nested, item{p.loc(nPath), func() { p.message(name, n, nPath) }})
+		nestedIndex = append(nestedIndex, i)
+	}
+	// flush adds the nested messages that come before the nth one. The
+	// messages that come before the entry message or group of a field are
+	// written before it, so that they keep their order when the file has
+	// no source information to order them by.
+	flush := func(n int) {
+		for len(nested) > 0 && nestedIndex[0] < n {
+			items = append(items, nested[0])
+			nested, nestedIndex = nested[1:], nestedIndex[1:]
+		}
+	}
+
+	oneofs := make(map[int32]bool)
+	for i, f := range m.Field {
+		f, fieldPath := f, subpath(path, descriptor.MessageFieldTag, int32(i))
+		if o := f.GetOneofIndex(); f.OneofIndex != nil && !f.GetProto3Optional() && int(o) < len(m.OneofDecl) {
+			// the oneof is written in place of its first field
+			if !oneofs[o] {
+				oneofs[o] = true
+				for _, g := range m.Field[i:] {
+					if g.OneofIndex != nil && g.GetOneofIndex() == o {
+						flush(implicitType(name, m.NestedType, g))
+					}
+				}
+				items = append(items, item{p.loc(subpath(path, descriptor.MessageOneofTag, o)), func() { p.oneof(name, m, o, path) }})
+			}
+			continue
+		}
+		flush(implicitType(name, m.NestedType, f))
+		items = append(items, item{p.loc(fieldPath), func() { p.field(name, f, fieldPath, m.NestedType, nestedPath, false) }})
+	}
+	items = append(items, nested...)
+
+	for i, e := range m.EnumType {
+		e, ePath := e, subpath(path, descriptor.MessageEnumTag, int32(i))
+		items = append(items, item{p.loc(ePath), func() { p.enum(name, e, ePath) }})
+	}
+
+	max := int32(maxFieldNumber)
+	if m.GetOptions().GetMessageSetWireFormat() {
+		max = math.MaxInt32 - 1
+	}
+	items = append(items, p.extensionRangeItems(name, m, path, max)...)
+	items = append(items, p.extendItems(name, m.Extension, subpath(path, descriptor.MessageExtensionTag), m.NestedType, nestedPath)...)
+
+	var ranges [][2]int32
+	for _, r := range m.ReservedRange {
+		ranges = append(ranges, [2]int32{r.GetStart(), r.GetEnd() - 1})
+	}
+	items = append(items, p.reservedItems(subpath(path, descriptor.MessageReservedRangeTag), ranges, max, subpath(path, descriptor.MessageReservedNameTag), m.ReservedName)...)
+
+	p.items(items)
+}
+
+// oneof writes the oneof at index in the message m, declared as scope at
+// msgPath, with its fields.
+func (p *printer) oneof(scope string, m *descriptorpb.DescriptorProto, index int32, msgPath []int32) {
+	path := subpath(msgPath, descriptor.MessageOneofTag, index)
+	o := m.OneofDecl[index]
+	p.decl(p.loc(path), "oneof "+o.GetName()+" {", true)
+	items := p.optionItems(o.Options, scope, subpath(path, descriptor.OneofOptionsTag))
+	nestedPath := subpath(msgPath, descriptor.MessageNestedTag)
+	for i, f := range m.Field {
+		if f.OneofIndex == nil || f.GetOneofIndex() != index || f.GetProto3Optional() {
+			continue
+		}
+		f, fieldPath := f, subpath(msgPath, descriptor.MessageFieldTag, int32(i))
+		items = append(items, item{p.loc(fieldPath), func() { p.field(scope, f, fieldPath, m.NestedType, nestedPath, true) }})
+	}
+	p.items(items)
+	p.close()
+}
+
+// field writes the field f, declared in scope at path. The entry messages
+// of map fields and the messages of groups are among nested, the messages
+// declared in scope, which are at nestedPath.
+func (p *printer) field(scope string, f *descriptorpb.FieldDescriptorProto, path []int32, nested []*descriptorpb.DescriptorProto, nestedPath []int32, inOneof bool) {
+	loc := p.loc(path)
+	number := strconv.Itoa(int(f.GetNumber()))
+	opts := func(inline bool) []compactOption { return p.fieldOptions(scope, f, path, inline) }
+
+	if i := mapEntry(scope, nested, f); i >= 0 {
+		entry := nested[i]
+		entryScope := join(scope, entry.GetName())
+		key, value := entryField(entry, 1), entryField(entry, 2)
+		if key != nil && value != nil {
+			typ := "map<" + p.fieldType(entryScope, key) + ", " + p.fieldType(entryScope, value) + ">"
+			p.decl(loc, p.withOptions(typ+" "+f.GetName()+cellMark+" = "+number, opts, ";"), false)
+			return
+		}
+	}
+
+	label := p.label(f, inOneof)
+	if i := groupType(scope, nested, f); i >= 0 {
+		g := nested[i]
+		// the comments of a group are those of its message
+		if gloc := p.loc(subpath(nestedPath, int32(i))); gloc != nil && loc != nil {
+			loc = &descriptorpb.SourceCodeInfo_Location{
+				Span:                    loc.Span,
+				LeadingComments:         gloc.LeadingComments,
+				TrailingComments:        gloc.TrailingComments,
+				LeadingDetachedComments: gloc.LeadingDetachedComments,
+			}
+		}
+		p.decl(loc, p.withOptions(label+"group "+g.GetName()+" = "+number, opts, " {"), true)
+		p.messageBody(scope, g, subpath(nestedPath, int32(i)))
+		p.close()
+		return
+	}
+	p.decl(loc, p.withOptions(label+p.fieldType(scope, f)+" "+f.GetName()+cellMark+" = "+number, opts, ";"), false)
+}
+
+// label returns the label of f, as written before its type.
+func (p *printer) label(f *descriptorpb.FieldDescriptorProto, inOneof bool) string {
+	switch {
+	case inOneof:
+		return ""
+	case f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED:
+		return "repeated "
+	case f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REQUIRED:
+		return "required "
+	case p.syntax() == "proto2", f.GetProto3Optional():
+		return "optional "
+	}
+	return ""
+}
+
+// fieldType returns the type of the field f, declared in scope.
+func (p *printer) fieldType(scope string, f *descriptorpb.FieldDescriptorProto) string {
+	if f.TypeName != nil {
+		return p.typeName(f.GetTypeName(), scope)
+	}
+	return strings.ToLower(strings.TrimPrefix(f.GetType().String(), "TYPE_"))
+}
+
+// fieldOptions returns the compact options of the field f, declared in
+// scope at path: the default value, the JSON name if it is not the default
+// one, and the options proper.
+func (p *printer) fieldOptions(scope string, f *descriptorpb.FieldDescriptorProto, path []int32, inline bool) []compactOption {
+	var opts []compactOption
+	if f.DefaultValue != nil {
+		opts = append(opts, compactOption{p.loc(subpath(path, descriptor.FieldDefaultTag)), "default = " + defaultValue(f)})
+	}
+	if f.JsonName != nil && f.GetJsonName() != descriptor.JSONCamelCase(f.GetName()) {
+		opts = append(opts, compactOption{p.loc(subpath(path, descriptor.FieldJSONNameTag)), "json_name = " + quote(f.GetJsonName())})
+	}
+	return append(opts, p.compactOptions(f.Options, scope, subpath(path, descriptor.FieldOptionsTag), inline)...)
+}
+
+// defaultValue returns the default value of f as written in its options.
+func defaultValue(f *descriptorpb.FieldDescriptorProto) string {
+	v := f.GetDefaultValue()
+	switch f.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING:
+		return quote(v)
+	case descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		return `"` + v + `"` // already escaped
+	}
+	return v
+}
+
+// implicitTypes returns the indexes of the messages among nested, which
+// are declared in scope, that are declared by the fields of the scope
+// rather than written out: the entries of map fields, and the messages of
+// groups.
+func implicitTypes(scope string, nested []*descriptorpb.DescriptorProto, fields ...[]*descriptorpb.FieldDescriptorProto) map[int]bool {
+	implicit := make(map[int]bool)
+	for _, fs := range fields {
+		for _, f := range fs {
+			if i := implicitType(scope, nested, f); i >= 0 {
+				implicit[i] = true
+			}
+		}
+	}
+	return implicit
+}
+
+// implicitType returns the index among nested of the entry message or the
+// group message of f, declared in scope, or -1 if f is neither a map field
+// nor a group.
+func implicitType(scope string, nested []*descriptorpb.DescriptorProto, f *descriptorpb.FieldDescriptorProto) int {
+	if i := mapEntry(scope, nested, f); i >= 0 {
+		return i
+	}
+	return groupType(scope, nested, f)
+}
+
+// mapEntry returns the index among nested of the entry message of the map
+// field f, declared in scope, or -1 if f is not a map field.
+func mapEntry(scope string, nested []*descriptorpb.DescriptorProto, f *descriptorpb.FieldDescriptorProto) int {
+	if f.GetLabel() != descriptorpb.FieldDescriptorProto_LABEL_REPEATED || f.Type != nil && f.GetType() != descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+		return -1
+	}
+	i := nestedType(scope, nested, f.GetTypeName())
+	if i < 0 || !nested[i].GetOptions().GetMapEntry() {
+		return -1
+	}
+	return i
+}
+
+// groupType returns the index among nested of the message of the group f,
+// declared in scope, or -1 if f is not a group.
+func groupType(scope string, nested []*descriptorpb.DescriptorProto, f *descriptorpb.FieldDescriptorProto) int {
+	if f.GetType() != descriptorpb.FieldDescriptorProto_TYPE_GROUP {
+		return -1
+	}
+	return nestedType(scope, nested, f.GetTypeName())
+}
+
+// nestedType returns the index of the message named typeName among
+// nested, which are declared in scope, or -1.
+func nestedType(scope string, nested []*descriptorpb.DescriptorProto, typeName string) int {
+	for i, n := range nested {
+		if typeName == "."+join(scope, n.GetName()) || typeName == n.GetName() {
+			return i
+		}
+	}
+	return -1
+}
+
+// entryField returns the field of the map entry message with number n.
+func entryField(entry *descriptorpb.DescriptorProto, n int32) *descriptorpb.FieldDescriptorProto {
+	for _, f := range entry.Field {
+		if f.GetNumber() == n {
+			return f
+		}
+	}
+	return nil
+}
+
+// extensionRangeItems returns the extensions statements of the message m,
+// declared as scope at path.
+func (p *printer) extensionRangeItems(scope string, m *descriptorpb.DescriptorProto, path []int32, max int32) []item {
+	var items []item
+	rs := m.ExtensionRange
+	for _, st := range p.statements(subpath(path, descriptor.MessageExtensionRangeTag), len(rs), func(i, j int) bool {
+		return proto.Equal(rs[i].GetOptions(), rs[j].GetOptions())
+	}) {
+		var ranges []string
+		for _, r := range rs[st.start:st.end] {
+			ranges = append(ranges, rangeText(r.GetStart(), r.GetEnd()-1, max))
+		}
+		opts, optsPath := rs[st.start].GetOptions(), subpath(path, descriptor.MessageExtensionRangeTag, int32(st.start), descriptor.RangeOptionsTag)
+		text := p.withOptions("extensions "+strings.Join(ranges, ", "), func(inline bool) []compactOption {
+			return p.compactOptions(opts, scope, optsPath, inline)
+		}, ";")
+		loc := st.loc
+		items = append(items, item{loc, func() { p.decl(loc, text, false) }})
+	}
+	return items
+}
+
+// extendItems returns the extend blocks that declare the extensions exts,
+// declared in scope at path. The messages of groups are among nested, at
+// nestedPath.
+func (p *printer) extendItems(scope string, exts []*descriptorpb.FieldDescriptorProto, path []int32, nested []*descriptorpb.DescriptorProto, nestedPath []int32) []item {
+	var items []item
+	for _, st := range p.statements(path, len(exts), func(i, j int) bool {
+		return exts[i].GetExtendee() == exts[j].GetExtendee()
+	}) {
+		st := st
+		items = append(items, item{st.loc, func() {
+			p.decl(st.loc, "extend "+p.typeName(exts[st.start].GetExtendee(), scope)+" {", true)
+			var fields []item
+			for i := st.start; i < st.end; i++ {
+				f, fieldPath := exts[i], subpath(path, int32(i))
+				fields = append(fields, item{p.loc(fieldPath), func() { p.field(scope, f, fieldPath, nested, nestedPath, false) }})
+			}
+			p.items(fields)
+			p.close()
+		}})
+	}
+	return items
+}
+
+// reservedItems returns the reserved statements of the inclusive ranges
+// and of the names, which are at rangePath and namePath.
+func (p *printer) reservedItems(rangePath []int32, ranges [][2]int32, max int32, namePath []int32, names []string) []item {
+	var items []item
+	all := func(i, j int) bool { return true }
+	for _, st := range p.statements(rangePath, len(ranges), all) {
+		var rs []string
+		for _, r := range ranges[st.start:st.end] {
+			rs = append(rs, rangeText(r[0], r[1], max))
+		}
+		text := "reserved " + strings.Join(rs, ", ") + ";"
+		loc := st.loc
+		items = append(items, item{loc, func() { p.decl(loc, text, false) }})
+	}
+	for _, st := range p.statements(namePath, len(names), all) {
+		var ns []string
+		for _, n := range names[st.start:st.end] {
+			ns = append(ns, quote(n))
+		}
+		text := "reserved " + strings.Join(ns, ", ") + ";"
+		loc := st.loc
+		items = append(items, item{loc, func() { p.decl(loc, text, false) }})
+	}
+	return items
+}
+
+// A statement declares the elements start to end of a list.
+type statement struct {
+	loc        *descriptorpb.SourceCodeInfo_Location // or nil
+	start, end int
+}
+
+// statements returns the statements that declare the n elements of the
+// list at listPath, such as the ranges of reserved statements. Each of
+// them has a location at listPath, which contains the locations of the
+// elements it declares. Elements that have no such location are declared
+// together with the elements that follow them for which same reports
+// true.
+func (p *printer) statements(listPath []int32, n int, same func(i, j int) bool) []statement {
+	stmts := p.locs[pathKey(listPath)]
+	stmt := func(i int) int {
+		if loc := p.loc(subpath(listPath, int32(i))); loc != nil {
+			for k, s := range stmts {
+				if spanContains(s.Span, loc.Span) {
+					return k
+				}
+			}
+		}
+		return -1
+	}
+
+	var list []statement
+	for i := 0; i < n; {
+		j := i + 1
+		var loc *descriptorpb.SourceCodeInfo_Location
+		if k := stmt(i); k >= 0 {
+			loc = stmts[k]
+			for j < n && stmt(j) == k {
+				j++
+			}
+		} else {
+			loc = p.loc(subpath(listPath, int32(i)))
+			for j < n && stmt(j) < 0 && same(i, j) {
+				j++
+			}
+		}
+		list = append(list, statement{loc, i, j})
+		i = j
+	}
+	return list
+}
+
+// rangeText returns the inclusive range start to end, written as in a
+// reserved or extensions statement.
+func rangeText(start, end, max int32) string {
+	switch {
+	case start == end:
+		return strconv.Itoa(int(start))
+	case end == max:
+		return strconv.Itoa(int(start)) + " to max"
+	}
+	return strconv.Itoa(int(start)) + " to " + strconv.Itoa(int(end))
+}
+
+// enum writes the enum e, declared in scope at path.
+func (p *printer) enum(scope string, e *descriptorpb.EnumDescriptorProto, path []int32) {
+	p.decl(p.loc(path), "enum "+e.GetName()+" {", true)
+	items := p.optionItems(e.Options, scope, subpath(path, descriptor.EnumOptionsTag))
+	for i, v := range e.Value {
+		v, vPath := v, subpath(path, descriptor.EnumValueTag, int32(i))
+		text := p.withOptions(v.GetName()+cellMark+" = "+strconv.Itoa(int(v.GetNumber())), func(inline bool) []compactOption {
+			return p.compactOptions(v.Options, scope, subpath(vPath, descriptor.EnumValueOptionsTag), inline)
+		}, ";")
+		loc := p.loc(vPath)
+		items = append(items, item{loc, func() { p.decl(loc, text, false) }})
+	}
+	var ranges [][2]int32
+	for _, r := range e.ReservedRange {
+		ranges = append(ranges, [2]int32{r.GetStart(), r.GetEnd()})
+	}
+	items = append(items, p.reservedItems(subpath(path, descriptor.EnumReservedRangeTag), ranges, math.MaxInt32, subpath(path, descriptor.EnumReservedNameTag), e.ReservedName)...)
+	p.items(items)
+	p.close()
+}
+
+// service writes the service s, declared in scope at path.
+func (p *printer) service(scope string, s *descriptorpb.ServiceDescriptorProto, path []int32) {
+	name := join(scope, s.GetName())
+	p.decl(p.loc(path), "service "+s.GetName()+" {", true)
+	items := p.optionItems(s.Options, scope, subpath(path, descriptor.ServiceOptionsTag))
+	for i, md := range s.Method {
+		md, mPath := md, subpath(path, descriptor.ServiceMethodTag, int32(i))
+		items = append(items, item{p.loc(mPath), func() { p.method(name, md, mPath) }})
+	}
+	p.items(items)
+	p.close()
+}
+
+// method writes the method md of the service scope, at path.
+func (p *printer) method(scope string, md *descriptorpb.MethodDescriptorProto, path []int32) {
+	text := "rpc " + md.GetName() +
+		"(" + stream(md.GetClientStreaming()) + p.typeName(md.GetInputType(), scope) + ")" +
+		" returns (" + stream(md.GetServerStreaming()) + p.typeName(md.GetOutputType(), scope) + ")"
+	opts := p.optionItems(md.Options, scope, subpath(path, descriptor.MethodOptionsTag))
+	if len(opts) == 0 {
+		p.decl(p.loc(path), text+";", false)
+		return
+	}
+	p.decl(p.loc(path), text+" {", true)
+	p.items(opts)
+	p.close()
+}
+
+func stream(streaming bool) string {
+	if streaming {
+		return "stream "
+	}
+	return ""
+}
+
+func contains(s []int32, i int) bool {
+	for _, e := range s {
+		if int(e) == i {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package printer
+
+import (
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// A symbolKind is a set of the properties of a symbol that matter when
+// names are looked up.
+type symbolKind uint8
+
+const (
+	aggregateSymbol symbolKind = 1 << iota // may contain other symbols
+	typeSymbol                             // a message or an enum
+	fieldSymbol                            // a field or an extension
+)
+
+// symbols maps the fully-qualified names, without the leading dot, of the
+// symbols that are known to a file to their kinds: those it declares,
+// and those it refers to, with their enclosing scopes.
+type symbols map[string]symbolKind
+
+func join(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+// fileSymbols returns the symbols known to fd.
+func fileSymbols(fd *descriptorpb.FileDescriptorProto) symbols {
+	s := make(symbols)
+	scope := fd.GetPackage()
+	if scope != "" {
+		s.addScopes(scope + ".")
+	}
+	for _, m := range fd.MessageType {
+		s.addMessage(scope, m)
+	}
+	for _, e := range fd.EnumType {
+		s[join(scope, e.GetName())] |= aggregateSymbol | typeSymbol
+	}
+	s.addFields(scope, fd.Extension)
+	for _, sd := range fd.Service {
+		s[join(scope, sd.GetName())] |= aggregateSymbol
+		for _, md := range sd.Method {
+			s.addRef(md.GetInputType(), typeSymbol)
+			s.addRef(md.GetOutputType(), typeSymbol)
+		}
+	}
+	return s
+}
+
+func (s symbols) addMessage(scope string, m *descriptorpb.DescriptorProto) {
+	name := join(scope, m.GetName())
+	s[name] |= aggregateSymbol | typeSymbol
+	s.addFields(name, m.Field)
+	s.addFields(name, m.Extension)
+	for _, n := range m.NestedType {
+		s.addMessage(name, n)
+	}
+	for _, e := range m.EnumType {
+		s[join(name, e.GetName())] |= aggregateSymbol | typeSymbol
+	}
+}
+
+func (s symbols) addFields(scope string, fields []*descriptorpb.FieldDescriptorProto) {
+	for _, f := range fields {
+		s[join(scope, f.GetName())] |= fieldSymbol
+		s.addRef(f.GetTypeName(), typeSymbol)
+		s.addRef(f.GetExtendee(), typeSymbol)
+	}
+}
+
+// addRef adds the symbol of kind k that the fully-qualified name refers
+// to, together with its enclosing scopes. Names that are not qualified
+// are ignored.
+func (s symbols) addRef(name string, k symbolKind) {
+	if !strings.HasPrefix(name, ".") {
+		return
+	}
+	s[name[1:]] |= k
+	s.addScopes(name[1:])
+}
+
+// addScopes adds the scopes that enclose the symbol name.
+func (s symbols) addScopes(name string) {
+	for i := strings.LastIndexByte(name, '.'); i > 0; i = strings.LastIndexByte(name, '.') {
+		name = name[:i]
+		s[name] |= aggregateSymbol
+	}
+}
+
+// lookup returns the fully-qualified name that name refers to when it is
+// used in scope, following the protobuf scoping rules: the innermost
+// scope that defines the first component of name is the one that is
+// used. A name of a single component only matches symbols of kind want.
+func (s symbols) lookup(name, scope string, want symbolKind) string {
+	first := name
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		first = name[:i]
+	}
+	for scope != "" {
+		k := s[join(scope, first)]
+		if first != name && k&aggregateSymbol != 0 || first == name && k&want != 0 {
+			return join(scope, name)
+		}
+		i := strings.LastIndexByte(scope, '.')
+		if i < 0 {
+			i = 0
+		}
+		scope = scope[:i]
+	}
+	return name
+}
+
+// statementKeywords are the keywords that start a statement within a
+// message, an extend block or a method's parentheses, where a type name
+// may be written. A type name that starts with one of them would be read
+// as that statement.
+var statementKeywords = map[string]bool{
+	"message": true, "enum": true, "extensions": true, "extend": true,
+	"option": true, "oneof": true, "reserved": true, "group": true,
+	"optional": true, "required": true, "repeated": true, "stream": true,
+}
+
+// typeName returns the shortest name that refers to the type full, a
+// fully-qualified name, when it is used in scope.
+func (p *printer) typeName(full, scope string) string {
+	return p.shortName(full, scope, typeSymbol)
+}
+
+// shortName returns the shortest name that refers to the symbol full, of
+// kind want, when it is used in scope. Names that are not qualified, and
+// all names if KeepNames is set, are returned as they are.
+func (p *printer) shortName(full, scope string, want symbolKind) string {
+	if p.KeepNames || !strings.HasPrefix(full, ".") {
+		return full
+	}
+	parts := strings.Split(full[1:], ".")
+	for i := len(parts) - 1; i >= 0; i-- {
+		if statementKeywords[parts[i]] {
+			continue
+		}
+		name := strings.Join(parts[i:], ".")
+		if p.syms.lookup(name, scope, want) == full[1:] {
+			return name
+		}
+	}
+	return full
+}
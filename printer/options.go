@@ -0,0 +1,561 @@
+package printer
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"rogchap.com/protoparser/internal/descriptor"
+	"rogchap.com/protoparser/internal/parser"
+	"rogchap.com/protoparser/internal/scanner"
+	"rogchap.com/protoparser/internal/token"
+)
+
+// An option is a single value set in an options message: a value of one
+// of its fields or extensions, or an uninterpreted option.
+type option struct {
+	name  string  // as written after "option"
+	path  []int32 // within the options message
+	fd    protoreflect.FieldDescriptor
+	value protoreflect.Value
+	uo    *descriptorpb.UninterpretedOption // if not interpreted
+}
+
+// options returns the options set in opts, those of an element declared
+// in scope: the standard options in field order, then the custom options.
+func (p *printer) options(opts proto.Message, scope string) []option {
+	if opts == nil || !opts.ProtoReflect().IsValid() {
+		return nil
+	}
+	m := p.resolveExtensions(opts.ProtoReflect())
+
+	var list []option
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.Number() != descriptor.UninterpretedOptionField && m.Has(fd) {
+			list = appendOption(list, string(fd.Name()), fd, m.Get(fd))
+		}
+	}
+
+	var exts []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		if fd.IsExtension() {
+			exts = append(exts, fd)
+		}
+		return true
+	})
+	sort.Slice(exts, func(i, j int) bool { return exts[i].Number() < exts[j].Number() })
+	for _, fd := range exts {
+		full := "." + string(fd.FullName())
+		p.syms.addRef(full, fieldSymbol)
+		list = appendOption(list, "("+p.shortName(full, scope, fieldSymbol)+")", fd, m.Get(fd))
+	}
+
+	if fd := fields.ByNumber(descriptor.UninterpretedOptionField); fd != nil {
+		l := m.Get(fd).List()
+		for i := 0; i < l.Len(); i++ {
+			uo, ok := l.Get(i).Message().Interface().(*descriptorpb.UninterpretedOption)
+			if !ok {
+				// decoded with the built-in descriptor.proto
+				uo = new(descriptorpb.UninterpretedOption)
+				b, err := proto.Marshal(l.Get(i).Message().Interface())
+				if err == nil {
+					err = proto.Unmarshal(b, uo)
+				}
+				if err != nil {
+					p.setError(err)
+					continue
+				}
+			}
+			var parts []string
+			for _, part := range uo.Name {
+				if part.GetIsExtension() {
+					parts = append(parts, "("+part.GetNamePart()+")")
+				} else {
+					parts = append(parts, part.GetNamePart())
+				}
+			}
+			list = append(list, option{name: strings.Join(parts, "."), path: []int32{descriptor.UninterpretedOptionField, int32(i)}, uo: uo})
+		}
+	}
+	return list
+}
+
+// appendOption appends the option name, set to v in the field fd, to list;
+// each element of a repeated field is a separate option.
+func appendOption(list []option, name string, fd protoreflect.FieldDescriptor, v protoreflect.Value) []option {
+	if !fd.IsList() {
+		return append(list, option{name: name, path: []int32{int32(fd.Number())}, fd: fd, value: v})
+	}
+	l := v.List()
+	for i := 0; i < l.Len(); i++ {
+		list = append(list, option{name: name, path: []int32{int32(fd.Number()), int32(i)}, fd: fd, value: l.Get(i)})
+	}
+	return list
+}
+
+// resolveExtensions returns m, with the unknown fields that are extensions
+// declared in the file, or known to the Resolver, decoded as such, as are
+// those that are fields of the options message that the runtime lacks,
+// such as debug_redact. Fields that remain unknown cannot be printed, so
+// the first of them is recorded as the error of the printer.
+func (p *printer) resolveExtensions(m protoreflect.Message) protoreflect.Message {
+	if len(m.GetUnknown()) == 0 {
+		return m
+	}
+	b, err := proto.Marshal(m.Interface())
+	if err != nil {
+		p.setError(err)
+		return m
+	}
+	n := m.New()
+	if err := (proto.UnmarshalOptions{Resolver: p.types}).Unmarshal(b, n.Interface()); err != nil {
+		p.setError(err)
+		return m
+	}
+	if len(n.GetUnknown()) > 0 {
+		// fields of the options message that the runtime lacks
+		md := parser.BuiltinDescriptor().Messages().ByName(m.Descriptor().Name())
+		if md != nil {
+			d := dynamicpb.NewMessage(md)
+			if err := (proto.UnmarshalOptions{Resolver: p.types}).Unmarshal(b, d); err != nil {
+				p.setError(err)
+				return m
+			}
+			n = d
+		}
+	}
+	if unknown := n.GetUnknown(); len(unknown) > 0 {
+		num, _, _ := protowire.ConsumeTag(unknown)
+		p.setError(fmt.Errorf("%s: option %d of %s cannot be printed: its extension is neither declared in the file nor known to the Resolver",
+			p.fd.GetName(), num, m.Descriptor().FullName()))
+	}
+	return n
+}
+
+// fileExtensions returns the types of the extensions declared in fd whose
+// values can be decoded: those that extend, and whose values are, messages
+// and enums declared in fd or in protoregistry.GlobalFiles.
+func fileExtensions(fd *descriptorpb.FileDescriptorProto) *protoregistry.Types {
+	types := new(protoregistry.Types)
+	f, err := protodesc.FileOptions{AllowUnresolvable: true}.New(fd, protoregistry.GlobalFiles)
+	if err != nil {
+		return types
+	}
+	add := func(exts protoreflect.ExtensionDescriptors) {
+		for i := 0; i < exts.Len(); i++ {
+			x := exts.Get(i)
+			if x.ContainingMessage().IsPlaceholder() ||
+				x.Message() != nil && x.Message().IsPlaceholder() ||
+				x.Enum() != nil && x.Enum().IsPlaceholder() {
+				continue
+			}
+			types.RegisterExtension(dynamicpb.NewExtensionType(x))
+		}
+	}
+	var addMessages func(msgs protoreflect.MessageDescriptors)
+	addMessages = func(msgs protoreflect.MessageDescriptors) {
+		for i := 0; i < msgs.Len(); i++ {
+			add(msgs.Get(i).Extensions())
+			addMessages(msgs.Get(i).Messages())
+		}
+	}
+	add(f.Extensions())
+	addMessages(f.Messages())
+	return types
+}
+
+// resolvers finds extension types with the first of its Resolvers that
+// knows them.
+type resolvers []Resolver
+
+func (rs resolvers) FindExtensionByName(field protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	for _, r := range rs {
+		if xt, err := r.FindExtensionByName(field); err == nil {
+			return xt, nil
+		}
+	}
+	return nil, protoregistry.NotFound
+}
+
+func (rs resolvers) FindExtensionByNumber(message protoreflect.FullName, field protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
+	for _, r := range rs {
+		if xt, err := r.FindExtensionByNumber(message, field); err == nil {
+			return xt, nil
+		}
+	}
+	return nil, protoregistry.NotFound
+}
+
+// optionItems returns the option statements of opts, the options of an
+// element declared in scope, which are at path.
+func (p *printer) optionItems(opts proto.Message, scope string, path []int32) []item {
+	var items []item
+	for _, o := range p.options(opts, scope) {
+		text := "option " + o.name + " = " + p.optionValue(o, true) + ";"
+		if p.width(text) > maxWidth {
+			text = "option " + o.name + " = " + p.optionValue(o, false) + ";"
+		}
+		loc := p.loc(subpath(path, o.path...))
+		items = append(items, item{loc, func() { p.decl(loc, text, false) }})
+	}
+	return items
+}
+
+// A compactOption is an option as written in brackets after a field or an
+// enum value.
+type compactOption struct {
+	loc  *descriptorpb.SourceCodeInfo_Location // or nil
+	text string
+}
+
+// compactOptions returns the options of opts, those of an element declared
+// in scope, which are at path. Message values are written on a single line
+// if inline is set.
+func (p *printer) compactOptions(opts proto.Message, scope string, path []int32, inline bool) []compactOption {
+	var list []compactOption
+	for _, o := range p.options(opts, scope) {
+		list = append(list, compactOption{p.loc(subpath(path, o.path...)), o.name + " = " + p.optionValue(o, inline)})
+	}
+	return list
+}
+
+// withOptions returns the text of a declaration, followed by its compact
+// options, which opts returns, and by end. The options are written in the
+// order of their source, if the file has source information. If they do
+// not fit on a line, each option is written on a line of its own.
+func (p *printer) withOptions(text string, opts func(inline bool) []compactOption, end string) string {
+	sorted := func(inline bool) []string {
+		list := opts(inline)
+		if p.fd.SourceCodeInfo != nil {
+			sort.SliceStable(list, func(i, j int) bool { return locBefore(list[i].loc, list[j].loc) })
+		}
+		var texts []string
+		for _, o := range list {
+			texts = append(texts, o.text)
+		}
+		return texts
+	}
+	list := sorted(true)
+	if len(list) == 0 {
+		return text + end
+	}
+	if s := text + " [" + strings.Join(list, ", ") + "]" + end; p.width(s) <= maxWidth {
+		return s
+	}
+	var b strings.Builder
+	b.WriteString(text + " [\n")
+	list = sorted(false)
+	for i, o := range list {
+		if i > 0 {
+			b.WriteString(",\n")
+		}
+		b.WriteString(p.Indent + strings.ReplaceAll(o, "\n", "\n"+p.Indent))
+	}
+	b.WriteString("\n]" + end)
+	return b.String()
+}
+
+// optionValue returns the value of o. Message values are written in the
+// text format, on a single line if inline is set.
+func (p *printer) optionValue(o option, inline bool) string {
+	if o.uo == nil {
+		return p.value(o.fd, o.value, inline)
+	}
+	uo := o.uo
+	switch {
+	case uo.IdentifierValue != nil:
+		return uo.GetIdentifierValue()
+	case uo.PositiveIntValue != nil:
+		return strconv.FormatUint(uo.GetPositiveIntValue(), 10)
+	case uo.NegativeIntValue != nil:
+		return strconv.FormatInt(uo.GetNegativeIntValue(), 10)
+	case uo.DoubleValue != nil:
+		return formatFloat(uo.GetDoubleValue(), 64)
+	case uo.StringValue != nil:
+		return quote(string(uo.StringValue))
+	case uo.AggregateValue != nil:
+		return p.aggregateText(uo.GetAggregateValue(), inline)
+	}
+	return ""
+}
+
+// aggregateText returns agg, the text of a message value as the parser
+// keeps it, between braces and laid out as aggregate lays out messages.
+// The tokens of agg are kept as they are.
+func (p *printer) aggregateText(agg string, inline bool) string {
+	src := []byte("{" + agg + "}")
+	var s scanner.Scanner
+	s.Init(token.NewFile("", len(src)), src, nil, 0)
+
+	var b strings.Builder
+	prev := token.ILLEGAL
+	depth, list := 0, 0
+	newline := func(depth int) {
+		b.WriteByte('\n')
+		b.WriteString(strings.Repeat(p.Indent, depth))
+	}
+	for {
+		_, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if lit == "" {
+			lit = tok.String()
+		}
+		opening := prev == token.LBRACE || prev == token.LANGLE
+		closing := tok == token.RBRACE || tok == token.RANGLE
+		if closing {
+			depth--
+		}
+		switch {
+		case prev == token.ILLEGAL:
+		case opening && closing:
+		case !inline && opening:
+			newline(depth)
+		case !inline && closing:
+			newline(depth)
+		case !inline && list == 0 && startsField(prev, tok):
+			newline(depth)
+		case tok == token.COLON, tok == token.COMMA, tok == token.SEMICOLON,
+			tok == token.RBRACK, tok == token.DOT, tok == token.SLASH:
+		case prev == token.LBRACK, prev == token.DOT, prev == token.SLASH, prev == token.MINUS:
+		default:
+			b.WriteByte(' ')
+		}
+		b.WriteString(lit)
+		switch tok {
+		case token.LBRACE, token.LANGLE:
+			depth++
+		case token.LBRACK:
+			list++
+		case token.RBRACK:
+			list--
+		}
+		prev = tok
+	}
+	if s.ErrorCount > 0 {
+		return "{ " + agg + " }"
+	}
+	return b.String()
+}
+
+// startsField reports whether tok, which follows prev in a message value
+// written in the text format, starts the name of a field.
+func startsField(prev, tok token.Token) bool {
+	if tok != token.IDENT && !tok.IsKeyword() && tok != token.LBRACK {
+		return false
+	}
+	switch prev {
+	case token.IDENT, token.INT, token.FLOAT, token.STRING, token.RBRACK,
+		token.RBRACE, token.RANGLE, token.COMMA, token.SEMICOLON:
+		return true
+	}
+	return prev.IsKeyword()
+}
+
+// value returns the value v of the field fd, written as an option value or
+// in the text format.
+func (p *printer) value(fd protoreflect.FieldDescriptor, v protoreflect.Value, inline bool) string {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return strconv.FormatBool(v.Bool())
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return strconv.Itoa(int(v.Enum()))
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return strconv.FormatInt(v.Int(), 10)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return strconv.FormatUint(v.Uint(), 10)
+	case protoreflect.FloatKind:
+		return formatFloat(v.Float(), 32)
+	case protoreflect.DoubleKind:
+		return formatFloat(v.Float(), 64)
+	case protoreflect.StringKind:
+		return quote(v.String())
+	case protoreflect.BytesKind:
+		return quoteBytes(v.Bytes())
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return p.aggregate(v.Message(), inline)
+	}
+	return ""
+}
+
+// aggregate returns the message m in the text format, between braces.
+func (p *printer) aggregate(m protoreflect.Message, inline bool) string {
+	var fields []string
+	add := func(fd protoreflect.FieldDescriptor) {
+		name := string(fd.Name())
+		switch {
+		case fd.IsExtension():
+			name = "[" + string(fd.FullName()) + "]"
+		case fd.Kind() == protoreflect.GroupKind:
+			name = string(fd.Message().Name())
+		}
+		v := m.Get(fd)
+		switch {
+		case fd.IsMap():
+			var keys []protoreflect.MapKey
+			v.Map().Range(func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+				keys = append(keys, k)
+				return true
+			})
+			sort.Slice(keys, func(i, j int) bool { return mapKeyLess(keys[i], keys[j]) })
+			for _, k := range keys {
+				entry := []string{
+					p.textField("key", fd.MapKey(), k.Value(), inline),
+					p.textField("value", fd.MapValue(), v.Map().Get(k), inline),
+				}
+				fields = append(fields, name+" "+p.textBlock(entry, inline))
+			}
+		case fd.IsList():
+			l := v.List()
+			for i := 0; i < l.Len(); i++ {
+				fields = append(fields, p.textField(name, fd, l.Get(i), inline))
+			}
+		default:
+			fields = append(fields, p.textField(name, fd, v, inline))
+		}
+	}
+
+	fds := m.Descriptor().Fields()
+	for i := 0; i < fds.Len(); i++ {
+		if fd := fds.Get(i); m.Has(fd) {
+			add(fd)
+		}
+	}
+	var exts []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		if fd.IsExtension() {
+			exts = append(exts, fd)
+		}
+		return true
+	})
+	sort.Slice(exts, func(i, j int) bool { return exts[i].Number() < exts[j].Number() })
+	for _, fd := range exts {
+		add(fd)
+	}
+	return p.textBlock(fields, inline)
+}
+
+// textField returns the field name set to v in the text format.
+func (p *printer) textField(name string, fd protoreflect.FieldDescriptor, v protoreflect.Value, inline bool) string {
+	if fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind {
+		return name + " " + p.aggregate(v.Message(), inline)
+	}
+	return name + ": " + p.value(fd, v, inline)
+}
+
+// textBlock returns the fields of a message in the text format, between
+// braces, either on a single line or on a line each.
+func (p *printer) textBlock(fields []string, inline bool) string {
+	if len(fields) == 0 {
+		return "{}"
+	}
+	if inline {
+		return "{ " + strings.Join(fields, " ") + " }"
+	}
+	var b strings.Builder
+	b.WriteString("{\n")
+	for _, f := range fields {
+		for _, l := range strings.Split(f, "\n") {
+			b.WriteString(p.Indent + l + "\n")
+		}
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func mapKeyLess(a, b protoreflect.MapKey) bool {
+	switch a.Interface().(type) {
+	case string:
+		return a.String() < b.String()
+	case bool:
+		return !a.Bool() && b.Bool()
+	case int32, int64:
+		return a.Int() < b.Int()
+	}
+	return a.Uint() < b.Uint()
+}
+
+// formatFloat returns f as a floating-point literal.
+func formatFloat(f float64, bitSize int) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, bitSize)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0" // not an integer
+	}
+	return s
+}
+
+// quote returns s as a string literal. Printable characters are written
+// as they are, and other bytes are escaped.
+func quote(s string) string {
+	return quoteString(s, true)
+}
+
+// quoteBytes returns b as a string literal, in which every byte that is
+// not printable ASCII is escaped.
+func quoteBytes(b []byte) string {
+	return quoteString(string(b), false)
+}
+
+func quoteString(s string, text bool) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if text && c >= utf8.RuneSelf {
+			r, n := utf8.DecodeRuneInString(s[i:])
+			if (r != utf8.RuneError || n > 1) && unicode.IsPrint(r) {
+				b.WriteString(s[i : i+n])
+				i += n - 1
+				continue
+			}
+		}
+		switch c {
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		default:
+			if c < ' ' || c >= 0x7f {
+				fmt.Fprintf(&b, `\%03o`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,460 @@
+// Package printer implements printing of file descriptors as .proto
+// source.
+package printer
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// A Config controls the output of Fprint.
+type Config struct {
+	// Indent is the indentation of each level of nesting. If empty, two
+	// spaces are used.
+	Indent string
+
+	// AlignNumbers aligns the "=" of the fields and enum values declared
+	// on consecutive lines, so that their numbers line up.
+	AlignNumbers bool
+
+	// KeepNames writes the names of types as they are in the descriptor,
+	// rather than as the shortest names that refer to the same types. It
+	// is meant for descriptors that are parsed but not linked, whose names
+	// are as written in the source.
+	KeepNames bool
+
+	// Resolver finds the extensions that define the custom options set in
+	// the file, other than those that the file declares itself, such as
+	// those of the files it imports. A file decoded without them holds such
+	// options as unknown fields of its options messages. If nil,
+	// protoregistry.GlobalTypes is used. An option whose extension cannot
+	// be found cannot be printed, and is reported as an error.
+	Resolver Resolver
+}
+
+// A Resolver finds extension types by name and by number. It is
+// implemented by protoregistry.Types.
+type Resolver interface {
+	FindExtensionByName(field protoreflect.FullName) (protoreflect.ExtensionType, error)
+	FindExtensionByNumber(message protoreflect.FullName, field protoreflect.FieldNumber) (protoreflect.ExtensionType, error)
+}
+
+// Fprint writes the .proto source of fd to w.
+//
+// If fd has a SourceCodeInfo, such as that of a file parsed with the
+// IncludeSourceInfo mode, declarations are written in the order of their
+// source, together with their comments. Otherwise they are written in the
+// order of the descriptor. Map fields are written as such rather than as
+// their entry messages, and the messages of groups within their groups.
+// Type names are written as short as they can be while still referring
+// to the same type. Files of syntax "editions" cannot be printed, as the
+// parser does not support editions.
+func (cfg *Config) Fprint(w io.Writer, fd *descriptorpb.FileDescriptorProto) error {
+	return cfg.FprintCommented(w, fd, nil)
+}
+
+// A Comment is a comment of the source of a file that its SourceCodeInfo
+// does not record, as it belongs to no declaration: one at the end of a
+// block or of the file, or one within a declaration.
+type Comment struct {
+	Span []int32 // as in a SourceCodeInfo location
+	Text string  // as in a SourceCodeInfo location
+}
+
+// FprintCommented is like Fprint, but also writes comments, which are in
+// the source of fd but not in its SourceCodeInfo, in source order. Each of
+// them is written on lines of its own, where it is in the source: within
+// the innermost block that contains it, and before the declaration that
+// contains it or follows it. A comment that follows a block on the line
+// that closes it is written on that line.
+func (cfg *Config) FprintCommented(w io.Writer, fd *descriptorpb.FileDescriptorProto, comments []Comment) error {
+	p := newPrinter(cfg, fd)
+	p.comments = comments
+	p.file()
+	p.flushComments(nil, false)
+	if p.err != nil {
+		return p.err
+	}
+	_, err := w.Write(p.align())
+	return err
+}
+
+// Fprint writes the .proto source of fd to w, using the default Config.
+func Fprint(w io.Writer, fd *descriptorpb.FileDescriptorProto) error {
+	return (&Config{}).Fprint(w, fd)
+}
+
+type printer struct {
+	Config
+	fd    *descriptorpb.FileDescriptorProto
+	syms  symbols
+	types Resolver                                           // of the extensions that options may use
+	err   error                                              // the first error found
+	locs  map[string][]*descriptorpb.SourceCodeInfo_Location // by path
+
+	buf     bytes.Buffer
+	depth   int       // nesting level
+	blank   bool      // the last line written is blank, or nothing was written
+	open    bool      // a block was opened, and nothing written in it yet
+	openEnd int       // end of the line that opened the block
+	sep     bool      // the next declaration is set apart by a blank line
+	last    int32     // source line of the end of the last declaration, or -1
+	ends    [][]int32 // source spans of the open blocks, or nil
+	cells   []int     // offsets in buf at which lines are aligned
+
+	comments []Comment // not yet written
+}
+
+func newPrinter(cfg *Config, fd *descriptorpb.FileDescriptorProto) *printer {
+	p := &printer{
+		Config: *cfg,
+		fd:     fd,
+		syms:   fileSymbols(fd),
+		locs:   make(map[string][]*descriptorpb.SourceCodeInfo_Location),
+		blank:  true,
+		last:   -1,
+	}
+	if p.Indent == "" {
+		p.Indent = "  "
+	}
+	if p.Resolver == nil {
+		p.Resolver = protoregistry.GlobalTypes
+	}
+	p.types = resolvers{fileExtensions(fd), p.Resolver}
+	for _, loc := range fd.GetSourceCodeInfo().GetLocation() {
+		key := pathKey(loc.Path)
+		p.locs[key] = append(p.locs[key], loc)
+	}
+	return p
+}
+
+// setError records err as the error of the printer, unless an error was
+// recorded before it.
+func (p *printer) setError(err error) {
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// loc returns the first location recorded at path, or nil. Statements
+// that declare several elements, such as extend blocks, each have another
+// location at the path of the list of elements they declare.
+func (p *printer) loc(path []int32) *descriptorpb.SourceCodeInfo_Location {
+	if locs := p.locs[pathKey(path)]; len(locs) > 0 {
+		return locs[0]
+	}
+	return nil
+}
+
+func pathKey(path []int32) string {
+	b := make([]byte, 0, 4*len(path))
+	for _, e := range path {
+		b = append(b, byte(e>>24), byte(e>>16), byte(e>>8), byte(e))
+	}
+	return string(b)
+}
+
+// subpath returns a new path of path followed by elems.
+func subpath(path []int32, elems ...int32) []int32 {
+	return append(append(make([]int32, 0, len(path)+len(elems)), path...), elems...)
+}
+
+// maxWidth is the width of the lines beyond which compact options and
+// option values are broken across several lines.
+const maxWidth = 80
+
+// width returns the width of the line s when it is written at the current
+// level of nesting.
+func (p *printer) width(s string) int {
+	return p.depth*len(p.Indent) + utf8.RuneCountInString(s) - strings.Count(s, cellMark)
+}
+
+// cellMark marks the point of the first line of the text of a declaration
+// at which it is aligned with the declarations on the lines around it.
+const cellMark = "\v"
+
+// line writes s on a line of its own, indented.
+func (p *printer) line(s string) {
+	if s != "" {
+		for i := 0; i < p.depth; i++ {
+			p.buf.WriteString(p.Indent)
+		}
+		p.buf.WriteString(s)
+	}
+	p.buf.WriteByte('\n')
+	p.blank = s == ""
+}
+
+// blankLine writes a blank line, unless one was just written.
+func (p *printer) blankLine() {
+	if !p.blank {
+		p.line("")
+	}
+}
+
+// comment writes text, a comment as recorded in a SourceCodeInfo, as line
+// comments.
+func (p *printer) comment(text string) {
+	text = strings.TrimSuffix(text, "\n")
+	for _, l := range strings.Split(text, "\n") {
+		p.line(strings.TrimRight("//"+l, " \t"))
+	}
+}
+
+// decl writes a declaration, text, which may span several lines, together
+// with the comments of its location loc, which may be nil. The lines that
+// follow a block declaration, whose text ends with the "{" that opens its
+// body, are indented until the block is closed.
+//
+// Declarations are set apart by blank lines where they are in the source,
+// as recorded by the spans of their locations. Without them, a block is
+// set apart from the declarations around it. The comments are laid out so
+// that protoc attributes them to the same declarations: detached comments
+// are set apart by blank lines, and a trailing comment of several lines is
+// followed by one.
+func (p *printer) decl(loc *descriptorpb.SourceCodeInfo_Location, text string, block bool) {
+	if loc == nil {
+		loc = new(descriptorpb.SourceCodeInfo_Location)
+	}
+	if span := span4(loc.Span); span != nil {
+		// The comments within a declaration that contains no others are
+		// written before it, set apart so that they stay apart from it
+		// and from the one before.
+		pos := span[2:]
+		if block {
+			pos = span[:2]
+		}
+		if p.flushComments(pos, true) {
+			p.sep = true
+		}
+	}
+	start, end := spanLines(loc.Span)
+	known := start >= 0 && p.last >= 0
+	if known {
+		// keep a blank line that sets the declaration apart in the source
+		lead := int32(0)
+		if loc.LeadingComments != nil {
+			lead = int32(strings.Count(strings.TrimSuffix(loc.GetLeadingComments(), "\n"), "\n") + 1)
+		}
+		if start-lead-p.last > 1 {
+			p.sep = true
+		}
+	}
+	if !p.open && (block && !known || p.sep) {
+		p.blankLine()
+	}
+	p.open, p.sep = false, false
+
+	for _, c := range loc.GetLeadingDetachedComments() {
+		p.blankLine()
+		p.comment(c)
+	}
+	if len(loc.GetLeadingDetachedComments()) > 0 {
+		p.blankLine()
+	}
+	if loc.LeadingComments != nil {
+		p.comment(loc.GetLeadingComments())
+	}
+
+	lines := strings.Split(text, "\n")
+	cell := strings.Index(lines[0], cellMark)
+	if cell >= 0 {
+		lines[0] = lines[0][:cell] + lines[0][cell+len(cellMark):]
+	}
+	trailing := strings.TrimSuffix(loc.GetTrailingComments(), "\n")
+	long := strings.Contains(trailing, "\n")
+	if loc.TrailingComments != nil && !long {
+		last := len(lines) - 1
+		lines[last] = strings.TrimRight(lines[last]+"  //"+trailing, " \t")
+	}
+	for i, l := range lines {
+		if i == 0 && cell >= 0 {
+			p.cells = append(p.cells, p.buf.Len()+p.depth*len(p.Indent)+cell)
+		}
+		p.line(l)
+	}
+
+	p.last = end
+	if block {
+		p.depth++
+		p.ends = append(p.ends, span4(loc.Span))
+		p.last = start
+	}
+	if long {
+		p.comment(trailing)
+		p.sep = true
+	}
+	if block && !long {
+		p.open = loc.TrailingComments == nil
+		p.openEnd = p.buf.Len()
+	}
+}
+
+// close ends the block of the last block declaration. An empty block is
+// closed on the line that opened it.
+func (p *printer) close() {
+	span := p.ends[len(p.ends)-1]
+	p.ends = p.ends[:len(p.ends)-1]
+	if span != nil {
+		p.flushComments(span[2:], false)
+	}
+	p.depth--
+	if p.open && p.buf.Len() == p.openEnd {
+		p.buf.Truncate(p.buf.Len() - 1)
+		p.buf.WriteString("}\n")
+	} else {
+		p.line("}")
+	}
+	p.open, p.sep, p.last = false, span == nil, -1
+	if span == nil {
+		return
+	}
+	p.last = span[2]
+	if len(p.comments) > 0 {
+		// a comment that follows the block on its last line
+		c := p.comments[0]
+		if cs := span4(c.Span); cs != nil && cs[0] == span[2] && !strings.Contains(strings.TrimSuffix(c.Text, "\n"), "\n") {
+			p.buf.Truncate(p.buf.Len() - 1)
+			p.buf.WriteString(strings.TrimRight("  //"+strings.TrimSuffix(c.Text, "\n"), " \t") + "\n")
+			p.comments = p.comments[1:]
+		}
+	}
+}
+
+// flushComments writes the comments, of those not yet written, that start
+// before pos, a line and a column, or all of them if pos is nil. If apart
+// is set, they are set apart from what comes before them. It reports
+// whether any were written.
+func (p *printer) flushComments(pos []int32, apart bool) bool {
+	n := 0
+	for _, c := range p.comments {
+		if pos != nil && !spanBefore(c.Span, pos) {
+			break
+		}
+		start, end := spanLines(c.Span)
+		if start >= 0 && p.last >= 0 && start-p.last > 1 {
+			p.sep = true
+		}
+		// a comment on the line after the one that opens a block would be
+		// read as its trailing comment
+		if n == 0 && (apart || p.open) || !p.open && p.sep {
+			p.blankLine()
+		}
+		p.open, p.sep = false, false
+		p.comment(c.Text)
+		p.last = end
+		n++
+	}
+	p.comments = p.comments[n:]
+	return n > 0
+}
+
+// align returns the output, with the declarations that have cells on
+// consecutive lines aligned at them if the AlignNumbers option is set.
+func (p *printer) align() []byte {
+	b := p.buf.Bytes()
+	if !p.AlignNumbers || len(p.cells) == 0 {
+		return b
+	}
+	lineStart := func(at int) int { return bytes.LastIndexByte(b[:at], '\n') + 1 }
+	lineEnd := func(at int) int { return at + bytes.IndexByte(b[at:], '\n') }
+	width := func(at int) int { return utf8.RuneCount(b[lineStart(at):at]) }
+
+	var out bytes.Buffer
+	prev := 0
+	for i := 0; i < len(p.cells); {
+		j := i + 1
+		for j < len(p.cells) && lineStart(p.cells[j]) == lineEnd(p.cells[j-1])+1 {
+			j++
+		}
+		max := 0
+		for _, at := range p.cells[i:j] {
+			if w := width(at); w > max {
+				max = w
+			}
+		}
+		for _, at := range p.cells[i:j] {
+			out.Write(b[prev:at])
+			out.WriteString(strings.Repeat(" ", max-width(at)))
+			prev = at
+		}
+		i = j
+	}
+	out.Write(b[prev:])
+	return out.Bytes()
+}
+
+// spanContains reports whether the span outer contains the span inner.
+func spanContains(outer, inner []int32) bool {
+	o, i := span4(outer), span4(inner)
+	if o == nil || i == nil {
+		return false
+	}
+	return (o[0] < i[0] || o[0] == i[0] && o[1] <= i[1]) &&
+		(o[2] > i[2] || o[2] == i[2] && o[3] >= i[3])
+}
+
+// span4 returns span in its four-element form, or nil if it is not valid.
+func span4(span []int32) []int32 {
+	switch len(span) {
+	case 3:
+		return []int32{span[0], span[1], span[0], span[2]}
+	case 4:
+		return span
+	}
+	return nil
+}
+
+// spanLines returns the lines at which span, a span of a SourceCodeInfo
+// location, starts and ends, or -1 if it is not valid.
+func spanLines(span []int32) (start, end int32) {
+	switch len(span) {
+	case 3:
+		return span[0], span[0]
+	case 4:
+		return span[0], span[2]
+	}
+	return -1, -1
+}
+
+// An item is a declaration of a block.
+type item struct {
+	loc   *descriptorpb.SourceCodeInfo_Location // or nil
+	print func()
+}
+
+// items writes the declarations of a block. If the file has source
+// information, they are written in the order of their source, with those
+// that have no source last.
+func (p *printer) items(items []item) {
+	if p.fd.SourceCodeInfo != nil {
+		sort.SliceStable(items, func(i, j int) bool { return locBefore(items[i].loc, items[j].loc) })
+	}
+	for _, it := range items {
+		it.print()
+	}
+}
+
+// locBefore reports whether the location a comes before b in the source.
+// Locations that are nil come after all others.
+func locBefore(a, b *descriptorpb.SourceCodeInfo_Location) bool {
+	if a == nil || b == nil {
+		return a != nil && b == nil
+	}
+	return spanBefore(a.Span, b.Span)
+}
+
+// spanBefore reports whether the span a starts before b.
+func spanBefore(a, b []int32) bool {
+	if len(a) < 2 || len(b) < 2 {
+		return len(a) >= 2 && len(b) < 2
+	}
+	return a[0] < b[0] || a[0] == b[0] && a[1] < b[1]
+}
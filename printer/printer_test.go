@@ -0,0 +1,389 @@
+package printer_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	_ "google.golang.org/protobuf/types/known/apipb"
+	_ "google.golang.org/protobuf/types/known/structpb"
+	_ "google.golang.org/protobuf/types/pluginpb"
+
+	"rogchap.com/protoparser"
+	"rogchap.com/protoparser/printer"
+)
+
+// Sources that are written the way the printer writes them, so that
+// printing their descriptors gives them back.
+var canonical = []struct {
+	name string
+	src  string
+}{
+	{
+		name: "proto3",
+		src: `// Detached comment about the file.
+
+syntax = "proto3";
+
+// The package.
+package foo.bar;
+
+import "google/protobuf/empty.proto";
+import public "google/protobuf/timestamp.proto";
+
+option go_package = "example.com/foo/bar";
+option java_multiple_files = true;
+
+// A message.
+message Foo {
+  option deprecated = true;
+
+  string name = 1;  // the name
+  repeated int32 ids = 2 [packed = false];
+  map<string, Bar> bars = 3;
+
+  // Either one.
+  oneof choice {
+    Bar bar = 5;
+    google.protobuf.Empty empty = 6;
+  }
+
+  message Bar {
+    Kind kind = 1;
+    Bar next = 2 [json_name = "nextBar"];
+  }
+
+  enum Kind {
+    option allow_alias = true;
+
+    KIND_UNSPECIFIED = 0;
+    KIND_DEFAULT = 0 [deprecated = true];
+    KIND_OTHER = 1;
+
+    reserved 5, 10 to max;
+    reserved "KIND_OLD";
+  }
+
+  reserved 8, 15 to 20;
+  reserved "old", "older";
+}
+
+message Empty {}
+
+service Service {
+  option deprecated = true;
+
+  rpc Get(Foo) returns (Foo.Bar);
+  rpc Watch(stream google.protobuf.Empty) returns (stream Foo) {
+    option idempotency_level = NO_SIDE_EFFECTS;
+  }
+}
+`,
+	},
+	{
+		name: "proto2",
+		src: `syntax = "proto2";
+
+package foo;
+
+import "google/protobuf/descriptor.proto";
+
+message Rule {
+  optional string name = 1;
+  repeated int32 ids = 2;
+}
+
+extend google.protobuf.MessageOptions {
+  optional Rule rule = 50000;
+  repeated string labels = 50001;
+}
+
+message Foo {
+  option (rule) = {
+    name: "a rule with a name that is too long to fit on a single line"
+    ids: [1, 2, 3]
+  };
+  option (labels) = "a";
+
+  optional string name = 1 [default = "\"foo\"\n"];
+  optional double ratio = 2 [default = inf];
+  optional bytes data = 3 [default = "\000\001\377"];
+  required Kind kind = 4 [default = KIND_ONE];
+
+  repeated group Result = 5 {
+    optional string url = 6;
+
+    optional group Inner = 7 {}
+  }
+
+  enum Kind {
+    KIND_ONE = 1;
+  }
+
+  extensions 100 to 199, 300;
+  extensions 1000 to max;
+
+  extend Foo {
+    optional int32 bar = 100;
+  }
+}
+
+extend Foo {
+  optional string baz = 101 [
+    deprecated = true,
+    json_name = "bazWithAVeryLongNameThatNeedsItsOwnLine"
+  ];
+}
+`,
+	},
+}
+
+func TestFprintSource(t *testing.T) {
+	for _, tt := range canonical {
+		t.Run(tt.name, func(t *testing.T) {
+			p := protoparser.Parser{Mode: protoparser.IncludeSourceInfo}
+			fd, err := p.ParseFile("test.proto", tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := printer.Fprint(&buf, fd); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.src, buf.String()); diff != "" {
+				t.Errorf("Fprint() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFprintWithoutSourceInfo(t *testing.T) {
+	for _, tt := range canonical {
+		t.Run(tt.name, func(t *testing.T) {
+			fd, err := protoparser.ParseFile("test.proto", tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := printer.Fprint(&buf, fd); err != nil {
+				t.Fatal(err)
+			}
+			got, err := protoparser.ParseFile("test.proto", buf.Bytes())
+			if err != nil {
+				t.Fatalf("parsing the printed file: %v\n%s", err, buf.String())
+			}
+			if !proto.Equal(fd, got) {
+				t.Errorf("printed file does not parse to the same descriptor:\n%s", buf.String())
+			}
+		})
+	}
+}
+
+// TestFprintDescriptors prints the descriptors of compiled files, which
+// have no source information, and parses them back.
+func TestFprintDescriptors(t *testing.T) {
+	fsys := make(fstest.MapFS)
+	want := make(map[string]*descriptorpb.FileDescriptorProto)
+	protoregistry.GlobalFiles.RangeFiles(func(f protoreflect.FileDescriptor) bool {
+		fd := protodesc.ToFileDescriptorProto(f)
+		var buf bytes.Buffer
+		if err := printer.Fprint(&buf, fd); err != nil {
+			t.Fatal(err)
+		}
+		fsys[f.Path()] = &fstest.MapFile{Data: buf.Bytes()}
+		if fd.Syntax == nil {
+			fd.Syntax = proto.String("proto2") // as it is printed
+		}
+		want[f.Path()] = fd
+		return true
+	})
+
+	for name := range want {
+		set, err := protoparser.ParseFS(fsys, name)
+		if err != nil {
+			t.Errorf("parsing the printed %s: %v", name, err)
+			continue
+		}
+		got := set.File[len(set.File)-1]
+		got.SourceCodeInfo = nil
+		if diff := cmp.Diff(want[name], got, protocmp.Transform()); diff != "" {
+			t.Errorf("%s mismatch (-want +got):\n%s", name, diff)
+		}
+	}
+}
+
+// TestFprintCustomOptions prints custom options that a decoded descriptor
+// holds as unknown fields, as those of a descriptor set written by protoc.
+func TestFprintCustomOptions(t *testing.T) {
+	fsys := fstest.MapFS{"test.proto": {Data: []byte(`syntax = "proto3";
+package foo;
+import "google/protobuf/descriptor.proto";
+message Rule { string name = 1; repeated Rule rules = 2; }
+extend google.protobuf.FieldOptions { Rule rule = 50000; }
+extend google.protobuf.MessageOptions { repeated string tags = 50001; }
+`)}}
+	set, err := protoparser.ParseFS(fsys, "test.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := new(protoregistry.Files)
+	for _, fd := range set.File {
+		f, err := protodesc.NewFile(fd, files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files.RegisterFile(f)
+	}
+	f, _ := files.FindFileByPath("test.proto")
+	types := new(protoregistry.Types)
+	for i := 0; i < f.Extensions().Len(); i++ {
+		types.RegisterExtension(dynamicpb.NewExtensionType(f.Extensions().Get(i)))
+	}
+
+	rule := func(name string) *dynamicpb.Message {
+		m := dynamicpb.NewMessage(f.Messages().ByName("Rule"))
+		m.Set(m.Descriptor().Fields().ByName("name"), protoreflect.ValueOfString(name))
+		return m
+	}
+	r := rule("a")
+	rules := r.Mutable(r.Descriptor().Fields().ByName("rules")).List()
+	rules.Append(protoreflect.ValueOfMessage(rule("b")))
+	fieldOpts := new(descriptorpb.FieldOptions)
+	fieldOpts.ProtoReflect().Set(extension(t, types, "foo.rule"), protoreflect.ValueOfMessage(r))
+	msgOpts := new(descriptorpb.MessageOptions)
+	tags := msgOpts.ProtoReflect().Mutable(extension(t, types, "foo.tags")).List()
+	tags.Append(protoreflect.ValueOfString("x"))
+	tags.Append(protoreflect.ValueOfString("y"))
+
+	fd := proto.Clone(set.File[len(set.File)-1]).(*descriptorpb.FileDescriptorProto)
+	fd.SourceCodeInfo = nil
+	fd.MessageType = append(fd.MessageType, &descriptorpb.DescriptorProto{
+		Name: proto.String("Foo"),
+		Field: []*descriptorpb.FieldDescriptorProto{{
+			Name:     proto.String("s"),
+			Number:   proto.Int32(1),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+			JsonName: proto.String("s"),
+			Options:  fieldOpts,
+		}},
+		Options: msgOpts,
+	})
+	// decode the descriptor as one that was read without the extensions
+	b, err := proto.Marshal(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd = new(descriptorpb.FileDescriptorProto)
+	if err := (proto.UnmarshalOptions{Resolver: new(protoregistry.Types)}).Unmarshal(b, fd); err != nil {
+		t.Fatal(err)
+	}
+
+	// as if the extensions were declared in a file that it imports
+	imported := proto.Clone(fd).(*descriptorpb.FileDescriptorProto)
+	imported.Extension = nil
+
+	const resolved = `message Foo {
+  option (tags) = "x";
+  option (tags) = "y";
+  string s = 1 [(rule) = { name: "a" rules { name: "b" } }];
+}
+`
+	tests := []struct {
+		name     string
+		fd       *descriptorpb.FileDescriptorProto
+		resolver printer.Resolver
+		want     string // or the error
+	}{
+		{name: "resolved", fd: imported, resolver: types, want: resolved},
+		{name: "declared in the file", fd: fd, want: resolved},
+		{
+			name: "unresolved",
+			fd:   imported,
+			want: "test.proto: option 50001 of google.protobuf.MessageOptions cannot be printed: its extension is neither declared in the file nor known to the Resolver",
+		},
+	}
+	for _, tt := range tests {
+		cfg := printer.Config{Resolver: tt.resolver}
+		var buf bytes.Buffer
+		if err := cfg.Fprint(&buf, tt.fd); err != nil {
+			if err.Error() != tt.want {
+				t.Errorf("%s: got error %q, want %q", tt.name, err, tt.want)
+			}
+			if buf.Len() > 0 {
+				t.Errorf("%s: wrote %d bytes despite an error", tt.name, buf.Len())
+			}
+			continue
+		}
+		got := buf.String()
+		if i := strings.Index(got, "message Foo"); i >= 0 {
+			got = got[i:]
+			if j := strings.Index(got, "\n}\n"); j >= 0 {
+				got = got[:j+3]
+			}
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("%s: Fprint() mismatch (-want +got):\n%s", tt.name, diff)
+		}
+	}
+}
+
+// TestFprintNewOptions prints options that set fields the runtime's
+// options messages lack, which a parsed file holds as unknown fields.
+func TestFprintNewOptions(t *testing.T) {
+	const src = `syntax = "proto3";
+
+message Foo {
+  string secret = 1 [
+    deprecated = true,
+    debug_redact = true,
+    retention = RETENTION_SOURCE,
+    targets = TARGET_TYPE_FILE,
+    targets = TARGET_TYPE_ENUM
+  ];
+}
+`
+	fd, err := protoparser.ParseFile("test.proto", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fd); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(src, buf.String()); diff != "" {
+		t.Errorf("Fprint() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFprintEditions(t *testing.T) {
+	fd := &descriptorpb.FileDescriptorProto{
+		Name:   proto.String("test.proto"),
+		Syntax: proto.String("editions"),
+	}
+	var buf bytes.Buffer
+	err := printer.Fprint(&buf, fd)
+	if want := "test.proto cannot be printed: editions are not supported"; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %s", err, want)
+	}
+	if buf.Len() > 0 {
+		t.Errorf("wrote %d bytes despite an error", buf.Len())
+	}
+}
+
+func extension(t *testing.T, types *protoregistry.Types, name protoreflect.FullName) protoreflect.ExtensionTypeDescriptor {
+	xt, err := types.FindExtensionByName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return xt.TypeDescriptor()
+}
@@ -1,19 +1,352 @@
-// Package TODO
-//
+// Package protoparser parses proto files into descriptors.
 package protoparser
 
 import (
+	"io"
+	"io/fs"
+	"path/filepath"
+
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
 	"google.golang.org/protobuf/types/descriptorpb"
 	"rogchap.com/protoparser/internal/parser"
+	"rogchap.com/protoparser/internal/scanner"
+)
+
+// Error describes a problem found in a proto file, together with its
+// source position.
+type Error = scanner.Error
+
+// ErrorList is a list of *Errors, sorted by source position.
+type ErrorList = scanner.ErrorList
+
+// A Note adds information to an Error about a related position.
+type Note = scanner.Note
+
+// A Snippet is the line of source that a diagnostic refers to. Its String
+// method marks the offending span with a caret.
+type Snippet = scanner.Snippet
+
+// PrintError prints err to w. If err is an ErrorList, each error is
+// followed by the source it refers to, with the offending span marked
+// as in
+//
+//	test.proto:3:13: expected field number, found ';'
+//	  int32 a = ;
+//	            ^
+//
+// and by its related notes.
+func PrintError(w io.Writer, err error) {
+	scanner.PrintError(w, err)
+}
+
+// Severity describes how serious a problem is.
+type Severity = scanner.Severity
+
+// The severities, from most to least serious. Only errors cause parsing
+// to fail.
+const (
+	SeverityError   = scanner.SeverityError
+	SeverityWarning = scanner.SeverityWarning
+	SeverityInfo    = scanner.SeverityInfo
+)
+
+// Diagnostic codes identify the kind of problem reported in Error.Code.
+// Codes are stable across releases.
+const (
+	CodeSyntax        = scanner.CodeSyntax
+	CodeMissingSyntax = scanner.CodeMissingSyntax
+	CodeNaming        = scanner.CodeNaming
+	CodeUnusedImport  = scanner.CodeUnusedImport
+	CodeInvalid       = scanner.CodeInvalid
+	CodeJSONName      = scanner.CodeJSONName
+	CodeImport        = scanner.CodeImport
+)
+
+// A Mode value is a set of flags (or 0).
+// They control optional parser functionality.
+type Mode = parser.Mode
+
+const (
+	// WarningsAsErrors reports warnings as errors, for strict builds.
+	WarningsAsErrors = parser.WarningsAsErrors
+
+	// ProtocErrors reports diagnostics the way protoc does: worded as
+	// protoc words the common errors, with columns counted as protoc
+	// counts them. Together with the "file:line:column: message" form of
+	// Error, this lets tools that parse protoc's output consume
+	// protoparser's errors unchanged.
+	ProtocErrors = parser.ProtocErrors
+
+	// IncludeSourceInfo populates the SourceCodeInfo of the parsed file
+	// with the location of every element, using the same paths and spans
+	// as protoc, so that code generators can map descriptors back to the
+	// source. The leading, trailing and detached comments of each
+	// declaration are attributed as protoc attributes them.
+	IncludeSourceInfo = parser.IncludeSourceInfo
+
+	// InterpretOptions interprets custom options, such as
+	// option (my_option) = 42, as protoc does once the extensions they set
+	// are known: their values are encoded into the unknown fields of the
+	// options messages, where generated code and protoreflect find them,
+	// rather than kept as uninterpreted options. It applies to files
+	// parsed together with their imports.
+	InterpretOptions = parser.InterpretOptions
 )
 
-// TODO: Should we provide so wrapper functions to make this easier for the caller?
-// for example:
-// Parse(filename string)
-// ParseReader(r io.Reader)
-// ParseBuffer(buf bytes.Buffer)
+// A Parser parses proto files.
+// The zero value is ready to use.
+type Parser struct {
+	// Mode controls optional parser functionality.
+	Mode Mode
+
+	// ImportPaths are the directories in which imported files are looked
+	// up, in order, as with protoc's -I flag. The well-known files that
+	// protoc ships, such as google/protobuf/timestamp.proto, are built in
+	// and need not be in any of them.
+	ImportPaths []string
+
+	// Accessor, if not nil, opens the file at path for reading. It is
+	// used to read both the files that are parsed and the files they
+	// import, so that they need not be on disk. If nil, os.Open is used.
+	// FSAccessor returns an Accessor for an fs.FS, such as an embed.FS.
+	Accessor func(path string) (io.ReadCloser, error)
+
+	// Resolver, if not nil, provides the imported files that are not found
+	// in the import paths, from descriptors that are already compiled. Set
+	// it to protoregistry.GlobalFiles to import the files of the generated
+	// Go packages linked into the program, so that only the files being
+	// edited need to be parsed from source.
+	Resolver protodesc.Resolver
+
+	// Report, if not nil, is called with every diagnostic found, in
+	// source order. This includes the warnings and informational
+	// diagnostics that don't cause parsing to fail.
+	Report func(*Error)
+}
+
+// config returns the configuration of p for the internal parser.
+func (p *Parser) config() parser.Config {
+	return parser.Config{
+		Mode:        p.Mode,
+		ImportPaths: p.ImportPaths,
+		Accessor:    p.Accessor,
+		Resolver:    p.Resolver,
+	}
+}
+
+// ParseFile is like the package function ParseFile, but uses the
+// configuration of p.
+//
+// If ImportPaths, Accessor or Resolver is set, the files that the file
+// imports are loaded too, from the first import path that contains them
+// or else from the Resolver, and the type names the file uses are
+// resolved against them. Names that cannot
+// be resolved, and imports that cannot be found, are reported as errors.
+// The Name of the returned descriptor is the path of the file relative
+// to the import path that contains it, as other files would import it.
+func (p *Parser) ParseFile(filename string, src interface{}) (*descriptorpb.FileDescriptorProto, error) {
+	var (
+		fd    *descriptorpb.FileDescriptorProto
+		diags ErrorList
+		err   error
+	)
+	if p.ImportPaths != nil || p.Accessor != nil || p.Resolver != nil {
+		conf := p.config()
+		fd, diags, err = conf.ParseFile(filename, src)
+	} else {
+		fd, diags, err = parser.ParseFile(filename, src, p.Mode)
+	}
+	if p.Report != nil {
+		for _, d := range diags {
+			p.Report(d)
+		}
+	}
+	return fd, err
+}
+
+// ParseFiles is like the package function ParseFiles, but uses the
+// configuration of p.
+func (p *Parser) ParseFiles(filenames ...string) (*descriptorpb.FileDescriptorSet, error) {
+	conf := p.config()
+	set, diags, err := conf.ParseFiles(filenames...)
+	if p.Report != nil {
+		for _, d := range diags {
+			p.Report(d)
+		}
+	}
+	return set, err
+}
+
+// ParseFS is like the package function ParseFS, but uses the
+// configuration of p. The Accessor of p is ignored.
+func (p *Parser) ParseFS(fsys fs.FS, filenames ...string) (*descriptorpb.FileDescriptorSet, error) {
+	q := *p
+	q.Accessor = FSAccessor(fsys)
+	return q.ParseFiles(filenames...)
+}
+
+// ParseToRegistry is like the package function ParseToRegistry, but uses
+// the configuration of p.
+func (p *Parser) ParseToRegistry(filenames ...string) (*protoregistry.Files, []protoreflect.FileDescriptor, error) {
+	conf := p.config()
+	files, fds, diags, err := conf.ParseToRegistry(filenames...)
+	if p.Report != nil {
+		for _, d := range diags {
+			p.Report(d)
+		}
+	}
+	return files, fds, err
+}
+
+// WriteDescriptorSet is like the package function WriteDescriptorSet, but
+// uses the configuration of p. In the IncludeSourceInfo mode, the files
+// have source info, as with protoc's --include_source_info.
+func (p *Parser) WriteDescriptorSet(w io.Writer, includeImports bool, filenames ...string) error {
+	conf := p.config()
+	diags, err := conf.WriteDescriptorSet(w, includeImports, filenames...)
+	if p.Report != nil {
+		for _, d := range diags {
+			p.Report(d)
+		}
+	}
+	return err
+}
+
+// ImportName returns the name by which the file filename is imported:
+// its path relative to the first of the ImportPaths of p that contains it,
+// or the path itself if there is none. It is the Name of the descriptor
+// that p parses from the file, and the name by which a plugin is asked to
+// generate code for it.
+func (p *Parser) ImportName(filename string) string {
+	conf := p.config()
+	return conf.ImportName(filename)
+}
+
+// Validate is like the package function Validate, but uses the Mode of p.
+// Report is called with every diagnostic found.
+func (p *Parser) Validate(set *descriptorpb.FileDescriptorSet) error {
+	diags := parser.Validate(set, p.Mode)
+	if p.Report != nil {
+		for _, d := range diags {
+			p.Report(d)
+		}
+	}
+	return diags.Errors().Err()
+}
 
-// ParseFile TODO
+// ParseFile parses the source of a single proto file and returns the
+// corresponding FileDescriptorProto.
+//
+// If src != nil, ParseFile parses the source from src and the filename is
+// only used when recording position information. The type of the argument
+// for the src parameter must be string, []byte, or io.Reader. If src ==
+// nil, ParseFile parses the file specified by filename. The Name of the
+// returned descriptor is filename relative to the current directory, the
+// name by which other files would import it.
+//
+// The parser does not stop at the first syntax error; it recovers at the
+// next statement and keeps going. If errors were found, ParseFile returns
+// a partial descriptor with everything that could be recovered, and an
+// ErrorList containing all of the errors. Warnings are not reported; use
+// a Parser to receive them.
 func ParseFile(filename string, src interface{}) (*descriptorpb.FileDescriptorProto, error) {
-	return parser.ParseFile(filename, src)
+	var p Parser
+	return p.ParseFile(filename, src)
+}
+
+// ParseFiles parses the proto files filenames, together with the files
+// they import, transitively, and returns them as a FileDescriptorSet that
+// can be passed to protodesc.NewFiles or used in a plugin request.
+//
+// Every file in the set comes after the files it imports, and each file
+// appears once, however many files import it. Imports are looked up
+// relative to the current directory; use a Parser to set ImportPaths.
+//
+// If a file can't be read, ParseFiles returns a nil set and the error.
+// Otherwise, if errors were found, it returns the partial descriptors of
+// every file and an ErrorList containing all of the errors.
+func ParseFiles(filenames ...string) (*descriptorpb.FileDescriptorSet, error) {
+	var p Parser
+	return p.ParseFiles(filenames...)
+}
+
+// ParseToRegistry parses the proto files filenames, together with the
+// files they import, and links them into protoreflect file descriptors.
+// It returns a registry containing every file, in which each file is
+// found by the name by which it is imported, and the descriptors of the
+// files filenames, in order.
+//
+// If errors were found, ParseToRegistry returns nil results and an
+// ErrorList containing all of the errors. Problems found while linking,
+// which protodesc reports without positions, are reported at the
+// declaration they concern.
+func ParseToRegistry(filenames ...string) (*protoregistry.Files, []protoreflect.FileDescriptor, error) {
+	var p Parser
+	return p.ParseToRegistry(filenames...)
+}
+
+// Validate checks the files of set, which may have been built by hand or
+// received from elsewhere rather than parsed, as ParseFiles checks the
+// files it parses: every element must be named by an identifier that no
+// other element of its scope, in any file of set, uses; every field must
+// have a label and a type that agrees with its type name; type names must
+// refer to declarations in the file or in the files it imports; and field
+// numbers, reserved ranges, JSON names and enum values must follow
+// protoc's rules. Every file must come after the files it imports.
+//
+// Unlike protodesc.NewFile, which stops at the first problem, Validate
+// returns an ErrorList of every error found, or nil. Errors are positioned
+// using the SourceCodeInfo of the file, if it has one.
+func Validate(set *descriptorpb.FileDescriptorSet) error {
+	var p Parser
+	return p.Validate(set)
+}
+
+// WriteDescriptorSet parses the proto files filenames, together with the
+// files they import, and writes them to w as a serialized
+// FileDescriptorSet, as protoc --descriptor_set_out writes it, for the
+// tools that consume such files. Custom options are interpreted, and the
+// set is marshalled deterministically.
+//
+// The files parsed from source are written as protoc writes them, without
+// the options of source retention. google/protobuf/descriptor.proto is
+// always parsed from source: if it is not found in the import paths, the
+// parser has that of protoc 27.0 built in. The other well-known files that
+// are not found in the import paths, such as
+// google/protobuf/timestamp.proto, are not parsed: their descriptors are
+// those compiled into the Go protobuf runtime that the program is built
+// with, which may come from another release of protobuf than the protoc
+// being compared with. With includeImports, the set may then differ from
+// protoc's in those files.
+//
+// Unless includeImports is set, the set holds only the files filenames;
+// otherwise it holds every file they import too, as with protoc's
+// --include_imports. Either way, every file comes after the files it
+// imports, so that the set can be passed to protodesc.NewFiles, and files
+// that do not depend on each other are in the order given. Use a Parser
+// in the IncludeSourceInfo mode to include source info.
+//
+// If errors were found, nothing is written and the error is an ErrorList
+// containing all of them.
+func WriteDescriptorSet(w io.Writer, includeImports bool, filenames ...string) error {
+	var p Parser
+	return p.WriteDescriptorSet(w, includeImports, filenames...)
+}
+
+// ParseFS is like ParseFiles, but reads the files, and the files they
+// import, from the file system fsys, such as an embed.FS holding a tree of
+// proto files. The file names are slash-separated paths in fsys.
+func ParseFS(fsys fs.FS, filenames ...string) (*descriptorpb.FileDescriptorSet, error) {
+	var p Parser
+	return p.ParseFS(fsys, filenames...)
+}
+
+// FSAccessor returns an Accessor that opens files in the file system
+// fsys, for use in a Parser.
+func FSAccessor(fsys fs.FS) func(path string) (io.ReadCloser, error) {
+	return func(path string) (io.ReadCloser, error) {
+		return fsys.Open(filepath.ToSlash(path))
+	}
 }
@@ -1 +1,137 @@
 package protoparser_test
+
+import (
+	"bytes"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"rogchap.com/protoparser"
+)
+
+func TestParseFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"proto/foo/foo.proto": {Data: []byte(`syntax = "proto3";
+package foo;
+import "bar/bar.proto";
+import "google/protobuf/empty.proto";
+message Foo { bar.Bar bar = 1; google.protobuf.Empty empty = 2; }
+`)},
+		"proto/bar/bar.proto": {Data: []byte("syntax = \"proto3\";\npackage bar;\nmessage Bar {}\n")},
+	}
+	p := protoparser.Parser{ImportPaths: []string{"proto"}}
+	set, err := p.ParseFS(fsys, "proto/foo/foo.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, fd := range set.File {
+		names = append(names, fd.GetName())
+	}
+	want := []string{"bar/bar.proto", "google/protobuf/empty.proto", "foo/foo.proto"}
+	if diff := cmp.Diff(want, names); diff != "" {
+		t.Errorf("files mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := protoparser.ParseFS(fsys, "missing.proto"); err == nil {
+		t.Error("got no error for a missing file")
+	}
+}
+
+func TestWriteDescriptorSet(t *testing.T) {
+	fsys := fstest.MapFS{
+		"foo.proto": {Data: []byte(`syntax = "proto2";
+package foo;
+import "bar.proto";
+import "google/protobuf/descriptor.proto";
+extend google.protobuf.FileOptions { optional string owner = 50000; }
+option (owner) = "me";
+message Foo { optional bar.Bar bar = 1; }
+`)},
+		"bar.proto": {Data: []byte("syntax = \"proto3\";\npackage bar;\nmessage Bar {}\n")},
+	}
+	p := protoparser.Parser{Accessor: protoparser.FSAccessor(fsys)}
+
+	tests := []struct {
+		includeImports bool
+		want           []string
+	}{
+		{false, []string{"foo.proto"}},
+		{true, []string{"bar.proto", "google/protobuf/descriptor.proto", "foo.proto"}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := p.WriteDescriptorSet(&buf, tt.includeImports, "foo.proto"); err != nil {
+			t.Fatal(err)
+		}
+		set := new(descriptorpb.FileDescriptorSet)
+		if err := proto.Unmarshal(buf.Bytes(), set); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, fd := range set.File {
+			names = append(names, fd.GetName())
+		}
+		if diff := cmp.Diff(tt.want, names); diff != "" {
+			t.Errorf("includeImports=%v: files mismatch (-want +got):\n%s", tt.includeImports, diff)
+		}
+
+		foo := set.File[len(set.File)-1]
+		if foo.Syntax != nil {
+			t.Errorf("got syntax %q for a proto2 file, want none", foo.GetSyntax())
+		}
+		if len(foo.Options.GetUninterpretedOption()) > 0 {
+			t.Errorf("got uninterpreted options %v", foo.Options.UninterpretedOption)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := p.WriteDescriptorSet(&buf, false, "missing.proto"); err == nil {
+		t.Error("got no error for a missing file")
+	}
+	if buf.Len() > 0 {
+		t.Errorf("wrote %d bytes despite an error", buf.Len())
+	}
+}
+
+func TestValidate(t *testing.T) {
+	fsys := fstest.MapFS{
+		"foo.proto": {Data: []byte(`syntax = "proto3";
+package foo;
+import "google/protobuf/empty.proto";
+message Foo { google.protobuf.Empty empty = 1; string name = 2; }
+`)},
+	}
+	set, err := protoparser.ParseFS(fsys, "foo.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := protoparser.Validate(set); err != nil {
+		t.Fatalf("unexpected error validating a parsed set: %v", err)
+	}
+
+	foo := set.File[len(set.File)-1]
+	foo.MessageType[0].Field[1].Number = foo.MessageType[0].Field[0].Number
+	foo.MessageType[0].Field[1].Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+	foo.MessageType[0].Field[1].TypeName = proto.String(".foo.Bar")
+	err = protoparser.Validate(set)
+	errs, ok := err.(protoparser.ErrorList)
+	if !ok {
+		t.Fatalf("got error %v, want an ErrorList", err)
+	}
+	var got []string
+	for _, e := range errs {
+		got = append(got, e.Error())
+	}
+	want := []string{
+		`foo.proto: ".foo.Bar" is not defined`,
+		`foo.proto: field number 1 has already been used in "foo.Foo" by field "empty"`,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("errors mismatch (-want +got):\n%s", diff)
+	}
+}